@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/agorman/repbak"
+)
+
+// daemonClient talks to the HTTP server of a running repbak daemon.
+type daemonClient struct {
+	baseURL string
+	client  *http.Client
+	auth    func(r *http.Request)
+}
+
+// newDaemonClient returns a client for the daemon configured in config. Credentials are taken from
+// the first configured token or user. With TLS the daemon must present the configured certificate and
+// the configured client certificate is presented to it.
+func newDaemonClient(config *repbak.Config) (*daemonClient, error) {
+	if config.HTTP == nil {
+		return nil, errors.New("HTTP server isn't configured")
+	}
+
+	// a daemon listening on every address is reached over loopback
+	host := config.HTTP.Addr
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	c := &daemonClient{
+		baseURL: fmt.Sprintf("http://%s", net.JoinHostPort(host, fmt.Sprint(config.HTTP.Port))),
+		client:  &http.Client{Timeout: 5 * time.Second},
+		auth:    func(r *http.Request) {},
+	}
+
+	if config.HTTP.TLS != nil {
+		transport, err := pinnedTransport(config.HTTP.TLS.CertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// a daemon with client_ca_file only accepts clients presenting a certificate
+		if config.HTTP.TLS.ClientCertFile != "" {
+			cert, err := tls.LoadX509KeyPair(config.HTTP.TLS.ClientCertFile, config.HTTP.TLS.ClientKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load client_cert_file: %w", err)
+			}
+			transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		}
+		c.baseURL = fmt.Sprintf("https://%s", net.JoinHostPort(host, fmt.Sprint(config.HTTP.Port)))
+		c.client.Transport = transport
+	}
+
+	if auth := config.HTTP.Auth; auth != nil {
+		if len(auth.Tokens) > 0 {
+			token := auth.Tokens[0].Token
+			c.auth = func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+		} else if len(auth.Users) > 0 {
+			user := auth.Users[0]
+			c.auth = func(r *http.Request) { r.SetBasicAuth(user.Username, user.Password) }
+		}
+	}
+
+	return c, nil
+}
+
+// get decodes the JSON response of path into v. Responses with a status other than 200 or one of
+// the allowed statuses are returned as errors.
+func (c *daemonClient) get(path string, v interface{}, allowed ...int) error {
+	return c.do(http.MethodGet, path, nil, v, allowed...)
+}
+
+// do sends body encoded as JSON to path with method and decodes the JSON response into v. A nil body
+// sends no body. Responses with a status other than 200 or one of the allowed statuses are returned
+// as errors.
+func (c *daemonClient) do(method, path string, body, v interface{}, allowed ...int) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.auth(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	ok := resp.StatusCode == http.StatusOK
+	for _, status := range allowed {
+		ok = ok || resp.StatusCode == status
+	}
+	if !ok {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("Unexpected status from %s %s: %s: %s", method, path, resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("Unexpected status from %s %s: %s", method, path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// pinnedTransport returns a transport that only trusts a server presenting the certificate in
+// certFile. The daemon is usually reached over loopback where the certificate's names don't match.
+func pinnedTransport(certFile string) (*http.Transport, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read cert_file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("No certificate found in %s", certFile)
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", certFile, err)
+	}
+
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			// the certificate is verified by VerifyPeerCertificate instead
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if len(rawCerts) == 0 || !bytes.Equal(rawCerts[0], block.Bytes) {
+					return errors.New("daemon didn't present the configured certificate")
+				}
+				return nil
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/agorman/repbak"
+	"github.com/namsral/flag"
+	log "github.com/sirupsen/logrus"
+)
+
+// commands are the repbak subcommands. Without a subcommand the daemon is started.
+var commands = map[string]func(args []string) error{
+	"stats":       statsCommand,
+	"run":         runCommand,
+	"jobs":        jobsCommand,
+	"history":     historyCommand,
+	"status":      statusCommand,
+	"restore":     restoreCommand,
+	"config":      configCommand,
+	"maintenance": maintenanceCommand,
+}
+
+// Exit statuses of the subcommands.
+const (
+	exitFailed = 1
+	exitError  = 2
+)
+
+// exitStatus is an error that exits the command with code.
+type exitStatus struct {
+	code int
+	err  error
+}
+
+func (e *exitStatus) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitStatus) Unwrap() error {
+	return e.err
+}
+
+// runCommand runs a backup in the foreground and exits 0 if it succeeded, 1 if it failed, and 2 if
+// it couldn't be run.
+//
+//	repbak run [-conf path] <job>
+func runCommand(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return &exitStatus{exitError, errors.New("Usage: repbak run [-conf path] <job>")}
+	}
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	db, err := repbak.OpenDB(config)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+	defer db.Close()
+
+	// notifications that fail are stored in the outbox and retried by the daemon
+	queue := repbak.NewDeliveryQueue(config, db)
+	notifier, closeNotifier := newNotifier(config, db, queue, nil)
+	defer closeNotifier()
+
+	rb := repbak.New(config, db, repbak.NewMySQLDumpDumper(config), notifier)
+
+	stat, err := rb.Backup(fs.Arg(0), repbak.TriggerManual)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	if stat.Error != nil {
+		if stat.Log != "" {
+			fmt.Fprintf(os.Stderr, "Run log: %s\n", stat.Log)
+		}
+		return &exitStatus{exitFailed, fmt.Errorf("Backup %s failed after %s: %w", stat.Name, stat.Duration.Round(time.Millisecond), stat.Error)}
+	}
+
+	fmt.Printf("Backup %s succeeded in %s\n", stat.Name, stat.Duration.Round(time.Millisecond))
+	for _, artifact := range stat.Artifacts {
+		fmt.Printf("Artifact: %s\n", artifact)
+	}
+	return nil
+}
+
+// jobsCommand prints the configured jobs and their next run times. The running daemon is asked if
+// the HTTP server is configured so running backups are shown.
+//
+//	repbak jobs [-conf path] [-format table|json]
+func jobsCommand(args []string) error {
+	fs := flag.NewFlagSet("jobs", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	format := fs.String("format", "table", "The output format: table or json")
+	fs.Parse(args)
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := fetchJobs(config)
+	if err != nil {
+		log.Debugf("Failed to query the daemon: %v", err)
+		jobs = repbak.New(config, nil, repbak.NewMySQLDumpDumper(config), nil).Jobs()
+	}
+
+	if *format == "json" {
+		return printJSON(jobs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tSCHEDULE\tNEXT RUN\tRUNNING SINCE")
+	for _, job := range jobs {
+		schedule := job.Schedule
+		if job.Timezone != "" {
+			schedule += " (" + job.Timezone + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", job.Name, schedule, formatTime(config, job.NextRun), formatTimePtr(config, job.RunningSince))
+	}
+	return w.Flush()
+}
+
+// historyCommand prints the stats of every job or the named job newest first. The running daemon is
+// asked if the HTTP server is configured since it holds the lock on the bolt database. Otherwise the
+// database is read directly.
+//
+//	repbak history [-conf path] [-format table|json] [-limit n] [job]
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	format := fs.String("format", "table", "The output format: table or json")
+	limit := fs.Int("limit", 20, "The number of stats to print for each job")
+	fs.Parse(args)
+
+	if *limit < 1 {
+		return errors.New("Invalid -limit: must be greater than 0")
+	}
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	if fs.NArg() > 0 {
+		names = []string{fs.Arg(0)}
+	} else {
+		for _, job := range repbak.New(config, nil, repbak.NewMySQLDumpDumper(config), nil).Jobs() {
+			names = append(names, job.Name)
+		}
+	}
+
+	stats, err := fetchHistory(config, names, *limit)
+	if err != nil {
+		log.Debugf("Failed to query the daemon: %v", err)
+
+		db, err := repbak.OpenDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		stats = nil
+		for _, name := range names {
+			page, err := db.Page(name, time.Time{}, *limit)
+			if err != nil {
+				return err
+			}
+			stats = append(stats, page...)
+		}
+	}
+
+	if stats == nil {
+		stats = []repbak.Stat{}
+	}
+
+	if *format == "json" {
+		return printJSON(stats)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tRUN ID\tRESULT\tSTART\tDURATION\tSIZE\tTRIGGER\tERROR")
+	for _, stat := range stats {
+		result := "success"
+		switch {
+		case stat.Skip:
+			result = "skipped"
+		case stat.Retried:
+			result = "retried"
+		case !stat.Success:
+			result = "failed"
+		}
+		size := stat.CompressedSize
+		if size == 0 {
+			size = stat.BytesWritten
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", stat.Name, stat.RunID, result, formatTime(config, stat.Start), stat.Duration.Round(time.Second), size, stat.Trigger, stat.ErrorMessage+stat.SkipReason)
+	}
+	return w.Flush()
+}
+
+// statusCommand prints the health of every job from the running daemon. It exits 1 if a job is
+// unhealthy and 2 if the daemon can't be reached.
+//
+//	repbak status [-conf path] [-format table|json]
+func statusCommand(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	format := fs.String("format", "table", "The output format: table or json")
+	fs.Parse(args)
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	client, err := newDaemonClient(config)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	var report repbak.HealthReport
+	if err := client.get("/health", &report, 503); err != nil {
+		return &exitStatus{exitError, fmt.Errorf("Failed to query the daemon: %w", err)}
+	}
+
+	var jobs []repbak.Job
+	if err := client.get("/api/v1/jobs", &jobs); err != nil {
+		return &exitStatus{exitError, fmt.Errorf("Failed to query the daemon: %w", err)}
+	}
+
+	nextRuns := make(map[string]time.Time)
+	for _, job := range jobs {
+		nextRuns[job.Name] = job.NextRun
+	}
+
+	if *format == "json" {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "JOB\tSTATE\tLAST RUN\tLAST SUCCESS\tNEXT RUN\tRUNNING SINCE\tREASON")
+		for _, job := range report.Jobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", job.Name, job.State, formatTimePtr(config, job.LastRun), formatTimePtr(config, job.LastSuccess), formatTime(config, nextRuns[job.Name]), formatTimePtr(config, job.RunningSince), job.Reason)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !report.Healthy {
+		return &exitStatus{exitFailed, errors.New("One or more backups are unhealthy")}
+	}
+	return nil
+}
+
+// fetchJobs gets the jobs from the running daemon.
+func fetchJobs(config *repbak.Config) ([]repbak.Job, error) {
+	client, err := newDaemonClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []repbak.Job
+	return jobs, client.get("/api/v1/jobs", &jobs)
+}
+
+// fetchHistory gets up to limit stats of each job in names from the running daemon. Every stat is
+// returned if limit is 0.
+func fetchHistory(config *repbak.Config, names []string, limit int) ([]repbak.Stat, error) {
+	client, err := newDaemonClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := limit
+	if limit == 0 {
+		pageSize = 100
+	}
+
+	var stats []repbak.Stat
+	for _, name := range names {
+		path := fmt.Sprintf("/api/v1/jobs/%s/stats?limit=%d", url.PathEscape(name), pageSize)
+
+		var page []repbak.Stat
+		if err := client.get(path, &page); err != nil {
+			return nil, err
+		}
+		stats = append(stats, page...)
+
+		for limit == 0 && len(page) == pageSize {
+			before := page[len(page)-1].Start.Format(time.RFC3339Nano)
+
+			page = nil
+			if err := client.get(path+"&before="+url.QueryEscape(before), &page); err != nil {
+				return nil, err
+			}
+			stats = append(stats, page...)
+		}
+	}
+	return stats, nil
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// formatTime formats t with the configured time_format or - if t is zero.
+func formatTime(config *repbak.Config, t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(config.TimeFormat)
+}
+
+// formatTimePtr formats t with the configured time_format or - if t is nil.
+func formatTimePtr(config *repbak.Config, t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return formatTime(config, *t)
+}
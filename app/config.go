@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/agorman/repbak"
+	"github.com/namsral/flag"
+)
+
+// configCommand runs the repbak config subcommands.
+//
+//	repbak config check [-conf path] [-smtp] [-quiet]
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return &exitStatus{exitError, errors.New("Usage: repbak config check [flags]")}
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	smtp := fs.Bool("smtp", false, "Connect and authenticate to the SMTP server")
+	quiet := fs.Bool("quiet", false, "Don't print the effective configuration")
+	fs.Parse(args[1:])
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return &exitStatus{exitFailed, fmt.Errorf("Invalid configuration %s: %w", *conf, err)}
+	}
+
+	failed := 0
+	for _, result := range config.Check(repbak.CheckOptions{SMTP: *smtp}) {
+		switch {
+		case result.Err == nil:
+			fmt.Printf("ok    %s\n", result.Name)
+		case result.Warning:
+			fmt.Printf("warn  %s: %v\n", result.Name, result.Err)
+		default:
+			fmt.Printf("fail  %s: %v\n", result.Name, result.Err)
+			failed++
+		}
+	}
+
+	if !*quiet {
+		data, err := config.MaskedYAML()
+		if err != nil {
+			return &exitStatus{exitFailed, err}
+		}
+		fmt.Printf("\n# effective configuration of %s\n%s", *conf, data)
+	}
+
+	if failed > 0 {
+		return &exitStatus{exitFailed, fmt.Errorf("%d configuration checks failed", failed)}
+	}
+
+	fmt.Fprintln(os.Stderr, "Configuration OK")
+	return nil
+}
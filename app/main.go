@@ -2,15 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
-	"time"
 
 	"github.com/agorman/repbak"
-	"github.com/etherlabsio/healthcheck/v2"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/namsral/flag"
 	log "github.com/sirupsen/logrus"
@@ -18,8 +18,27 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+
+				var status *exitStatus
+				if errors.As(err, &status) {
+					os.Exit(status.code)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	conf := flag.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
 	debug := flag.Bool("debug", false, "Log to STDOUT")
+	runLog := flag.String("log", "", "Print the captured log of the latest run of a backup and exit")
+	runID := flag.String("run", "", "The run log ID to print with -log instead of the latest")
+	outbox := flag.Bool("outbox", false, "Print the pending and dead lettered notifications and exit")
+	migrate := flag.Bool("migrate", false, "Copy the stats in the bolt database into the configured database and exit")
 	flag.Parse()
 
 	config, err := repbak.OpenConfig(*conf)
@@ -27,17 +46,59 @@ func main() {
 		log.Fatal(err)
 	}
 
-	db, err := repbak.NewBoltDB(config)
+	if *runLog != "" {
+		f, err := repbak.OpenRunLog(config, *runLog, *runID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *outbox {
+		if err := printOutbox(config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *migrate {
+		if err := migrateBolt(config); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := repbak.OpenDB(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	notifier := repbak.NewEmailNotifier(config)
+	// notifications that fail are stored in the outbox and retried
+	queue := repbak.NewDeliveryQueue(config, db)
+
+	metrics, err := repbak.NewMetrics(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	notifier, closeNotifier := newNotifier(config, db, queue, metrics)
+	defer func() { closeNotifier() }()
 
 	dumper := repbak.NewMySQLDumpDumper(config)
 
-	if !*debug {
+	output := config.LogOutput
+	if *debug {
+		output = "stdout"
+	}
+
+	switch output {
+	case "file":
 		logfile := &lumberjack.Logger{
 			Filename:   config.LogPath,
 			MaxSize:    1,
@@ -45,60 +106,144 @@ func main() {
 			MaxAge:     30,
 		}
 		log.SetOutput(logfile)
+	case "syslog":
+		hook := repbak.NewSyslogHook(config)
+		defer hook.Close()
+		log.AddHook(hook)
+		log.SetOutput(io.Discard)
+	case "journald":
+		hook := repbak.NewJournaldHook(config)
+		defer hook.Close()
+		log.AddHook(hook)
+		log.SetOutput(io.Discard)
 	}
 
+	queue.Start()
+	defer func() { queue.Stop() }()
+
 	rb := repbak.New(config, db, dumper, notifier)
+	rb.SetMetrics(metrics)
 	rb.Start()
 	defer rb.Stop()
 
 	errc := make(chan error, 1)
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// liveness check
+	// health checks, metrics, run logs and the JSON API
+	var server *repbak.Server
 	if config.HTTP != nil {
-		http.Handle("/live", healthcheck.Handler(
-			healthcheck.WithChecker(
-				"live", healthcheck.CheckerFunc(
-					func(ctx context.Context) error {
-						return nil
-					},
-				),
-			),
-		))
-
-		// latest backups successful check
-		http.Handle("/health", healthcheck.Handler(
-			healthcheck.WithTimeout(5*time.Second),
-			healthcheck.WithChecker(
-				"health", healthcheck.CheckerFunc(
-					func(ctx context.Context) error {
-						statMap, err := db.List()
-						if err != nil {
-							return err
-						}
-
-						for name, stats := range statMap {
-							if len(stats) > 0 && !stats[0].Success {
-								return fmt.Errorf("One more more backups failed including %s", name)
-							}
-						}
-
-						return nil
-					},
-				),
-			),
-		))
-
-		go func() { errc <- http.ListenAndServe(fmt.Sprintf("%s:%d", config.HTTP.Addr, config.HTTP.Port), nil) }()
-	}
-
-	select {
-	case s := <-sig:
-		log.Warnf("Received signal %s, exiting", s)
-		return
-	case e := <-errc:
-		log.Errorf("Run error: %s", e)
-		return
+		server = repbak.NewServer(config, db, rb, metrics)
+		go func() { errc <- server.ListenAndServe() }()
+		defer server.Shutdown(context.Background())
+	}
+
+	// reload replaces the config, notifiers and outbox queue. If the new config is rejected the
+	// current one keeps running.
+	var reloadMu sync.Mutex
+	reload := func() error {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+
+		newConfig, err := repbak.OpenConfig(*conf)
+		if err != nil {
+			return err
+		}
+
+		newQueue := repbak.NewDeliveryQueue(newConfig, db)
+		reloadedNotifier, newCloseNotifier := newNotifier(newConfig, db, newQueue, metrics)
+
+		if err := rb.Reload(newConfig, reloadedNotifier); err != nil {
+			newCloseNotifier()
+			return err
+		}
+
+		if httpListenerChanged(config.HTTP, newConfig.HTTP) {
+			log.Warn("Reload: changes to the http address and tls require a restart")
+		}
+		if newConfig.LogOutput != config.LogOutput || newConfig.LogPath != config.LogPath {
+			log.Warn("Reload: changes to log_output and log_path require a restart")
+		}
+
+		queue.Stop()
+		closeNotifier()
+		queue, closeNotifier = newQueue, newCloseNotifier
+		queue.Start()
+
+		if server != nil {
+			server.SetConfig(newConfig)
+		}
+		config = newConfig
+
+		return nil
+	}
+	if server != nil {
+		server.OnReload(reload)
+	}
+
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Infof("Received signal %s, reloading %s", s, *conf)
+				if err := reload(); err != nil {
+					log.Errorf("Reload failed, keeping the current configuration: %v", err)
+				}
+				continue
+			}
+			log.Warnf("Received signal %s, exiting", s)
+			return
+		case e := <-errc:
+			log.Errorf("Run error: %s", e)
+			return
+		}
+	}
+}
+
+// newNotifier returns the configured notifiers. Failed notifications are stored in queue and
+// delivery failures are counted by metrics. The returned func closes the notifiers.
+func newNotifier(config *repbak.Config, db repbak.DB, queue *repbak.DeliveryQueue, metrics *repbak.Metrics) (repbak.Notifier, func()) {
+	var notifiers repbak.MultiNotifier
+	var closers []func() error
+
+	if config.Email != nil {
+		notifiers = append(notifiers, queue.Wrap("email", metrics.Wrap("email", repbak.NewEmailNotifier(config))))
+	}
+	if config.Syslog != nil {
+		syslogNotifier := repbak.NewSyslogNotifier(config)
+		closers = append(closers, syslogNotifier.Close)
+		notifiers = append(notifiers, queue.Wrap("syslog", metrics.Wrap("syslog", syslogNotifier)))
+	}
+	if config.Journald != nil {
+		journaldNotifier := repbak.NewJournaldNotifier(config)
+		closers = append(closers, journaldNotifier.Close)
+		notifiers = append(notifiers, queue.Wrap("journald", metrics.Wrap("journald", journaldNotifier)))
+	}
+
+	closeAll := func() {
+		for _, close := range closers {
+			close()
+		}
+	}
+
+	if config.NotificationPolicy != nil {
+		return repbak.NewPolicyNotifier(config, db, notifiers), closeAll
+	}
+	return notifiers, closeAll
+}
+
+// httpListenerChanged reports whether the http server was added, removed, or given a new address or tls
+// configuration. These are only picked up on a restart while the auth options are applied by a reload.
+func httpListenerChanged(current, next *repbak.HTTP) bool {
+	if current == nil || next == nil {
+		return current != next
+	}
+	if current.Addr != next.Addr || current.Port != next.Port {
+		return true
+	}
+	if current.TLS == nil || next.TLS == nil {
+		return current.TLS != next.TLS
 	}
+	return current.TLS.CertFile != next.TLS.CertFile || current.TLS.KeyFile != next.TLS.KeyFile ||
+		current.TLS.ClientCAFile != next.TLS.ClientCAFile
 }
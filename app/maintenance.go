@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/agorman/repbak"
+	"github.com/namsral/flag"
+	log "github.com/sirupsen/logrus"
+)
+
+// maintenanceCommand enables, disables, or prints maintenance mode. The running daemon is asked if
+// it can be reached. Otherwise the stored state is changed and the daemon picks it up when it's
+// started.
+//
+//	repbak maintenance [-conf path] [-reason text] on|off|status
+func maintenanceCommand(args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	reason := fs.String("reason", "", "Why maintenance mode is enabled")
+	format := fs.String("format", "text", "The output format: text or json")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("Usage: repbak maintenance [-conf path] [-reason text] on|off|status")
+	}
+
+	var method string
+	var body interface{}
+	switch fs.Arg(0) {
+	case "on":
+		method = http.MethodPost
+		body = map[string]string{"reason": *reason}
+	case "off":
+		method = http.MethodDelete
+	case "status":
+		method = http.MethodGet
+	default:
+		return fmt.Errorf("Unknown maintenance command %q: must be on, off, or status", fs.Arg(0))
+	}
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return err
+	}
+
+	maintenance, err := daemonMaintenance(config, method, body)
+	if errors.Is(err, errDaemonUnreachable) {
+		log.Debugf("Failed to query the daemon: %v", err)
+		maintenance, err = storedMaintenance(config, fs.Arg(0), *reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		return printJSON(maintenance)
+	}
+
+	if !maintenance.Enabled {
+		fmt.Println("Maintenance mode is disabled")
+		return nil
+	}
+	fmt.Printf("Maintenance mode is enabled since %s", formatTimePtr(config, maintenance.Since))
+	if maintenance.Reason != "" {
+		fmt.Printf(": %s", maintenance.Reason)
+	}
+	fmt.Println()
+	return nil
+}
+
+// errDaemonUnreachable is returned when the daemon isn't configured or can't be reached.
+var errDaemonUnreachable = errors.New("daemon is unreachable")
+
+// daemonMaintenance sends a maintenance request with method to the running daemon.
+func daemonMaintenance(config *repbak.Config, method string, body interface{}) (repbak.Maintenance, error) {
+	var maintenance repbak.Maintenance
+
+	client, err := newDaemonClient(config)
+	if err != nil {
+		return maintenance, fmt.Errorf("%w: %v", errDaemonUnreachable, err)
+	}
+
+	err = client.do(method, "/api/v1/maintenance", body, &maintenance)
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return maintenance, fmt.Errorf("%w: %v", errDaemonUnreachable, err)
+	}
+	return maintenance, err
+}
+
+// storedMaintenance changes or loads the stored maintenance mode when the daemon isn't running.
+func storedMaintenance(config *repbak.Config, command, reason string) (repbak.Maintenance, error) {
+	if command == "status" {
+		return repbak.LoadMaintenance(config)
+	}
+
+	maintenance := repbak.Maintenance{Enabled: command == "on"}
+	if maintenance.Enabled {
+		now := time.Now()
+		maintenance.Since = &now
+		maintenance.Reason = reason
+	}
+	return maintenance, repbak.StoreMaintenance(config, maintenance)
+}
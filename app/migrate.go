@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/agorman/repbak"
+)
+
+// migrateBolt copies the stats in the bolt database under lib_path into the database selected by the
+// database driver. The daemon must be stopped since it holds the lock on the bolt database.
+func migrateBolt(config *repbak.Config) error {
+	if config.Database.Driver == "bolt" {
+		return errors.New("The database driver must be sqlite or postgres to migrate the bolt database")
+	}
+
+	src, err := repbak.NewBoltDB(config)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := repbak.OpenDB(config)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := repbak.MigrateStats(src, dst); err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied the bolt database into the %s database\n", config.Database.Driver)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/agorman/repbak"
+)
+
+// printOutbox prints the pending and dead lettered notifications. The running daemon is asked over
+// HTTP if the HTTP server is configured since it holds the lock on the database. Otherwise the
+// database is read directly.
+func printOutbox(config *repbak.Config) error {
+	status, err := fetchOutbox(config)
+	if err != nil {
+		db, err := repbak.OpenDB(config)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		status, err = repbak.ListOutbox(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tID\tNOTIFIER\tKIND\tATTEMPTS\tNEXT ATTEMPT\tLAST ERROR")
+	for _, delivery := range status.Pending {
+		fmt.Fprintf(w, "pending\t%s\t%s\t%s\t%d\t%s\t%s\n", delivery.ID, delivery.Notifier, delivery.Kind, delivery.Attempts, delivery.NextAttempt.Format(config.TimeFormat), delivery.LastError)
+	}
+	for _, delivery := range status.DeadLetters {
+		fmt.Fprintf(w, "dead\t%s\t%s\t%s\t%d\t-\t%s\n", delivery.ID, delivery.Notifier, delivery.Kind, delivery.Attempts, delivery.LastError)
+	}
+	return w.Flush()
+}
+
+// fetchOutbox gets the outbox status from the running daemon.
+func fetchOutbox(config *repbak.Config) (repbak.OutboxStatus, error) {
+	var status repbak.OutboxStatus
+
+	client, err := newDaemonClient(config)
+	if err != nil {
+		return status, err
+	}
+
+	return status, client.get("/outbox", &status)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/agorman/repbak"
+	"github.com/go-sql-driver/mysql"
+	"github.com/namsral/flag"
+	log "github.com/sirupsen/logrus"
+)
+
+// restoreCommand restores a backup into a database or writes it uncompressed to a file. The
+// checksum of the backup is verified first.
+//
+//	repbak restore [-conf path] [-backup id|latest|timestamp] [-dsn dsn | -to-file path] [-yes] [-skip-verify] <job>
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	id := fs.String("backup", "latest", "The backup to restore: latest, a run ID, an artifact file name, or a timestamp")
+	dsn := fs.String("dsn", "", "The database to restore into such as user:pass@tcp(127.0.0.1:3306)/db")
+	toFile := fs.String("to-file", "", "Write the uncompressed backup to this file instead of restoring it. Use - for STDOUT")
+	yes := fs.Bool("yes", false, "Restore without asking for confirmation")
+	skipVerify := fs.Bool("skip-verify", false, "Restore backups without a recorded checksum")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*dsn == "") == (*toFile == "") {
+		return &exitStatus{exitError, errors.New("Usage: repbak restore [-conf path] [-backup id] [-dsn dsn | -to-file path] [-yes] [-skip-verify] <job>")}
+	}
+	name := fs.Arg(0)
+
+	config, err := repbak.OpenConfig(*conf)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	// When more dumpers are added this can be generalized
+	dumper := repbak.NewMySQLDumpDumper(config)
+	if _, err := repbak.New(config, nil, dumper, nil).Job(name); err != nil {
+		return &exitStatus{exitError, err}
+	}
+	var restorer repbak.Restorer = dumper
+
+	stats, err := jobStats(config, name)
+	if err != nil {
+		if !*skipVerify {
+			return &exitStatus{exitError, fmt.Errorf("Failed to read the stats needed to verify the backup: %w", err)}
+		}
+		log.Warnf("Failed to read stats: %v", err)
+	}
+
+	backups, err := restorer.Backups(stats)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	backup, err := repbak.FindBackup(backups, *id)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	fmt.Fprintf(os.Stderr, "Backup: %s started %s (%d bytes)\n", backup.Path, backup.Start.Format(config.TimeFormat), backup.Size)
+
+	if backup.Checksum != "" || !*skipVerify {
+		if err := repbak.VerifyBackup(backup); err != nil {
+			return &exitStatus{exitFailed, err}
+		}
+		fmt.Fprintln(os.Stderr, "Checksum verified")
+	} else {
+		log.Warnf("Restoring %s without verifying its checksum", backup.Path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	r, err := repbak.OpenBackup(backup)
+	if err != nil {
+		return &exitStatus{exitFailed, err}
+	}
+	defer r.Close()
+
+	if *toFile != "" {
+		return restoreToFile(r, *toFile)
+	}
+
+	if !*yes && !confirm(fmt.Sprintf("Restore %s into %s? This overwrites its data.", backup.Path, maskDSN(*dsn))) {
+		return &exitStatus{exitError, errors.New("Restore canceled")}
+	}
+
+	start := time.Now()
+	if err := restorer.Restore(ctx, r, *dsn, os.Stderr); err != nil {
+		return &exitStatus{exitFailed, err}
+	}
+
+	fmt.Fprintf(os.Stderr, "Restored %s in %s\n", backup.Path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// restoreToFile writes the uncompressed backup from r to path. Existing files aren't overwritten.
+func restoreToFile(r io.Reader, path string) error {
+	if path == "-" {
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return &exitStatus{exitFailed, err}
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return &exitStatus{exitError, err}
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return &exitStatus{exitFailed, fmt.Errorf("Failed to write %s: %w", path, err)}
+	}
+
+	if err := f.Close(); err != nil {
+		return &exitStatus{exitFailed, err}
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	return nil
+}
+
+// jobStats returns every stat of the job name. The running daemon is asked if the HTTP server is
+// configured since it holds the lock on the bolt database. Otherwise the database is read directly.
+func jobStats(config *repbak.Config, name string) ([]repbak.Stat, error) {
+	stats, err := fetchHistory(config, []string{name}, 0)
+	if err == nil {
+		return stats, nil
+	}
+	log.Debugf("Failed to query the daemon: %v", err)
+
+	db, err := repbak.OpenDB(config)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var before time.Time
+	for {
+		page, err := db.Page(name, before, 100)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, page...)
+
+		if len(page) < 100 {
+			return stats, nil
+		}
+		before = page[len(page)-1].Start
+	}
+}
+
+// confirm asks the question on STDERR and returns true if the answer read from STDIN is yes.
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// maskDSN returns dsn without its password.
+func maskDSN(dsn string) string {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "the database"
+	}
+	cfg.Passwd = ""
+	return cfg.FormatDSN()
+}
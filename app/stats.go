@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/agorman/repbak"
+	"github.com/namsral/flag"
+)
+
+// statsCommand runs the repbak stats subcommands.
+//
+//	repbak stats export [-conf path] [-format jsonl|csv] [-job name] [-from time] [-to time] [-out path]
+//	repbak stats import [-conf path] [-format jsonl|csv] [path]
+func statsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: repbak stats export|import [flags]")
+	}
+
+	fs := flag.NewFlagSet("stats "+args[0], flag.ExitOnError)
+	conf := fs.String("conf", "/etc/repbak.yaml", "Path to the repbak configuration file")
+	format := fs.String("format", repbak.ExportJSONL, "The format of the stats: jsonl or csv")
+
+	switch args[0] {
+	case "export":
+		job := fs.String("job", "", "Only export the stats of this job")
+		from := fs.String("from", "", "Only export stats that started at or after this RFC 3339 time")
+		to := fs.String("to", "", "Only export stats that started before this RFC 3339 time")
+		out := fs.String("out", "", "The file to write the stats to instead of STDOUT")
+		fs.Parse(args[1:])
+
+		opts := repbak.ExportOptions{
+			Format: *format,
+			Job:    *job,
+		}
+
+		var err error
+		if opts.From, err = parseTimeFlag(*from); err != nil {
+			return fmt.Errorf("Invalid -from: %w", err)
+		}
+		if opts.To, err = parseTimeFlag(*to); err != nil {
+			return fmt.Errorf("Invalid -to: %w", err)
+		}
+
+		db, err := openStatsDB(*conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.Create(*out)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+
+		n, err := repbak.ExportStats(db, w, opts)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Exported %d stats\n", n)
+		return nil
+	case "import":
+		fs.Parse(args[1:])
+
+		var r io.Reader = os.Stdin
+		if fs.NArg() > 0 {
+			f, err := os.Open(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+
+		db, err := openStatsDB(*conf)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		n, err := repbak.ImportStats(db, r, *format)
+		if err != nil {
+			return fmt.Errorf("Imported %d stats before failing: %w", n, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Imported %d stats\n", n)
+		return nil
+	default:
+		return fmt.Errorf("Unknown stats command: %s", args[0])
+	}
+}
+
+// openStatsDB opens the database configured in the repbak configuration file at conf.
+func openStatsDB(conf string) (repbak.DB, error) {
+	config, err := repbak.OpenConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return repbak.OpenDB(config)
+}
+
+// parseTimeFlag parses an RFC 3339 time or returns the zero time if value is empty.
+func parseTimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
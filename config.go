@@ -3,9 +3,15 @@ package repbak
 import (
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
+	cron "github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -15,6 +21,9 @@ type Config struct {
 	// LogPath is the oath on disk where repbak log file. Defaults to /var/log/repbak.log.
 	LogPath string `yaml:"log_path"`
 
+	// LogOutput is where repbak logs are written. Valid outputs are: file, stdout, syslog, and journald. Defaults to file.
+	LogOutput string `yaml:"log_output"`
+
 	// LogLevel sets the level of logging. Valid levels are: panic, fatal, trace, debug, warn, info, and error. Defaults to error
 	LogLevel string `yaml:"log_level"`
 
@@ -26,12 +35,27 @@ type Config struct {
 	TimeFormat string `yaml:"time_format"`
 
 	// Retention is the number of logs and stats that are stored for each backup. If set to less than 0 no
-	// logs or stats are saved. Defaults to 7.
+	// logs or stats are saved. Defaults to 7 unless retention_max_age is set.
 	Retention int `yaml:"retention"`
 
+	// RetentionMaxAge is the optional maximum age of stored logs and stats such as 90d or 36h. If set
+	// without retention stats are only pruned by age.
+	RetentionMaxAge string `yaml:"retention_max_age"`
+	retentionMaxAge time.Duration
+
+	// PruneSchedule is the cron expression that defines when old stats are pruned in addition to after
+	// each backup. Defaults to 0 * * * *.
+	PruneSchedule string `yaml:"prune_schedule"`
+
+	Database  *Database  `yaml:"database"`
 	HTTP      *HTTP      `yaml:"http"`
 	MySQLDump *MySQLDump `yaml:"mysqldump"`
 	Email     *Email     `yaml:"email"`
+	Syslog    *Syslog    `yaml:"syslog"`
+	Journald  *Journald  `yaml:"journald"`
+
+	NotificationPolicy *NotificationPolicy `yaml:"notification_policy"`
+	Outbox             *Outbox             `yaml:"outbox"`
 }
 
 // validate both validates the configuration and sets the default options.
@@ -40,6 +64,14 @@ func (c *Config) validate() error {
 		c.LogPath = "/var/log/repbak.log"
 	}
 
+	switch c.LogOutput {
+	case "":
+		c.LogOutput = "file"
+	case "file", "stdout", "syslog", "journald":
+	default:
+		return fmt.Errorf("Invalid log_output: %s", c.LogOutput)
+	}
+
 	if c.LogLevel == "" {
 		c.LogLevel = "error"
 		log.SetLevel(log.ErrorLevel)
@@ -72,10 +104,43 @@ func (c *Config) validate() error {
 		c.TimeFormat = "Mon Jan 02 03:04:05 PM MST"
 	}
 
-	if c.Retention == 0 {
+	if c.RetentionMaxAge != "" {
+		var err error
+		c.retentionMaxAge, err = parseAge(c.RetentionMaxAge)
+		if err != nil {
+			return fmt.Errorf("Failed to parse retention_max_age: %w", err)
+		}
+	} else if c.Retention == 0 {
 		c.Retention = 7
 	}
 
+	if c.PruneSchedule == "" {
+		c.PruneSchedule = "0 * * * *"
+	}
+
+	if c.Database == nil {
+		c.Database = &Database{}
+	}
+
+	switch c.Database.Driver {
+	case "":
+		c.Database.Driver = "bolt"
+	case "bolt":
+	case "sqlite":
+		if c.Database.Path == "" {
+			c.Database.Path = filepath.Join(c.LibPath, "repbak.sqlite")
+		}
+	case "postgres":
+		if err := readSecretFile(&c.Database.DSN, c.Database.DSNFile, "database dsn"); err != nil {
+			return err
+		}
+		if c.Database.DSN == "" {
+			return errors.New("Missing required dsn entry for the postgres database")
+		}
+	default:
+		return fmt.Errorf("Invalid database driver: %s", c.Database.Driver)
+	}
+
 	if c.HTTP != nil {
 		if c.HTTP.Addr == "" {
 			c.HTTP.Addr = "127.0.0.1"
@@ -84,39 +149,70 @@ func (c *Config) validate() error {
 		if c.HTTP.Port == 0 {
 			c.HTTP.Port = 4060
 		}
-	}
 
-	if c.Email == nil {
-		return errors.New("Missing required email configuration")
+		if err := c.HTTP.validate(); err != nil {
+			return err
+		}
 	}
 
-	if c.Email.Host == "" {
-		return errors.New("Missing required host entry for email")
-	}
+	if c.Syslog != nil {
+		switch c.Syslog.Network {
+		case "":
+			if c.Syslog.Addr != "" {
+				return errors.New("Missing required network entry for syslog when addr is set")
+			}
+		case "unix", "unixgram", "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+			if c.Syslog.Addr == "" {
+				return errors.New("Missing required addr entry for syslog")
+			}
+		default:
+			return fmt.Errorf("Invalid syslog network: %s", c.Syslog.Network)
+		}
+
+		if c.Syslog.Tag == "" {
+			c.Syslog.Tag = "repbak"
+		}
 
-	if c.Email.Port == 0 {
-		c.Email.Port = 25
+		if c.Syslog.Facility == "" {
+			c.Syslog.Facility = "daemon"
+		}
+
+		facility, ok := syslogFacilities[c.Syslog.Facility]
+		if !ok {
+			return fmt.Errorf("Invalid syslog facility: %s", c.Syslog.Facility)
+		}
+		c.Syslog.facility = facility
 	}
 
-	// StartTLS takes presidence over SSL
-	if c.Email.StartTLS {
-		c.Email.SSL = false
+	if c.Journald != nil {
+		if c.Journald.Identifier == "" {
+			c.Journald.Identifier = "repbak"
+		}
 	}
 
-	if c.Email.Subject == "" {
-		c.Email.Subject = "Database Replication Failure"
+	if c.Email != nil {
+		if err := c.Email.validate(); err != nil {
+			return err
+		}
 	}
 
-	if c.Email.From == "" {
-		return errors.New("Missing required from entry for email")
+	if c.NotificationPolicy != nil {
+		// suppressed notifications are stored with the stats until they're sent in a digest
+		if c.Retention < 0 {
+			return errors.New("notification_policy requires retention to be 0 or greater")
+		}
+
+		if err := c.NotificationPolicy.validate(); err != nil {
+			return err
+		}
 	}
 
-	if len(c.Email.To) == 0 {
-		return errors.New("Missing required to entry for email")
+	if c.Outbox == nil {
+		c.Outbox = &Outbox{}
 	}
 
-	if c.Email.HistorySubject == "" {
-		c.Email.HistorySubject = "Database Backup History"
+	if err := c.Outbox.validate(); err != nil {
+		return err
 	}
 
 	if c.MySQLDump == nil {
@@ -139,8 +235,58 @@ func (c *Config) validate() error {
 		c.MySQLDump.ExecutablePath = "mysqldump"
 	}
 
+	if c.MySQLDump.Timezone != "" {
+		if _, err := time.LoadLocation(c.MySQLDump.Timezone); err != nil {
+			return fmt.Errorf("Invalid mysqldump timezone: %w", err)
+		}
+	}
+
+	if c.MySQLDump.Jitter != "" {
+		var err error
+		c.MySQLDump.jitter, err = time.ParseDuration(c.MySQLDump.Jitter)
+		if err != nil {
+			return fmt.Errorf("Failed to parse mysqldump jitter: %w", err)
+		}
+	}
+
+	for i := range c.MySQLDump.Blackouts {
+		if err := c.MySQLDump.Blackouts[i].validate(c.MySQLDump.Timezone); err != nil {
+			return fmt.Errorf("Invalid mysqldump blackout: %w", err)
+		}
+	}
+
+	if c.MySQLDump.RestoreExecutablePath == "" {
+		c.MySQLDump.RestoreExecutablePath = "mysql"
+	}
+
 	if c.MySQLDump.ExecutableArgs == "" {
-		c.MySQLDump.ExecutableArgs = "--add-drop-database --all-databases"
+		if len(c.MySQLDump.Databases) == 0 {
+			c.MySQLDump.ExecutableArgs = "--add-drop-database --all-databases"
+		} else {
+			c.MySQLDump.ExecutableArgs = "--add-drop-database"
+		}
+	}
+
+	if _, err := splitArgs(c.MySQLDump.ExecutableArgs); err != nil {
+		return fmt.Errorf("Failed to parse mysqldump executable_args: %w", err)
+	}
+
+	if c.MySQLDump.Host != "" && c.MySQLDump.Socket != "" {
+		return errors.New("Only one of mysqldump host and socket can be set")
+	}
+
+	if c.MySQLDump.Port < 0 || c.MySQLDump.Port > 65535 {
+		return fmt.Errorf("Invalid mysqldump port: %d", c.MySQLDump.Port)
+	}
+
+	if c.MySQLDump.TLS != nil {
+		if err := c.MySQLDump.TLS.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := readSecretFile(&c.MySQLDump.Password, c.MySQLDump.PasswordFile, "mysqldump password"); err != nil {
+		return err
 	}
 
 	if c.MySQLDump.TimeLimit != "" {
@@ -151,30 +297,413 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if c.MySQLDump.MaxAge != "" {
+		var err error
+		c.MySQLDump.maxAge, err = parseAge(c.MySQLDump.MaxAge)
+		if err != nil {
+			return fmt.Errorf("Failed to parse mysqldump max_age: %w", err)
+		}
+	}
+
+	if c.MySQLDump.StatsMaxAge != "" {
+		var err error
+		c.MySQLDump.statsMaxAge, err = parseAge(c.MySQLDump.StatsMaxAge)
+		if err != nil {
+			return fmt.Errorf("Failed to parse mysqldump stats_max_age: %w", err)
+		}
+	}
+
+	if c.MySQLDump.Retry != nil {
+		if err := c.MySQLDump.Retry.validate(); err != nil {
+			return fmt.Errorf("Invalid mysqldump retry: %w", err)
+		}
+	}
+
 	return nil
 
 }
 
 // MySQL defines how a mysql backup will be created.
 type MySQLDump struct {
-	// Retention is the number of backups to keep before rotating old backups out. Defaults to 7.
+	// Retention is the number of backups to keep in addition to the latest before rotating old backups out. If set
+	// to less than 0 all backups are kept. Defaults to 7.
 	Retention int `yaml:"retention"`
 
-	// OutputPath is the path where backups will be stored.
+	// OutputPath is the path where backups will be stored. It's linked to the latest backup and each backup is stored
+	// next to it with a timestamp in the name.
 	OutputPath string `yaml:"output_path"`
 
-	// Schedule is the cron expression that defines when backups are created.
+	// Schedule is the cron expression that defines when backups are created. An optional leading seconds field
+	// and descriptors such as @daily and @every 6h are supported.
 	Schedule string `yaml:"schedule"`
 
+	// Timezone is the optional IANA timezone the schedule is evaluated in such as America/New_York. Defaults to
+	// the local time.
+	Timezone string `yaml:"timezone"`
+
+	// Jitter is an optional maximum random delay before scheduled backups start to spread the load of many hosts.
+	Jitter string `yaml:"jitter"`
+	jitter time.Duration
+
+	// CatchUp runs a backup when repbak starts if a scheduled backup was missed since the last successful backup.
+	CatchUp bool `yaml:"catch_up"`
+
+	// Blackouts are optional windows during which scheduled backups are skipped or deferred.
+	Blackouts []Blackout `yaml:"blackouts"`
+
 	// ExecutablePath is the path to the tool used to create the mysql backup. Defaults to mysqldump.
 	ExecutablePath string `yaml:"executable_path"`
 
-	// ExecutableArgs are the arguments passed to the executable used to create the mysql backup. Defaults to --add-drop-database --all-databases.
+	// ExecutableArgs are extra arguments passed to the executable used to create the mysql backup. They're split with
+	// shell quoting. Defaults to --add-drop-database --all-databases or --add-drop-database if databases is set.
 	ExecutableArgs string `yaml:"executable_args"`
 
+	// Host is the optional hostname or IP of the mysql server. It can't be used with socket.
+	Host string `yaml:"host"`
+
+	// Port is the optional port of the mysql server.
+	Port int `yaml:"port"`
+
+	// Socket is the optional path of the unix socket of the mysql server.
+	Socket string `yaml:"socket"`
+
+	// User is the optional mysql user. It's passed to the executable in the same option file as the password.
+	User string `yaml:"user"`
+
+	// Password is the optional password of the mysql user. It's passed to the executable in a
+	// temporary --defaults-extra-file so it isn't visible in the process list.
+	Password string `yaml:"password"`
+
+	// PasswordFile is the path of a file containing the password. It can't be used with password.
+	PasswordFile string `yaml:"password_file"`
+
+	// TLS optionally configures the encryption of the connection to the mysql server.
+	TLS *MySQLTLS `yaml:"tls"`
+
+	// Databases are the databases backed up. Every database is backed up if empty.
+	Databases []string `yaml:"databases"`
+
+	// RestoreExecutablePath is the path to the mysql client used to restore backups. Defaults to mysql.
+	RestoreExecutablePath string `yaml:"restore_executable_path"`
+
+	// Compress gzip compresses backups if true. Compressed backups have .gz appended to their path.
+	Compress bool `yaml:"compress"`
+
 	// TimeLimit is an optional limit to the time it takes to run the backup.
 	TimeLimit string `yaml:"time_limit"`
 	timeLimit time.Duration
+
+	// MaxAge is the optional maximum age of the last successful backup such as 26h or 2d before the
+	// backup is reported unhealthy.
+	MaxAge string `yaml:"max_age"`
+	maxAge time.Duration
+
+	// StatsRetention optionally overrides the global retention for the stats and logs of this backup.
+	StatsRetention int `yaml:"stats_retention"`
+
+	// Retry optionally retries failed backups with backoff before notifying about the failure.
+	Retry *Retry `yaml:"retry"`
+
+	// StatsMaxAge optionally overrides the global retention_max_age for the stats and logs of this backup.
+	StatsMaxAge string `yaml:"stats_max_age"`
+	statsMaxAge time.Duration
+}
+
+// MySQLTLS defines the encryption of the connection to the mysql server.
+type MySQLTLS struct {
+	// Mode is the --ssl-mode of the connection. Valid modes are: disabled, preferred, required, verify_ca, and
+	// verify_identity. Defaults to required.
+	Mode string `yaml:"mode"`
+
+	// CAFile is the optional path of the PEM encoded CA certificates used to verify the server.
+	CAFile string `yaml:"ca_file"`
+
+	// CertFile is the optional path of the PEM encoded client certificate.
+	CertFile string `yaml:"cert_file"`
+
+	// KeyFile is the optional path of the PEM encoded client private key.
+	KeyFile string `yaml:"key_file"`
+}
+
+// validate validates the tls config and sets its defaults.
+func (t *MySQLTLS) validate() error {
+	switch strings.ToLower(t.Mode) {
+	case "":
+		t.Mode = "required"
+	case "disabled", "preferred", "required", "verify_ca", "verify_identity":
+		t.Mode = strings.ToLower(t.Mode)
+	default:
+		return fmt.Errorf("Invalid mysqldump tls mode: %s", t.Mode)
+	}
+
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("Missing required cert_file and key_file entries for mysqldump tls")
+	}
+
+	return nil
+}
+
+// statsRetention returns the number and maximum age of the stats kept for the backup name. A value of
+// 0 means no limit.
+func (c *Config) statsRetention(name string) (int, time.Duration) {
+	count, maxAge := c.Retention, c.retentionMaxAge
+
+	if c.MySQLDump != nil && name == "mysqldump" {
+		if c.MySQLDump.StatsRetention != 0 {
+			count = c.MySQLDump.StatsRetention
+		}
+		if c.MySQLDump.statsMaxAge != 0 {
+			maxAge = c.MySQLDump.statsMaxAge
+		}
+	}
+
+	return count, maxAge
+}
+
+// healthLimits returns the time limit of a run and the maximum age of the last successful run of the
+// backup name. A value of 0 means no limit.
+func (c *Config) healthLimits(name string) (time.Duration, time.Duration) {
+	if c.MySQLDump != nil && name == "mysqldump" {
+		return c.MySQLDump.timeLimit, c.MySQLDump.maxAge
+	}
+	return 0, 0
+}
+
+// Actions taken for scheduled backups during a blackout window.
+const (
+	// BlackoutSkip skips the backup.
+	BlackoutSkip = "skip"
+
+	// BlackoutDefer runs the backup once the window ends.
+	BlackoutDefer = "defer"
+)
+
+// Blackout is a window during which scheduled backups are skipped or deferred. Windows either recur on a
+// schedule for a duration or are a one time range from start to end.
+type Blackout struct {
+	// Schedule is the cron expression of when recurring windows start. It's evaluated in the backup's timezone.
+	Schedule string `yaml:"schedule"`
+	schedule cron.Schedule
+
+	// Duration is how long recurring windows last such as 4h.
+	Duration string `yaml:"duration"`
+	duration time.Duration
+
+	// Start and End are the RFC 3339 times of a one time window.
+	Start time.Time `yaml:"start,omitempty"`
+	End   time.Time `yaml:"end,omitempty"`
+
+	// Action is skip or defer. Defaults to skip.
+	Action string `yaml:"action"`
+
+	// Reason is an optional description of the window recorded with skipped backups.
+	Reason string `yaml:"reason"`
+}
+
+// validate validates the blackout and sets its defaults. Schedules are evaluated in timezone.
+func (b *Blackout) validate(timezone string) error {
+	switch b.Action {
+	case "":
+		b.Action = BlackoutSkip
+	case BlackoutSkip, BlackoutDefer:
+	default:
+		return fmt.Errorf("invalid action: %s", b.Action)
+	}
+
+	if b.Schedule == "" {
+		if b.Start.IsZero() || b.End.IsZero() {
+			return errors.New("missing required schedule and duration or start and end entries")
+		}
+		if !b.End.After(b.Start) {
+			return errors.New("end must be after start")
+		}
+		return nil
+	}
+
+	var err error
+	if b.schedule, err = parseSchedule(b.Schedule, timezone); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", b.Schedule, err)
+	}
+
+	if b.duration, err = time.ParseDuration(b.Duration); err != nil || b.duration <= 0 {
+		return fmt.Errorf("invalid duration: %q", b.Duration)
+	}
+
+	return nil
+}
+
+// window returns the end of the window active at t and false if the window isn't active.
+func (b *Blackout) window(t time.Time) (time.Time, bool) {
+	if b.schedule == nil {
+		return b.End, !t.Before(b.Start) && t.Before(b.End)
+	}
+
+	// a window is active if it started in the last duration
+	start := b.schedule.Next(t.Add(-b.duration))
+	if start.After(t) {
+		return time.Time{}, false
+	}
+	return start.Add(b.duration), true
+}
+
+// blackout returns the blackout of the backup name active at t and the end of its window. Nil is
+// returned if no blackout is active.
+func (c *Config) blackout(name string, t time.Time) (*Blackout, time.Time) {
+	if c.MySQLDump == nil || name != "mysqldump" {
+		return nil, time.Time{}
+	}
+
+	for i := range c.MySQLDump.Blackouts {
+		blackout := &c.MySQLDump.Blackouts[i]
+		if end, ok := blackout.window(t); ok {
+			return blackout, end
+		}
+	}
+	return nil, time.Time{}
+}
+
+// retryPolicy returns the retry policy of the backup name or nil if failures aren't retried.
+func (c *Config) retryPolicy(name string) *Retry {
+	if c.MySQLDump != nil && name == "mysqldump" {
+		return c.MySQLDump.Retry
+	}
+	return nil
+}
+
+// Failures that can be retried.
+const (
+	// FailureConnect is a failure to connect to the database or a lost connection.
+	FailureConnect = "connect"
+
+	// FailureTimeout is a backup that ran past its time_limit.
+	FailureTimeout = "timeout"
+
+	// FailureError is any other failure.
+	FailureError = "error"
+)
+
+// Retry defines how failed backups are retried. The delay between attempts starts at initial_delay and is
+// multiplied by multiplier after each attempt up to max_delay.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts including the first. Defaults to 3.
+	MaxAttempts int `yaml:"max_attempts"`
+
+	// InitialDelay is the delay before the first retry. Defaults to 1m.
+	InitialDelay string `yaml:"initial_delay"`
+	initialDelay time.Duration
+
+	// Multiplier multiplies the delay after each retry. Defaults to 2.
+	Multiplier float64 `yaml:"multiplier"`
+
+	// MaxDelay is the optional maximum delay between attempts.
+	MaxDelay string `yaml:"max_delay"`
+	maxDelay time.Duration
+
+	// RetryOn are the failures that are retried. Valid failures are: connect, timeout, and error. Defaults to connect.
+	RetryOn []string `yaml:"retry_on"`
+}
+
+// validate validates the retry policy and sets its defaults.
+func (r *Retry) validate() error {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1: %d", r.MaxAttempts)
+	}
+
+	if r.InitialDelay == "" {
+		r.InitialDelay = "1m"
+	}
+	var err error
+	if r.initialDelay, err = time.ParseDuration(r.InitialDelay); err != nil {
+		return fmt.Errorf("failed to parse initial_delay: %w", err)
+	}
+
+	if r.Multiplier == 0 {
+		r.Multiplier = 2
+	}
+	if r.Multiplier < 1 {
+		return fmt.Errorf("multiplier must be at least 1: %v", r.Multiplier)
+	}
+
+	if r.MaxDelay != "" {
+		if r.maxDelay, err = time.ParseDuration(r.MaxDelay); err != nil {
+			return fmt.Errorf("failed to parse max_delay: %w", err)
+		}
+	}
+
+	if len(r.RetryOn) == 0 {
+		r.RetryOn = []string{FailureConnect}
+	}
+	for _, failure := range r.RetryOn {
+		switch failure {
+		case FailureConnect, FailureTimeout, FailureError:
+		default:
+			return fmt.Errorf("invalid retry_on failure: %s", failure)
+		}
+	}
+
+	return nil
+}
+
+// delay returns the delay before the retry following attempt.
+func (r *Retry) delay(attempt int) time.Duration {
+	delay := float64(r.initialDelay) * math.Pow(r.Multiplier, float64(attempt-1))
+	if r.maxDelay > 0 && delay > float64(r.maxDelay) {
+		return r.maxDelay
+	}
+	return time.Duration(delay)
+}
+
+// retryable returns true if the failed stat of attempt should be retried. A nil Retry never retries.
+func (r *Retry) retryable(stat Stat, attempt int) bool {
+	if r == nil || stat.Success || attempt >= r.MaxAttempts {
+		return false
+	}
+
+	failure := failureOf(stat)
+	for _, retryOn := range r.RetryOn {
+		if retryOn == failure {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAge parses a duration that may also be given in days such as 90d.
+func parseAge(age string) (time.Duration, error) {
+	if strings.HasSuffix(age, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(age, "d"))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid number of days: %s", age)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(age)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("age must be positive: %s", age)
+	}
+	return d, nil
+}
+
+// Database defines where stats are stored.
+type Database struct {
+	// Driver is the database used to store stats. Valid drivers are: bolt, sqlite, and postgres. Defaults to bolt.
+	Driver string `yaml:"driver"`
+
+	// Path is the path of the sqlite database. Defaults to lib_path/repbak.sqlite.
+	Path string `yaml:"path"`
+
+	// DSN is the connection string of the postgres database. Required when driver is postgres.
+	DSN string `yaml:"dsn"`
+
+	// DSNFile is the path of a file containing the DSN. It can't be used with dsn.
+	DSNFile string `yaml:"dsn_file"`
 }
 
 // HTTP defines the configuration for http health checks.
@@ -184,8 +713,169 @@ type HTTP struct {
 
 	// The port the http server will listen on.
 	Port int `yaml:"port"`
+
+	// TLS serves https if set.
+	TLS *HTTPTLS `yaml:"tls"`
+
+	// Auth requires clients to authenticate if set.
+	Auth *HTTPAuth `yaml:"auth"`
 }
 
+// validate both validates the http configuration and sets the default options.
+func (h *HTTP) validate() error {
+	if h.TLS != nil {
+		if h.TLS.CertFile == "" || h.TLS.KeyFile == "" {
+			return errors.New("Missing required cert_file and key_file entries for http tls")
+		}
+		if (h.TLS.ClientCertFile == "") != (h.TLS.ClientKeyFile == "") {
+			return errors.New("Both client_cert_file and client_key_file must be set for http tls")
+		}
+	}
+
+	if h.Auth != nil {
+		if len(h.Auth.Tokens) == 0 && len(h.Auth.Users) == 0 {
+			return errors.New("Missing required tokens or users entry for http auth")
+		}
+
+		for i := range h.Auth.Tokens {
+			token := &h.Auth.Tokens[i]
+			if err := readSecretFile(&token.Token, token.TokenFile, "http auth token"); err != nil {
+				return err
+			}
+			if token.Token == "" {
+				return errors.New("Missing required token entry for http auth token")
+			}
+			if err := validateRole(&token.Role); err != nil {
+				return err
+			}
+		}
+
+		for i := range h.Auth.Users {
+			user := &h.Auth.Users[i]
+			if err := readSecretFile(&user.Password, user.PasswordFile, "http auth user password"); err != nil {
+				return err
+			}
+			if user.Username == "" || user.Password == "" {
+				return errors.New("Missing required username and password entries for http auth user")
+			}
+			if err := validateRole(&user.Role); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Roles of authenticated http clients.
+const (
+	// RoleRead can use every GET endpoint.
+	RoleRead = "read"
+
+	// RoleOperator can also trigger and cancel backups.
+	RoleOperator = "operator"
+)
+
+// validateRole validates role and defaults it to read.
+func validateRole(role *string) error {
+	switch *role {
+	case "":
+		*role = RoleRead
+	case RoleRead, RoleOperator:
+	default:
+		return fmt.Errorf("Invalid http auth role: %s", *role)
+	}
+	return nil
+}
+
+// HTTPTLS defines the certificates used to serve https.
+type HTTPTLS struct {
+	// CertFile is the path of the PEM encoded certificate. The certificate is reloaded when the file changes.
+	CertFile string `yaml:"cert_file"`
+
+	// KeyFile is the path of the PEM encoded private key.
+	KeyFile string `yaml:"key_file"`
+
+	// ClientCAFile is an optional path of PEM encoded CA certificates. If set then clients must present a certificate signed by one of them
+	// except for a public /live.
+	ClientCAFile string `yaml:"client_ca_file"`
+
+	// ClientCertFile and ClientKeyFile are the optional PEM encoded certificate and private key presented by the repbak
+	// commands when they query the daemon. They're needed when ClientCAFile is set.
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+}
+
+// HTTPAuth defines the clients allowed to use the http server.
+type HTTPAuth struct {
+	// Tokens are the bearer tokens allowed to use the http server.
+	Tokens []HTTPToken `yaml:"tokens"`
+
+	// Users are the basic auth users allowed to use the http server.
+	Users []HTTPUser `yaml:"users"`
+
+	// PublicLive serves /live without authentication for orchestrator probes.
+	PublicLive bool `yaml:"public_live"`
+}
+
+// HTTPToken is a bearer token allowed to use the http server.
+type HTTPToken struct {
+	Token string `yaml:"token"`
+
+	// TokenFile is the path of a file containing the token. It can't be used with token.
+	TokenFile string `yaml:"token_file"`
+
+	// Role is read or operator. Defaults to read.
+	Role string `yaml:"role"`
+}
+
+// HTTPUser is a basic auth user allowed to use the http server.
+type HTTPUser struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+
+	// PasswordFile is the path of a file containing the password. It can't be used with password.
+	PasswordFile string `yaml:"password_file"`
+
+	// Role is read or operator. Defaults to read.
+	Role string `yaml:"role"`
+}
+
+// Syslog defines the configuration for sending backup events and logs to syslog using RFC 5424.
+type Syslog struct {
+	// Network is the network used to connect to syslog. Valid networks are: unix, unixgram, udp, and tcp. Defaults to the local syslog socket.
+	Network string `yaml:"network"`
+
+	// Addr is the address of the syslog server or the path of the unix socket. Required when network is set.
+	Addr string `yaml:"addr"`
+
+	// Tag is the app name used for syslog messages. Defaults to repbak.
+	Tag string `yaml:"tag"`
+
+	// Facility is the syslog facility used for messages. Defaults to daemon.
+	Facility string `yaml:"facility"`
+	facility int
+
+	// OnFailure will write an event for each backup failure if true.
+	OnFailure bool `yaml:"on_failure"`
+
+	// OnSuccess will write an event for each successful backup if true.
+	OnSuccess bool `yaml:"on_success"`
+}
+
+// Journald defines the configuration for sending backup events and logs directly to the systemd journal.
+type Journald struct {
+	// Identifier is the SYSLOG_IDENTIFIER used for journal entries. Defaults to repbak.
+	Identifier string `yaml:"identifier"`
+
+	// OnFailure will write an entry for each backup failure if true.
+	OnFailure bool `yaml:"on_failure"`
+
+	// OnSuccess will write an entry for each successful backup if true.
+	OnSuccess bool `yaml:"on_success"`
+}
+
+// Email defines the configuration for email notifications.
 type Email struct {
 	// Host is the hostname or IP of the SMTP server.
 	Host string `yaml:"host"`
@@ -199,6 +889,9 @@ type Email struct {
 	// Pass is the password used to authenticate.
 	Pass string `yaml:"pass"`
 
+	// PassFile is the path of a file containing the password. It can't be used with pass.
+	PassFile string `yaml:"pass_file"`
+
 	// StartTLS enables TLS security. If both StartTLS and SSL are true then StartTLS will be used.
 	StartTLS bool `yaml:"starttls"`
 
@@ -208,9 +901,18 @@ type Email struct {
 	// SSL enables SSL security. If both StartTLS and SSL are true then StartTLS will be used.
 	SSL bool `yaml:"ssl"`
 
-	// Optional subject field for notification emails
+	// Optional subject field for notification emails. The subject is a template that is passed a FailureEmail.
 	Subject string `yaml:"subject"`
 
+	// FailureTemplate is an optional path to an HTML email template to use when sending failure emails. If not set uses the default template.
+	FailureTemplate string `yaml:"failure_template"`
+
+	// FailureTextTemplate is an optional path to a plain text email template to use when sending failure emails. If not set uses the default template.
+	FailureTextTemplate string `yaml:"failure_text_template"`
+
+	// TailLines is the number of trailing STDERR lines from the dumper included in failure emails. Defaults to 20 with a max of 100.
+	TailLines int `yaml:"tail_lines"`
+
 	// From is the email address the email will be sent from.
 	From string `yaml:"from"`
 
@@ -220,6 +922,9 @@ type Email struct {
 	// HistorySubject is an optional subject to use when sending sync history emails. Defaults to Database Backup History.
 	HistorySubject string `yaml:"history_subject"`
 
+	// DigestSubject is an optional subject to use when sending digests of suppressed notifications. Defaults to Database Backup Notification Digest.
+	DigestSubject string `yaml:"digest_subject"`
+
 	// HistorySchedule is a cron expression. If set then an email with sync history will be sent based on the schedule.
 	HistorySchedule string `yaml:"history_schedule"`
 
@@ -230,6 +935,199 @@ type Email struct {
 	OnFailure bool `yaml:"on_failure"`
 }
 
+// validate both validates the email configuration and sets the default options.
+func (e *Email) validate() error {
+	if e.Host == "" {
+		return errors.New("Missing required host entry for email")
+	}
+
+	if err := readSecretFile(&e.Pass, e.PassFile, "email pass"); err != nil {
+		return err
+	}
+
+	if e.Port == 0 {
+		e.Port = 25
+	}
+
+	// StartTLS takes presidence over SSL
+	if e.StartTLS {
+		e.SSL = false
+	}
+
+	if e.Subject == "" {
+		e.Subject = "Database Replication Failure"
+	}
+
+	if _, err := template.New("subject").Parse(e.Subject); err != nil {
+		return fmt.Errorf("Failed to parse email subject template: %w", err)
+	}
+
+	if e.TailLines == 0 {
+		e.TailLines = 20
+	}
+
+	if e.TailLines < 0 || e.TailLines > maxStderrLines {
+		return fmt.Errorf("Invalid email tail_lines %d: must be between 1 and %d", e.TailLines, maxStderrLines)
+	}
+
+	if e.From == "" {
+		return errors.New("Missing required from entry for email")
+	}
+
+	if len(e.To) == 0 {
+		return errors.New("Missing required to entry for email")
+	}
+
+	if e.HistorySubject == "" {
+		e.HistorySubject = "Database Backup History"
+	}
+
+	if e.DigestSubject == "" {
+		e.DigestSubject = "Database Backup Notification Digest"
+	}
+
+	return nil
+}
+
+// NotificationPolicy defines how failure notifications are deduplicated, rate limited, and batched
+// before they are sent by the notifiers. Suppressed notifications are stored and can be sent as a digest.
+type NotificationPolicy struct {
+	// DedupWindow is an optional duration. Failures with the same backup and error as a notification sent within the window are suppressed.
+	DedupWindow string `yaml:"dedup_window"`
+	dedupWindow time.Duration
+
+	// MaxPerHour is an optional limit to the number of failure notifications sent per hour.
+	MaxPerHour int `yaml:"max_per_hour"`
+
+	// DigestSchedule is a cron expression. If set then a digest of suppressed notifications will be sent based on the schedule.
+	DigestSchedule string `yaml:"digest_schedule"`
+
+	// QuietHours is an optional daily window where only critical notifications are sent.
+	QuietHours *QuietHours `yaml:"quiet_hours"`
+
+	// CriticalFailures is the number of consecutive failures of a backup after which its failures are critical. Defaults to 3.
+	CriticalFailures int `yaml:"critical_failures"`
+}
+
+// validate both validates the notification policy configuration and sets the default options.
+func (p *NotificationPolicy) validate() error {
+	if p.DedupWindow != "" {
+		var err error
+		p.dedupWindow, err = time.ParseDuration(p.DedupWindow)
+		if err != nil {
+			return fmt.Errorf("Failed to parse notification_policy dedup_window: %w", err)
+		}
+	}
+
+	if p.MaxPerHour < 0 {
+		return fmt.Errorf("Invalid notification_policy max_per_hour: %d", p.MaxPerHour)
+	}
+
+	if p.CriticalFailures == 0 {
+		p.CriticalFailures = 3
+	}
+
+	if p.QuietHours != nil {
+		if err := p.QuietHours.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// QuietHours defines a daily window in local time. If End is before Start the window spans midnight.
+type QuietHours struct {
+	// Start is the start of quiet hours formatted as HH:MM.
+	Start string `yaml:"start"`
+	start time.Duration
+
+	// End is the end of quiet hours formatted as HH:MM.
+	End string `yaml:"end"`
+	end time.Duration
+}
+
+// validate parses the start and end of quiet hours.
+func (q *QuietHours) validate() error {
+	start, err := time.Parse("15:04", q.Start)
+	if err != nil {
+		return fmt.Errorf("Failed to parse quiet_hours start: %w", err)
+	}
+
+	end, err := time.Parse("15:04", q.End)
+	if err != nil {
+		return fmt.Errorf("Failed to parse quiet_hours end: %w", err)
+	}
+
+	q.start = time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute
+	q.end = time.Duration(end.Hour())*time.Hour + time.Duration(end.Minute())*time.Minute
+
+	return nil
+}
+
+// contains returns true if t is within quiet hours.
+func (q *QuietHours) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	if q.start <= q.end {
+		return offset >= q.start && offset < q.end
+	}
+	return offset >= q.start || offset < q.end
+}
+
+// Outbox defines how notifications that fail to be delivered are retried. Undelivered
+// notifications are stored in the stats database so retries continue across restarts.
+type Outbox struct {
+	// RetryInterval is how often the outbox is checked for notifications to retry. Defaults to 1m.
+	RetryInterval string `yaml:"retry_interval"`
+	retryInterval time.Duration
+
+	// InitialBackoff is the delay before the first retry. The delay doubles after each failed retry. Defaults to 1m.
+	InitialBackoff string `yaml:"initial_backoff"`
+	initialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries. Defaults to 1h.
+	MaxBackoff string `yaml:"max_backoff"`
+	maxBackoff time.Duration
+
+	// MaxAge is how long a notification is retried before it's moved to the dead letters. Defaults to 24h.
+	MaxAge string `yaml:"max_age"`
+	maxAge time.Duration
+}
+
+// validate both validates the outbox configuration and sets the default options.
+func (o *Outbox) validate() error {
+	durations := []struct {
+		name     string
+		value    *string
+		def      string
+		duration *time.Duration
+	}{
+		{"retry_interval", &o.RetryInterval, "1m", &o.retryInterval},
+		{"initial_backoff", &o.InitialBackoff, "1m", &o.initialBackoff},
+		{"max_backoff", &o.MaxBackoff, "1h", &o.maxBackoff},
+		{"max_age", &o.MaxAge, "24h", &o.maxAge},
+	}
+
+	for _, d := range durations {
+		if *d.value == "" {
+			*d.value = d.def
+		}
+
+		var err error
+		*d.duration, err = time.ParseDuration(*d.value)
+		if err != nil {
+			return fmt.Errorf("Failed to parse outbox %s: %w", d.name, err)
+		}
+
+		if *d.duration <= 0 {
+			return fmt.Errorf("Invalid outbox %s: must be greater than 0", d.name)
+		}
+	}
+
+	return nil
+}
+
 // OpenConfig returns a new Config option by reading the YAML file at path. If the file
 // doesn't exist, can't be read, is invalid YAML, or doesn't match the repbak spec then
 // an error is returned.
@@ -239,8 +1137,20 @@ func OpenConfig(path string) (*Config, error) {
 		return nil, err
 	}
 
+	defer f.Close()
+
+	// ${VAR} references in values are replaced with the environment before decoding
+	var node yaml.Node
+	if err := yaml.NewDecoder(f).Decode(&node); err != nil {
+		return nil, err
+	}
+
+	if err := interpolate(&node); err != nil {
+		return nil, err
+	}
+
 	config := new(Config)
-	if err := yaml.NewDecoder(f).Decode(config); err != nil {
+	if err := node.Decode(config); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,198 @@
+package repbak
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maskedSecret replaces secrets in the printed config.
+const maskedSecret = "********"
+
+// CheckOptions selects the optional checks run by Check.
+type CheckOptions struct {
+	// SMTP connects and authenticates to the SMTP server if email is configured.
+	SMTP bool
+}
+
+// CheckResult is the result of a single configuration check.
+type CheckResult struct {
+	Name string
+
+	// Err is the problem found or nil if the check passed.
+	Err error
+
+	// Warning is true if the problem doesn't stop repbak from running backups.
+	Warning bool
+}
+
+// Check does the checks that validate can't without side effects: cron expressions are parsed,
+// executables are resolved, directories are tested for writes, templates are rendered, and TLS
+// certificates are loaded. The config must already be validated.
+func (c *Config) Check(opts CheckOptions) []CheckResult {
+	var results []CheckResult
+	check := func(name string, err error) {
+		results = append(results, CheckResult{Name: name, Err: err})
+	}
+	warn := func(name string, err error) {
+		results = append(results, CheckResult{Name: name, Err: err, Warning: err != nil})
+	}
+
+	// schedules
+	check("mysqldump schedule", checkSchedule(scheduleSpec(c.MySQLDump.Schedule, c.MySQLDump.Timezone)))
+	if c.Retention > -1 {
+		check("prune_schedule", checkSchedule(c.PruneSchedule))
+	}
+	if c.Email != nil && c.Email.HistorySchedule != "" {
+		check("email history_schedule", checkSchedule(c.Email.HistorySchedule))
+	}
+	if c.NotificationPolicy != nil && c.NotificationPolicy.DigestSchedule != "" {
+		check("notification_policy digest_schedule", checkSchedule(c.NotificationPolicy.DigestSchedule))
+	}
+
+	// executables
+	check("mysqldump executable_path", checkExecutable(c.MySQLDump.ExecutablePath))
+	warn("mysqldump restore_executable_path", checkExecutable(c.MySQLDump.RestoreExecutablePath))
+
+	// writable directories
+	check("mysqldump output_path", checkWritable(filepath.Dir(c.MySQLDump.OutputPath)))
+	check("lib_path", checkWritable(c.LibPath))
+	if c.LogOutput == "file" {
+		check("log_path", checkWritable(filepath.Dir(c.LogPath)))
+	}
+	if c.Database.Driver == "sqlite" {
+		check("database path", checkWritable(filepath.Dir(c.Database.Path)))
+	}
+
+	// email
+	if c.Email != nil {
+		notifier := NewEmailNotifier(c)
+		check("email templates", notifier.checkTemplates())
+		if opts.SMTP {
+			check("email smtp", notifier.checkSMTP())
+		}
+	}
+
+	// http
+	if c.HTTP != nil && c.HTTP.TLS != nil {
+		_, err := newTLSConfig(c.HTTP.TLS)
+		check("http tls", err)
+
+		if c.HTTP.TLS.ClientCertFile != "" {
+			_, err := tls.LoadX509KeyPair(c.HTTP.TLS.ClientCertFile, c.HTTP.TLS.ClientKeyFile)
+			check("http tls client certificate", err)
+		}
+	}
+
+	return results
+}
+
+// checkSchedule parses a cron expression the way the scheduler does.
+func checkSchedule(schedule string) error {
+	if _, err := scheduleParser.Parse(schedule); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", schedule, err)
+	}
+	return nil
+}
+
+// checkExecutable resolves path in the PATH if it isn't absolute.
+func checkExecutable(path string) error {
+	if _, err := exec.LookPath(path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkWritable checks that a file can be created in dir. A missing dir is checked by its nearest
+// existing parent since repbak creates it.
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s isn't a directory", dir)
+			}
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) || filepath.Dir(dir) == dir {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".repbak-check-*")
+	if err != nil {
+		return fmt.Errorf("%s isn't writable: %w", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// passwordArgs matches passwords passed as -pPASS or --password=PASS.
+var passwordArgs = regexp.MustCompile(`(^|\s)(-p|--password=)\S+`)
+
+// MaskedYAML returns the config with its defaults applied as YAML. Passwords, tokens, and the
+// password in the database DSN are masked.
+func (c *Config) MaskedYAML() ([]byte, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal config: %w", err)
+	}
+
+	// a copy so the secrets of c aren't changed
+	masked := &Config{}
+	if err := yaml.Unmarshal(data, masked); err != nil {
+		return nil, fmt.Errorf("Failed to copy config: %w", err)
+	}
+
+	if masked.Email != nil && masked.Email.Pass != "" {
+		masked.Email.Pass = maskedSecret
+	}
+
+	if masked.MySQLDump != nil {
+		if masked.MySQLDump.Password != "" {
+			masked.MySQLDump.Password = maskedSecret
+		}
+		masked.MySQLDump.ExecutableArgs = passwordArgs.ReplaceAllString(masked.MySQLDump.ExecutableArgs, "${1}${2}"+maskedSecret)
+	}
+
+	if masked.Database != nil && masked.Database.DSN != "" {
+		masked.Database.DSN = maskDSNPassword(masked.Database.DSN)
+	}
+
+	if masked.HTTP != nil && masked.HTTP.Auth != nil {
+		for i := range masked.HTTP.Auth.Tokens {
+			masked.HTTP.Auth.Tokens[i].Token = maskedSecret
+		}
+		for i := range masked.HTTP.Auth.Users {
+			masked.HTTP.Auth.Users[i].Password = maskedSecret
+		}
+	}
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(masked); err != nil {
+		return nil, fmt.Errorf("Failed to marshal config: %w", err)
+	}
+	return b.Bytes(), encoder.Close()
+}
+
+// dsnPasswords match the password in a postgres URL or key value DSN.
+var (
+	dsnURLPassword = regexp.MustCompile(`(://[^:/@]*:)[^@]*@`)
+	dsnPassword    = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+)
+
+// maskDSNPassword masks the password in a postgres URL or key value DSN.
+func maskDSNPassword(dsn string) string {
+	dsn = dsnURLPassword.ReplaceAllString(dsn, "${1}"+maskedSecret+"@")
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+maskedSecret)
+}
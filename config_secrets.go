@@ -0,0 +1,81 @@
+package repbak
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envReference matches ${VAR} and ${VAR:-default} references. $${ escapes a literal ${.
+var envReference = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// interpolate replaces the environment variable references in the scalar values of node. Referencing
+// an unset variable without a default is an error.
+func interpolate(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		value, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("Config: line %d: %w", node.Line, err)
+		}
+		// plain scalars are resolved again so references work in int and bool fields. Quoted values stay strings.
+		if value != node.Value && node.Style == 0 {
+			node.Tag = ""
+		}
+		node.Value = value
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := interpolate(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandEnv replaces the environment variable references in s.
+func expandEnv(s string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		match := envReference.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(match[1]); ok {
+			return value
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", match[1])
+		}
+		return ref
+	})
+	return expanded, err
+}
+
+// readSecretFile sets secret to the contents of path with trailing newlines removed if path is set.
+// Setting both secret and path is an error.
+func readSecretFile(secret *string, path, name string) error {
+	if path == "" {
+		return nil
+	}
+
+	if *secret != "" {
+		return fmt.Errorf("Only one of %s and its file can be set", name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s file: %w", name, err)
+	}
+
+	*secret = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
@@ -0,0 +1,91 @@
+package repbak
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigInterpolate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Setenv("REPBAK_TEST_LIB", dir)
+	t.Setenv("REPBAK_TEST_PASS", "s3cr3t")
+	t.Setenv("REPBAK_TEST_PORT", "3307")
+	t.Setenv("REPBAK_TEST_CATCH_UP", "true")
+
+	path := filepath.Join(dir, "repbak.yaml")
+	assert.Nil(t, os.WriteFile(path, []byte(`lib_path: ${REPBAK_TEST_LIB}
+log_path: ${REPBAK_TEST_UNSET:-/tmp/repbak.log}
+retention: ${REPBAK_TEST_UNSET:-14}
+mysqldump:
+  output_path: /mnt/backups/mysql.dump
+  schedule: "0 0 * * *"
+  host: 127.0.0.1
+  port: ${REPBAK_TEST_PORT}
+  catch_up: ${REPBAK_TEST_CATCH_UP}
+  user: "${REPBAK_TEST_PORT}"
+  password: ${REPBAK_TEST_PASS}
+  executable_args: --comments=$${REPBAK_TEST_PASS}
+`), 0644))
+
+	config, err := OpenConfig(path)
+	assert.Nil(t, err)
+	assert.Equal(t, config.LibPath, dir)
+	assert.Equal(t, config.LogPath, "/tmp/repbak.log")
+	assert.Equal(t, config.MySQLDump.Password, "s3cr3t")
+	assert.Equal(t, config.MySQLDump.ExecutableArgs, "--comments=${REPBAK_TEST_PASS}")
+
+	// plain values are typed after they're expanded and quoted values stay strings
+	assert.Equal(t, config.Retention, 14)
+	assert.Equal(t, config.MySQLDump.Port, 3307)
+	assert.True(t, config.MySQLDump.CatchUp)
+	assert.Equal(t, config.MySQLDump.User, "3307")
+
+	// unset variables without a default are an error
+	assert.Nil(t, os.WriteFile(path, []byte("lib_path: ${REPBAK_TEST_UNSET}\n"), 0644))
+	_, err = OpenConfig(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "REPBAK_TEST_UNSET")
+}
+
+func TestConfigSecretFiles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	secret := filepath.Join(dir, "secret")
+	assert.Nil(t, os.WriteFile(secret, []byte("s3cr3t\n"), 0600))
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Email.Pass = ""
+	config.Email.PassFile = secret
+	config.MySQLDump.PasswordFile = secret
+	config.HTTP.Auth = &HTTPAuth{
+		Tokens: []HTTPToken{{TokenFile: secret}},
+		Users:  []HTTPUser{{Username: "admin", PasswordFile: secret}},
+	}
+	config.Database = &Database{Driver: "postgres", DSNFile: secret}
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.Email.Pass, "s3cr3t")
+	assert.Equal(t, config.MySQLDump.Password, "s3cr3t")
+	assert.Equal(t, config.HTTP.Auth.Tokens[0].Token, "s3cr3t")
+	assert.Equal(t, config.HTTP.Auth.Users[0].Password, "s3cr3t")
+	assert.Equal(t, config.Database.DSN, "s3cr3t")
+
+	// a secret and its file can't both be set
+	value := "set"
+	assert.Error(t, readSecretFile(&value, secret, "test"))
+
+	value = ""
+	assert.Error(t, readSecretFile(&value, filepath.Join(dir, "missing"), "test"))
+
+	assert.Nil(t, readSecretFile(&value, "", "test"))
+	assert.Equal(t, value, "")
+}
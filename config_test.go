@@ -2,6 +2,7 @@ package repbak
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -55,12 +56,14 @@ func TestConfigDefaults(t *testing.T) {
 	assert.Equal(t, config.LogLevel, "error")
 	assert.Equal(t, config.LibPath, "/var/lib/repbak")
 	assert.Equal(t, config.Retention, 7)
+	assert.Equal(t, config.PruneSchedule, "0 * * * *")
 	assert.Equal(t, config.TimeFormat, "Mon Jan 02 03:04:05 PM MST")
 
 	assert.Equal(t, config.Email.Port, 25)
 	assert.Equal(t, config.Email.StartTLS, false)
 	assert.Equal(t, config.Email.SSL, false)
 	assert.Equal(t, config.Email.Subject, "Database Replication Failure")
+	assert.Equal(t, config.Email.TailLines, 20)
 	assert.Equal(t, config.Email.OnFailure, false)
 
 	assert.Equal(t, config.MySQLDump.Retention, 7)
@@ -161,3 +164,336 @@ func TestConfigBadTimeLimit(t *testing.T) {
 	err = config.validate()
 	assert.Error(t, err)
 }
+
+func TestConfigBadEmailTemplates(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Email.Subject = "{{.Name"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.Email.Subject = "{{.Name}} failed"
+	config.Email.TailLines = 101
+	err = config.validate()
+	assert.Error(t, err)
+}
+
+func TestConfigSyslog(t *testing.T) {
+	config, err := OpenConfig("./testdata/defaults.yaml")
+	assert.Nil(t, err)
+
+	assert.Equal(t, config.LogOutput, "file")
+
+	config.Syslog = &Syslog{}
+	err = config.validate()
+	assert.Nil(t, err)
+	assert.Equal(t, config.Syslog.Tag, "repbak")
+	assert.Equal(t, config.Syslog.Facility, "daemon")
+	assert.Equal(t, config.Syslog.facility, 3)
+
+	config.Syslog.Addr = "127.0.0.1:514"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.Syslog.Network = "udp"
+	config.Syslog.Facility = "local3"
+	err = config.validate()
+	assert.Nil(t, err)
+	assert.Equal(t, config.Syslog.facility, 19)
+
+	config.Syslog.Facility = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.Syslog.Facility = "daemon"
+	config.Syslog.Network = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.Syslog = nil
+	config.Journald = &Journald{}
+	err = config.validate()
+	assert.Nil(t, err)
+	assert.Equal(t, config.Journald.Identifier, "repbak")
+
+	config.LogOutput = "journald"
+	err = config.validate()
+	assert.Nil(t, err)
+
+	config.LogOutput = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+}
+
+func TestConfigEmailOptional(t *testing.T) {
+	config, err := OpenConfig("./testdata/defaults.yaml")
+	assert.Nil(t, err)
+
+	config.Email = nil
+	err = config.validate()
+	assert.Nil(t, err)
+}
+
+func TestConfigNotificationPolicy(t *testing.T) {
+	config, err := OpenConfig("./testdata/defaults.yaml")
+	assert.Nil(t, err)
+
+	config.NotificationPolicy = &NotificationPolicy{
+		DedupWindow: "30m",
+		QuietHours: &QuietHours{
+			Start: "22:00",
+			End:   "06:00",
+		},
+	}
+	err = config.validate()
+	assert.Nil(t, err)
+	assert.Equal(t, config.NotificationPolicy.dedupWindow, 30*time.Minute)
+	assert.Equal(t, config.NotificationPolicy.CriticalFailures, 3)
+	assert.Equal(t, config.NotificationPolicy.QuietHours.start, 22*time.Hour)
+	assert.Equal(t, config.NotificationPolicy.QuietHours.end, 6*time.Hour)
+
+	config.NotificationPolicy.DedupWindow = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.NotificationPolicy.DedupWindow = ""
+	config.NotificationPolicy.MaxPerHour = -1
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.NotificationPolicy.MaxPerHour = 0
+	config.NotificationPolicy.QuietHours.End = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+
+	// suppressed notifications can't be stored without stats
+	config.NotificationPolicy.QuietHours.End = "06:00"
+	config.Retention = -1
+	err = config.validate()
+	assert.EqualError(t, err, "notification_policy requires retention to be 0 or greater")
+}
+
+func TestConfigOutbox(t *testing.T) {
+	config, err := OpenConfig("./testdata/defaults.yaml")
+	assert.Nil(t, err)
+
+	assert.NotNil(t, config.Outbox)
+	assert.Equal(t, config.Outbox.retryInterval, time.Minute)
+	assert.Equal(t, config.Outbox.initialBackoff, time.Minute)
+	assert.Equal(t, config.Outbox.maxBackoff, time.Hour)
+	assert.Equal(t, config.Outbox.maxAge, 24*time.Hour)
+
+	config.Outbox.MaxAge = "bad"
+	err = config.validate()
+	assert.Error(t, err)
+
+	config.Outbox.MaxAge = "-1h"
+	err = config.validate()
+	assert.Error(t, err)
+}
+
+func TestConfigRetention(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Retention = 0
+	config.RetentionMaxAge = "90d"
+	config.MySQLDump.StatsRetention = 5
+	config.MySQLDump.StatsMaxAge = "36h"
+	assert.Nil(t, config.validate())
+
+	count, maxAge := config.statsRetention("OTHER")
+	assert.Equal(t, count, 0)
+	assert.Equal(t, maxAge, 90*24*time.Hour)
+
+	count, maxAge = config.statsRetention("mysqldump")
+	assert.Equal(t, count, 5)
+	assert.Equal(t, maxAge, 36*time.Hour)
+
+	config.RetentionMaxAge = "d"
+	assert.Error(t, config.validate())
+
+	config.RetentionMaxAge = "-1h"
+	assert.Error(t, config.validate())
+
+	config.RetentionMaxAge = ""
+	config.MySQLDump.StatsMaxAge = "asdf"
+	assert.Error(t, config.validate())
+}
+
+func TestConfigDatabase(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, config.Database.Driver, "bolt")
+
+	config.Database.Driver = "sqlite"
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.Database.Path, "/tmp/repbak/repbak.sqlite")
+
+	config.Database.Driver = "postgres"
+	assert.Error(t, config.validate())
+
+	config.Database.DSN = "postgres://repbak@localhost/repbak"
+	assert.Nil(t, config.validate())
+
+	config.Database.Driver = "mongo"
+	assert.Error(t, config.validate())
+}
+
+func TestConfigHTTP(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.HTTP = &HTTP{TLS: &HTTPTLS{CertFile: "cert.pem"}}
+	assert.Error(t, config.validate())
+
+	config.HTTP.TLS.KeyFile = "key.pem"
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.HTTP.Port, 4060)
+
+	config.HTTP.TLS.ClientCertFile = "client.pem"
+	assert.Error(t, config.validate())
+
+	config.HTTP.TLS.ClientKeyFile = "client-key.pem"
+	assert.Nil(t, config.validate())
+
+	config.HTTP.Auth = &HTTPAuth{}
+	assert.Error(t, config.validate())
+
+	config.HTTP.Auth.Tokens = []HTTPToken{{Token: "TOKEN"}}
+	config.HTTP.Auth.Users = []HTTPUser{{Username: "admin", Password: "PASS", Role: RoleOperator}}
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.HTTP.Auth.Tokens[0].Role, RoleRead)
+
+	config.HTTP.Auth.Users[0].Role = "admin"
+	assert.Error(t, config.validate())
+
+	config.HTTP.Auth.Users[0].Role = RoleOperator
+	config.HTTP.Auth.Users[0].Password = ""
+	assert.Error(t, config.validate())
+}
+
+func TestConfigMySQLDumpConnection(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.MySQLDump.ExecutableArgs = ""
+	config.MySQLDump.Databases = []string{"app"}
+	config.MySQLDump.TLS = &MySQLTLS{}
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.ExecutableArgs, "--add-drop-database")
+	assert.Equal(t, config.MySQLDump.TLS.Mode, "required")
+
+	config.MySQLDump.TLS.Mode = "VERIFY_IDENTITY"
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.TLS.Mode, "verify_identity")
+
+	config.MySQLDump.TLS.Mode = "always"
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.TLS = &MySQLTLS{CertFile: "cert.pem"}
+	assert.Error(t, config.validate())
+	config.MySQLDump.TLS = nil
+
+	config.MySQLDump.Host = "db.example.com"
+	config.MySQLDump.Socket = "/run/mysqld/mysqld.sock"
+	assert.Error(t, config.validate())
+	config.MySQLDump.Socket = ""
+
+	config.MySQLDump.Port = 70000
+	assert.Error(t, config.validate())
+	config.MySQLDump.Port = 3306
+
+	config.MySQLDump.ExecutableArgs = `--where="unterminated`
+	assert.Error(t, config.validate())
+}
+
+func TestConfigRetry(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.MySQLDump.Retry = &Retry{}
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.Retry.MaxAttempts, 3)
+	assert.Equal(t, config.MySQLDump.Retry.Multiplier, 2.0)
+	assert.Equal(t, config.MySQLDump.Retry.RetryOn, []string{FailureConnect})
+	assert.Equal(t, config.MySQLDump.Retry.delay(1), time.Minute)
+	assert.Equal(t, config.MySQLDump.Retry.delay(3), 4*time.Minute)
+
+	config.MySQLDump.Retry.MaxDelay = "3m"
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.Retry.delay(3), 3*time.Minute)
+
+	config.MySQLDump.Retry.RetryOn = []string{"always"}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Retry = &Retry{MaxAttempts: -1}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Retry = &Retry{Multiplier: 0.5}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Retry = &Retry{InitialDelay: "soon"}
+	assert.Error(t, config.validate())
+}
+
+func TestConfigSchedule(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.MySQLDump.Timezone = "Europe/Berlin"
+	config.MySQLDump.Jitter = "5m"
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.jitter, 5*time.Minute)
+
+	config.MySQLDump.Jitter = "soon"
+	assert.Error(t, config.validate())
+	config.MySQLDump.Jitter = ""
+
+	config.MySQLDump.Timezone = "Mars/Olympus_Mons"
+	assert.Error(t, config.validate())
+}
+
+func TestConfigBlackout(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	config.MySQLDump.Timezone = "UTC"
+	config.MySQLDump.Blackouts = []Blackout{
+		{Schedule: "0 1 * * *", Duration: "2h", Reason: "nightly batch"},
+		{Start: start, End: start.Add(24 * time.Hour), Action: BlackoutDefer},
+	}
+	assert.Nil(t, config.validate())
+	assert.Equal(t, config.MySQLDump.Blackouts[0].Action, BlackoutSkip)
+
+	// the one time window
+	blackout, end := config.blackout("mysqldump", start.Add(12*time.Hour))
+	assert.NotNil(t, blackout)
+	assert.Equal(t, blackout.Action, BlackoutDefer)
+	assert.True(t, end.Equal(start.Add(24*time.Hour)))
+
+	// the recurring window
+	blackout, end = config.blackout("mysqldump", time.Date(2024, 7, 1, 2, 30, 0, 0, time.UTC))
+	assert.NotNil(t, blackout)
+	assert.Equal(t, blackout.Reason, "nightly batch")
+	assert.True(t, end.Equal(time.Date(2024, 7, 1, 3, 0, 0, 0, time.UTC)))
+
+	blackout, _ = config.blackout("mysqldump", time.Date(2024, 7, 1, 3, 0, 0, 0, time.UTC))
+	assert.Nil(t, blackout)
+	blackout, _ = config.blackout("other", start.Add(12*time.Hour))
+	assert.Nil(t, blackout)
+
+	config.MySQLDump.Blackouts = []Blackout{{Schedule: "0 1 * * *"}}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Blackouts = []Blackout{{Schedule: "0 1 * * *", Duration: "2h", Action: "pause"}}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Blackouts = []Blackout{{Start: start, End: start}}
+	assert.Error(t, config.validate())
+
+	config.MySQLDump.Blackouts = []Blackout{{Schedule: "whenever", Duration: "2h"}}
+	assert.Error(t, config.validate())
+}
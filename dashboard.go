@@ -0,0 +1,174 @@
+package repbak
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+// Size of the duration sparkline in pixels.
+const (
+	sparklineWidth  = 200
+	sparklineHeight = 30
+)
+
+// dashboardRuns is the number of recent runs shown for each job.
+const dashboardRuns = 20
+
+// dashboardData is the data rendered by the dashboard template.
+type dashboardData struct {
+	Jobs            []dashboardJob
+	SparklineWidth  int
+	SparklineHeight int
+}
+
+// dashboardJob is a backup job shown on the dashboard.
+type dashboardJob struct {
+	Job
+	Health JobHealth
+
+	// Runs are the recent runs sorted newest first.
+	Runs []Stat
+
+	// Sparkline are the SVG polyline points of the recent run durations oldest first.
+	Sparkline string
+}
+
+// dashboard is a read only HTML page of the state and recent runs of each backup job.
+type dashboard struct {
+	db     DB
+	repbak *RepBak
+	tmpl   *template.Template
+	assets http.Handler
+}
+
+// newDashboard returns a dashboard with the embedded template and assets.
+func newDashboard(db DB, rb *RepBak) *dashboard {
+	funcs := template.FuncMap{
+		"formatTime": func(t time.Time) string {
+			return t.Format(rb.currentConfig().TimeFormat)
+		},
+		"formatDuration": func(d time.Duration) string {
+			return d.Round(time.Second).String()
+		},
+		"formatBytes": func(stat Stat) string {
+			return formatBytes(statSize(stat))
+		},
+	}
+
+	assets, _ := fs.Sub(dashboardFS, "dashboard")
+
+	return &dashboard{
+		db:     db,
+		repbak: rb,
+		tmpl:   template.Must(template.New("dashboard.html").Funcs(funcs).ParseFS(dashboardFS, "dashboard/dashboard.html")),
+		assets: http.StripPrefix("/assets/", http.FileServer(http.FS(assets))),
+	}
+}
+
+// ServeHTTP renders the dashboard.
+func (d *dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := d.data()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := d.tmpl.Execute(&b, data); err != nil {
+		http.Error(w, fmt.Sprintf("Dashboard: failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	b.WriteTo(w)
+}
+
+// data returns the state and recent runs of each backup job.
+func (d *dashboard) data() (dashboardData, error) {
+	data := dashboardData{
+		SparklineWidth:  sparklineWidth,
+		SparklineHeight: sparklineHeight,
+	}
+
+	for _, job := range d.repbak.Jobs() {
+		health, err := d.repbak.JobHealth(job.Name)
+		if err != nil {
+			return data, err
+		}
+
+		runs, err := d.db.Page(job.Name, time.Time{}, dashboardRuns)
+		if err != nil {
+			return data, err
+		}
+
+		data.Jobs = append(data.Jobs, dashboardJob{
+			Job:       job,
+			Health:    health,
+			Runs:      runs,
+			Sparkline: sparkline(runs, sparklineWidth, sparklineHeight),
+		})
+	}
+
+	return data, nil
+}
+
+// sparkline returns the SVG polyline points of the durations of runs scaled to width and height.
+// Runs are sorted newest first and plotted oldest first. An empty string is returned if there are
+// fewer than 2 runs.
+func sparkline(runs []Stat, width, height int) string {
+	if len(runs) < 2 {
+		return ""
+	}
+
+	var max time.Duration
+	for _, run := range runs {
+		if run.Duration > max {
+			max = run.Duration
+		}
+	}
+
+	step := float64(width) / float64(len(runs)-1)
+	points := make([]string, 0, len(runs))
+	for i := range runs {
+		run := runs[len(runs)-1-i]
+
+		y := float64(height)
+		if max > 0 {
+			y -= float64(run.Duration) / float64(max) * float64(height)
+		}
+
+		points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+
+	return strings.Join(points, " ")
+}
+
+// formatBytes formats n as a human readable size using binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
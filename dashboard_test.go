@@ -0,0 +1,87 @@
+package repbak
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	rb := New(config, db, &testDumper{}, &testNotifier{})
+	server := NewServer(config, db, rb, nil)
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := request("/")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Contains(t, w.Body.String(), "mysqldump")
+	assert.Contains(t, w.Body.String(), "No runs yet.")
+
+	success := NewStat("mysqldump").Finish(nil)
+	success.Start = success.Start.Add(-time.Hour)
+	success.Duration = 90 * time.Second
+	success.BytesWritten = 2048
+	success.Log = "/tmp/run.log"
+	assert.Nil(t, db.Insert(success))
+
+	failure := NewStat("mysqldump").Finish(errors.New("<ERROR>"))
+	assert.Nil(t, db.Insert(failure))
+
+	w = request("/")
+	assert.Equal(t, w.Code, http.StatusOK)
+	body := w.Body.String()
+	assert.Contains(t, body, "state-failed")
+	assert.Contains(t, body, "1m30s")
+	assert.Contains(t, body, "2.0 KiB")
+	assert.Contains(t, body, "&lt;ERROR&gt;")
+	assert.Contains(t, body, "/api/v1/jobs/mysqldump/runs/"+success.RunID+"/log")
+	assert.NotContains(t, body, "/runs/"+failure.RunID+"/log")
+	assert.Contains(t, body, "<polyline")
+
+	w = request("/assets/dashboard.css")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Contains(t, w.Body.String(), ".sparkline")
+
+	w = request("/missing")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestSparkline(t *testing.T) {
+	assert.Equal(t, sparkline(nil, 100, 10), "")
+	assert.Equal(t, sparkline([]Stat{{Duration: time.Second}}, 100, 10), "")
+
+	runs := []Stat{
+		{Duration: 2 * time.Second},
+		{Duration: time.Second},
+		{Duration: 0},
+	}
+	assert.Equal(t, sparkline(runs, 100, 10), "0.0,10.0 50.0,5.0 100.0,0.0")
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, formatBytes(0), "0 B")
+	assert.Equal(t, formatBytes(1023), "1023 B")
+	assert.Equal(t, formatBytes(1536), "1.5 KiB")
+	assert.Equal(t, formatBytes(5*1024*1024*1024), "5.0 GiB")
+}
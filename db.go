@@ -1,5 +1,7 @@
 package repbak
 
+import "time"
+
 // DB defines an interface for persisting Stats. Stats are simple metrics
 // for each job.
 type DB interface {
@@ -10,9 +12,135 @@ type DB interface {
 	//that sysnc. Stats should be returned storted by Start in descending order.
 	List() (map[string][]Stat, error)
 
+	// Latest returns the most recent stat for each job keyed by job name.
+	Latest() (map[string]Stat, error)
+
+	// Range returns the stats for the job name that started at or after from and before to sorted by Start in
+	// descending order.
+	Range(name string, from, to time.Time) ([]Stat, error)
+
+	// LastSuccess returns the most recent successful stat for the job name or nil if there isn't one.
+	LastSuccess(name string) (*Stat, error)
+
+	// LastFailure returns the most recent failed stat for the job name or nil if there isn't one.
+	LastFailure(name string) (*Stat, error)
+
+	// Run returns the stat of the run with runID of the job name or nil if there isn't one.
+	Run(name, runID string) (*Stat, error)
+
+	// Page returns up to limit stats for the job name that started before the given time sorted by Start in
+	// descending order. A zero before starts at the most recent stat. The Start of the last stat returned is
+	// used as before to get the next page.
+	Page(name string, before time.Time, limit int) ([]Stat, error)
+
+	// Aggregate summarizes the stats for the job name that started at or after since.
+	Aggregate(name string, since time.Time) (Aggregate, error)
+
 	// Insert adds a stat to the database.
 	Insert(Stat) error
 
+	// InsertBatch adds stats to the database and prunes once they're all added. It's used to copy many stats.
+	InsertBatch([]Stat) error
+
+	// InsertSuppressed adds a stat whose notification was suppressed by the notification policy.
+	InsertSuppressed(Stat) error
+
+	// ListSuppressed returns all suppressed stats sorted by Start in ascending order.
+	ListSuppressed() ([]Stat, error)
+
+	// PruneSuppressed removes the oldest n suppressed stats. It's used after a digest of the suppressed stats is sent.
+	PruneSuppressed(n int) error
+
+	// PutDelivery adds or replaces an undelivered notification in the outbox.
+	PutDelivery(Delivery) error
+
+	// DeleteDelivery removes a delivered notification from the outbox.
+	DeleteDelivery(id string) error
+
+	// DeadLetter moves a notification from the outbox to the dead letters.
+	DeadLetter(Delivery) error
+
+	// ListDeliveries returns the notifications waiting in the outbox sorted by ID.
+	ListDeliveries() ([]Delivery, error)
+
+	// ListDeadLetters returns the notifications that were never delivered sorted by ID.
+	ListDeadLetters() ([]Delivery, error)
+
+	// SetConfig replaces the config used for retention. Whether stats are stored can't be changed.
+	SetConfig(config *Config)
+
 	// Closes the connection the database
 	Close() error
 }
+
+// OpenDB opens the database selected by the database driver in config.
+func OpenDB(config *Config) (DB, error) {
+	switch config.Database.Driver {
+	case "sqlite":
+		db, err := NewSQLiteDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	case "postgres":
+		db, err := NewPostgresDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		db, err := NewBoltDB(config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+}
+
+// MigrateStats copies the stats, suppressed stats, and notifications in src into dst.
+func MigrateStats(src, dst DB) error {
+	statMap, err := src.List()
+	if err != nil {
+		return err
+	}
+
+	var stats []Stat
+	for _, jobStats := range statMap {
+		stats = append(stats, jobStats...)
+	}
+
+	// prune once at the end instead of after every stat
+	if err := dst.InsertBatch(stats); err != nil {
+		return err
+	}
+
+	suppressed, err := src.ListSuppressed()
+	if err != nil {
+		return err
+	}
+
+	for _, stat := range suppressed {
+		if err := dst.InsertSuppressed(stat); err != nil {
+			return err
+		}
+	}
+
+	status, err := ListOutbox(src)
+	if err != nil {
+		return err
+	}
+
+	for _, delivery := range status.Pending {
+		if err := dst.PutDelivery(delivery); err != nil {
+			return err
+		}
+	}
+
+	for _, delivery := range status.DeadLetters {
+		if err := dst.DeadLetter(delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
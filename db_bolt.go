@@ -1,6 +1,7 @@
 package repbak
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -13,12 +14,50 @@ import (
 	bolt "go.etcd.io/bbolt"
 )
 
+// statsBucket holds a nested bucket of stats for each job, runsBucket holds a nested bucket for each
+// job mapping run IDs to the keys of their stats, skipsBucket holds a nested bucket for each job with
+// the keys of its skipped stats, suppressedBucket holds stats whose notifications were suppressed by
+// the notification policy, outboxBucket holds notifications waiting to be retried, and
+// deadLetterBucket holds notifications that were never delivered.
+var (
+	statsBucket      = []byte("stats")
+	runsBucket       = []byte("runs")
+	skipsBucket      = []byte("skips")
+	suppressedBucket = []byte("suppressed")
+	outboxBucket     = []byte("outbox")
+	deadLetterBucket = []byte("deadletter")
+	rootBuckets      = [][]byte{statsBucket, runsBucket, skipsBucket, suppressedBucket, outboxBucket, deadLetterBucket}
+)
+
+// statKeyFormat is the fixed width UTC time stats are keyed by so keys sort by start time.
+const statKeyFormat = "2006-01-02T15:04:05.000000000Z"
+
+// statKey returns the key a stat that started at start is stored under.
+func statKey(start time.Time) []byte {
+	return []byte(start.UTC().Format(statKeyFormat))
+}
+
+// maxSuppressed caps the number of stored suppressed stats in case a digest is never sent.
+const maxSuppressed = 1000
+
+// maxSkips caps the number of stored skipped backups of each job. Skips don't count toward the
+// retention so maintenance mode and blackouts can't push the real runs out of the history.
+const maxSkips = 50
+
+// maxDeadLetters caps the number of stored dead lettered notifications.
+const maxDeadLetters = 1000
+
+// compactThreshold is the number of stats removed by a single prune after which the bolt file is
+// compacted to reclaim the freed space.
+const compactThreshold = 500
+
 // BoltDB is the default and only database for storing stats. In the future
 // other databases could be added.
 type BoltDB struct {
-	config *Config
-	mu     sync.Mutex
-	db     *bolt.DB
+	config   *Config
+	configMu sync.RWMutex
+	mu       sync.RWMutex
+	db       *bolt.DB
 }
 
 // NewBoltDB creates the underlying boltdb database. If retention is less than 1 than
@@ -43,16 +82,263 @@ func NewBoltDB(config *Config) (*BoltDB, error) {
 
 	boltdb := &BoltDB{
 		config: config,
-		mu:     sync.Mutex{},
+		mu:     sync.RWMutex{},
 		db:     db,
 	}
 
+	if err := boltdb.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return boltdb, boltdb.Prune()
 }
 
+// migrate moves job buckets from the root of the database, where older versions of repbak stored
+// them, into the stats bucket, upgrades stats stored before schema version 2, and indexes the
+// skipped stats of databases created before skips were indexed.
+func (s *BoltDB) migrate() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		indexed := tx.Bucket(skipsBucket) != nil
+		for _, name := range rootBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return fmt.Errorf("BoltDB: create bucket: %s", err)
+			}
+		}
+		stats := tx.Bucket(statsBucket)
+
+		var legacy [][]byte
+		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			for _, root := range rootBuckets {
+				if bytes.Equal(name, root) {
+					return nil
+				}
+			}
+			legacy = append(legacy, name)
+			return nil
+		})
+
+		for _, name := range legacy {
+			b, err := stats.CreateBucketIfNotExists(name)
+			if err != nil {
+				return fmt.Errorf("BoltDB: create bucket: %s", err)
+			}
+
+			err = tx.Bucket(name).ForEach(func(k, v []byte) error {
+				return b.Put(k, v)
+			})
+			if err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+
+			if err := tx.DeleteBucket(name); err != nil {
+				return fmt.Errorf("BoltDB: delete bucket: %s", err)
+			}
+
+			log.Infof("BoltDB: migrated stats for %s", name)
+		}
+
+		if err := s.upgrade(tx); err != nil {
+			return err
+		}
+
+		if indexed {
+			return nil
+		}
+		return indexSkips(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed migration: %s", err)
+	}
+	return nil
+}
+
+// indexSkips adds the keys of every skipped stat to the skips bucket.
+func indexSkips(tx *bolt.Tx) error {
+	return tx.Bucket(statsBucket).ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+
+		skips, err := tx.Bucket(skipsBucket).CreateBucketIfNotExists(name)
+		if err != nil {
+			return fmt.Errorf("BoltDB: create bucket: %s", err)
+		}
+
+		return tx.Bucket(statsBucket).Bucket(name).ForEach(func(k, v []byte) error {
+			if stat, ok := decodeStat(v); !ok || !stat.Skip {
+				return nil
+			}
+			if err := skips.Put(k, []byte{}); err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+			return nil
+		})
+	})
+}
+
+// legacyStat holds the times of a stat stored before schema version 2 which were strings formatted
+// with time_format.
+type legacyStat struct {
+	SchemaVersion int
+	Start         string
+	End           string
+}
+
+// upgrade converts stats stored before schema version 2. Stats are rekeyed by statKey and their
+// times are recovered from the keys they were stored under, falling back to parsing the stored
+// strings with time_format.
+func (s *BoltDB) upgrade(tx *bolt.Tx) error {
+	stats := tx.Bucket(statsBucket)
+
+	var names [][]byte
+	stats.ForEach(func(name, v []byte) error {
+		if v == nil {
+			names = append(names, name)
+		}
+		return nil
+	})
+
+	for _, name := range names {
+		b := stats.Bucket(name)
+
+		// buckets can't be modified while iterating over them so collect the upgrades first
+		upgraded := make(map[string][]byte)
+		b.ForEach(func(k, v []byte) error {
+			start, _ := time.Parse(time.RFC3339Nano, string(k))
+			if encoded, ok := s.upgradeStat(v, start); ok {
+				upgraded[string(k)] = encoded
+			}
+			return nil
+		})
+
+		for k, encoded := range upgraded {
+			stat := Stat{}
+			json.Unmarshal(encoded, &stat)
+
+			if err := b.Delete([]byte(k)); err != nil {
+				return fmt.Errorf("BoltDB: delete: %s", err)
+			}
+			if err := b.Put(statKey(stat.Start), encoded); err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+		}
+
+		if len(upgraded) > 0 {
+			log.Infof("BoltDB: upgraded %d stats for %s", len(upgraded), name)
+		}
+	}
+
+	// suppressed stats are keyed by their start time and name
+	suppressed := tx.Bucket(suppressedBucket)
+	upgraded := make(map[string][]byte)
+	suppressed.ForEach(func(k, v []byte) error {
+		prefix, _, _ := bytes.Cut(k, []byte("/"))
+		start, _ := time.Parse(runLogTimeFormat, string(prefix))
+		if encoded, ok := s.upgradeStat(v, start); ok {
+			upgraded[string(k)] = encoded
+		}
+		return nil
+	})
+	for k, encoded := range upgraded {
+		if err := suppressed.Put([]byte(k), encoded); err != nil {
+			return fmt.Errorf("BoltDB: put: %s", err)
+		}
+	}
+
+	// stats waiting in notifications only have the stored strings
+	for _, bucket := range [][]byte{outboxBucket, deadLetterBucket} {
+		b := tx.Bucket(bucket)
+		upgraded := make(map[string][]byte)
+		b.ForEach(func(k, v []byte) error {
+			if encoded, ok := s.upgradeDelivery(v); ok {
+				upgraded[string(k)] = encoded
+			}
+			return nil
+		})
+		for k, encoded := range upgraded {
+			if err := b.Put([]byte(k), encoded); err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// upgradeStat returns the encoded stat converted to the current schema version and true if v needed
+// upgrading. Start is used as the start time unless it's zero.
+func (s *BoltDB) upgradeStat(v []byte, start time.Time) ([]byte, bool) {
+	legacy := legacyStat{}
+	if err := json.Unmarshal(v, &legacy); err != nil || legacy.SchemaVersion >= 2 {
+		return nil, false
+	}
+
+	stat := Stat{}
+	if err := json.Unmarshal(v, &stat); err != nil {
+		return nil, false
+	}
+
+	encoded, err := json.Marshal(s.upgradeLegacyStat(stat, legacy, start))
+	if err != nil {
+		return nil, false
+	}
+	return encoded, true
+}
+
+// upgradeDelivery returns the encoded delivery with its stats converted to the current schema
+// version and true if any needed upgrading.
+func (s *BoltDB) upgradeDelivery(v []byte) ([]byte, bool) {
+	delivery := Delivery{}
+	if err := json.Unmarshal(v, &delivery); err != nil {
+		return nil, false
+	}
+
+	legacy := struct{ Stats []legacyStat }{}
+	if err := json.Unmarshal(v, &legacy); err != nil || len(legacy.Stats) != len(delivery.Stats) {
+		return nil, false
+	}
+
+	upgraded := false
+	for i, stat := range legacy.Stats {
+		if stat.SchemaVersion < 2 {
+			delivery.Stats[i] = s.upgradeLegacyStat(delivery.Stats[i], stat, time.Time{})
+			upgraded = true
+		}
+	}
+
+	if !upgraded {
+		return nil, false
+	}
+
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return nil, false
+	}
+	return encoded, true
+}
+
+func (s *BoltDB) upgradeLegacyStat(stat Stat, legacy legacyStat, start time.Time) Stat {
+	if start.IsZero() {
+		start, _ = time.ParseInLocation(s.cfg().TimeFormat, legacy.Start, time.Local)
+	}
+
+	stat.SchemaVersion = statSchemaVersion
+	stat.Start = start
+	if !start.IsZero() {
+		stat.End = start.Add(stat.Duration)
+	}
+	return stat
+}
+
 // Insert adds one Stat to bolt.
 func (s *BoltDB) Insert(stat Stat) error {
-	if s.config.Retention < 0 {
+	return s.InsertBatch([]Stat{stat})
+}
+
+// InsertBatch adds stats to the database in a single transaction and prunes once they're all added.
+func (s *BoltDB) InsertBatch(stats []Stat) error {
+	if s.cfg().Retention < 0 {
 		return nil
 	}
 
@@ -61,20 +347,51 @@ func (s *BoltDB) Insert(stat Stat) error {
 	defer s.mu.Unlock()
 
 	err := s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(stat.Name))
-		if err != nil {
-			return fmt.Errorf("BoltDB: create bucket: %s", err)
-		}
+		for _, stat := range stats {
+			b, err := tx.Bucket(statsBucket).CreateBucketIfNotExists([]byte(stat.Name))
+			if err != nil {
+				return fmt.Errorf("BoltDB: create bucket: %s", err)
+			}
 
-		// store stat in bolt JSON encoded
-		encoded, err := json.Marshal(stat)
-		if err != nil {
-			return fmt.Errorf("BoltDB: marshal json: %s", err)
-		}
+			// store stat in bolt JSON encoded
+			encoded, err := json.Marshal(stat)
+			if err != nil {
+				return fmt.Errorf("BoltDB: marshal json: %s", err)
+			}
 
-		// store stat by sortable start time
-		if err := b.Put([]byte(stat.start.Format(time.RFC3339Nano)), encoded); err != nil {
-			return fmt.Errorf("BoltDB: put: %s", err)
+			// store stat by sortable start time
+			key := statKey(stat.Start)
+			if err := b.Put(key, encoded); err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+
+			// skips are indexed so they can be counted without decoding every stat
+			skips, err := tx.Bucket(skipsBucket).CreateBucketIfNotExists([]byte(stat.Name))
+			if err != nil {
+				return fmt.Errorf("BoltDB: create bucket: %s", err)
+			}
+
+			if stat.Skip {
+				err = skips.Put(key, []byte{})
+			} else {
+				err = skips.Delete(key)
+			}
+			if err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
+
+			if stat.RunID == "" {
+				continue
+			}
+
+			runs, err := tx.Bucket(runsBucket).CreateBucketIfNotExists([]byte(stat.Name))
+			if err != nil {
+				return fmt.Errorf("BoltDB: create bucket: %s", err)
+			}
+
+			if err := runs.Put([]byte(stat.RunID), key); err != nil {
+				return fmt.Errorf("BoltDB: put: %s", err)
+			}
 		}
 
 		return nil
@@ -86,7 +403,8 @@ func (s *BoltDB) Insert(stat Stat) error {
 	return s.prune()
 }
 
-// Prune removes all entries for a Sync job that exceed the rentention config.
+// Prune removes the entries for each backup that exceed the retention count or max age along with
+// their run logs.
 func (s *BoltDB) Prune() error {
 	// only one goroutine can do a read/write bold transaction at a time
 	s.mu.Lock()
@@ -96,31 +414,199 @@ func (s *BoltDB) Prune() error {
 }
 
 func (s *BoltDB) prune() error {
-	if s.config.Retention < 0 {
+	if s.cfg().Retention < 0 {
 		return nil
 	}
 
+	now := time.Now()
+	pruned := 0
+
 	err := s.db.Update(func(tx *bolt.Tx) error {
-		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			stats := b.Stats()
-			count := stats.KeyN
+		stats := tx.Bucket(statsBucket)
+
+		// buckets can't be modified while iterating over them so collect the job names first
+		var names [][]byte
+		stats.ForEach(func(name, v []byte) error {
+			if v == nil {
+				names = append(names, name)
+			}
+			return nil
+		})
+
+		for _, name := range names {
+			max, maxAge := s.cfg().statsRetention(string(name))
+
+			// keys sort by start time so everything before the cutoff key is too old
+			var cutoff []byte
+			if maxAge > 0 {
+				cutoff = statKey(now.Add(-maxAge))
+			}
+
+			b := stats.Bucket(name)
 			cursor := b.Cursor()
-			cursor.First()
 
-			for count > s.config.Retention {
+			// deleting moves the cursor to the next entry so always delete the first (oldest) entry
+			for k, v := cursor.First(); k != nil && cutoff != nil && bytes.Compare(k, cutoff) < 0; k, v = cursor.First() {
+				if err := removeStat(s.cfg(), tx, name, k, v); err != nil {
+					return err
+				}
 				if err := cursor.Delete(); err != nil {
 					return fmt.Errorf("BoltDB: failed delete: %s", err)
 				}
-				cursor.Next()
-				count--
+				pruned++
 			}
-			return nil
-		})
-		return nil
+
+			if max <= 0 {
+				continue
+			}
+
+			// skipped backups don't count toward max and are capped separately
+			skipped := 0
+			skips := tx.Bucket(skipsBucket).Bucket(name)
+			if skips != nil {
+				skipped = skips.Stats().KeyN
+			}
+			runs := b.Stats().KeyN - skipped
+			if runs <= max && skipped <= maxSkips {
+				continue
+			}
+
+			// both buckets sort by start time so they're walked together from the oldest entry
+			var skipCursor *bolt.Cursor
+			var skipKey []byte
+			if skips != nil {
+				skipCursor = skips.Cursor()
+				skipKey, _ = skipCursor.First()
+			}
+
+			var expired [][]byte
+			for k, _ := cursor.First(); k != nil && (runs > max || skipped > maxSkips); k, _ = cursor.Next() {
+				for skipKey != nil && bytes.Compare(skipKey, k) < 0 {
+					skipKey, _ = skipCursor.Next()
+				}
+
+				if bytes.Equal(skipKey, k) {
+					if skipped <= maxSkips {
+						continue
+					}
+					skipped--
+				} else {
+					if runs <= max {
+						continue
+					}
+					runs--
+				}
+
+				expired = append(expired, append([]byte(nil), k...))
+			}
+
+			for _, k := range expired {
+				if err := removeStat(s.cfg(), tx, name, k, b.Get(k)); err != nil {
+					return err
+				}
+				if err := b.Delete(k); err != nil {
+					return fmt.Errorf("BoltDB: failed delete: %s", err)
+				}
+				pruned++
+			}
+		}
+
+		return pruneCount(tx.Bucket(suppressedBucket), maxSuppressed)
 	})
 	if err != nil {
 		return fmt.Errorf("BoltDB: failed transaction: %s", err)
 	}
+
+	if pruned >= compactThreshold {
+		log.Infof("BoltDB: compacting after pruning %d stats", pruned)
+		return s.compact()
+	}
+	return nil
+}
+
+// compact rewrites the bolt file to reclaim the space freed by pruning. The write lock must be held.
+func (s *BoltDB) compact() error {
+	path := s.db.Path()
+	tmp := path + ".compact"
+
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("BoltDB: failed to remove %s: %w", tmp, err)
+	}
+
+	dst, err := bolt.Open(tmp, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return fmt.Errorf("BoltDB: Failed to open bolt database %s: %w", tmp, err)
+	}
+
+	if err := bolt.Compact(dst, s.db, 64*1024*1024); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("BoltDB: failed to compact: %w", err)
+	}
+
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("BoltDB: failed to compact: %w", err)
+	}
+
+	if err := s.db.Close(); err != nil {
+		return fmt.Errorf("BoltDB: failed to close bolt database %s: %w", path, err)
+	}
+
+	// reopen the original file if the compacted copy can't replace it
+	renameErr := os.Rename(tmp, path)
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return fmt.Errorf("BoltDB: Failed to open bolt database %s: %w", path, err)
+	}
+	s.db = db
+
+	if renameErr != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("BoltDB: failed to replace %s with compacted copy: %w", path, renameErr)
+	}
+	return nil
+}
+
+// removeStat removes the run log of the encoded stat stored under k of the job name and removes the
+// stat from the run ID and skip indexes before it's deleted.
+func removeStat(config *Config, tx *bolt.Tx, name, k, v []byte) error {
+	if skips := tx.Bucket(skipsBucket).Bucket(name); skips != nil {
+		if err := skips.Delete(k); err != nil {
+			return fmt.Errorf("BoltDB: failed delete: %s", err)
+		}
+	}
+
+	stat := Stat{}
+	if err := json.Unmarshal(v, &stat); err != nil {
+		log.Error(err)
+		return nil
+	}
+
+	if err := removeRunLog(config, stat.Name, stat.Log); err != nil {
+		log.Error(err)
+	}
+
+	if runIDs := tx.Bucket(runsBucket).Bucket(name); runIDs != nil && stat.RunID != "" {
+		if err := runIDs.Delete([]byte(stat.RunID)); err != nil {
+			return fmt.Errorf("BoltDB: failed delete: %s", err)
+		}
+	}
+	return nil
+}
+
+// pruneCount deletes the oldest entries in b until at most max remain.
+func pruneCount(b *bolt.Bucket, max int) error {
+	count := b.Stats().KeyN
+	cursor := b.Cursor()
+
+	for k, _ := cursor.First(); k != nil && count > max; k, _ = cursor.First() {
+		if err := cursor.Delete(); err != nil {
+			return fmt.Errorf("BoltDB: failed delete: %s", err)
+		}
+		count--
+	}
 	return nil
 }
 
@@ -129,13 +615,22 @@ func (s *BoltDB) prune() error {
 func (s *BoltDB) List() (map[string][]Stat, error) {
 	statMap := make(map[string][]Stat)
 
-	if s.config.Retention < 0 {
+	if s.cfg().Retention < 0 {
 		return statMap, nil
 	}
 
+	// the database may be reopened by compaction
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	err := s.db.View(func(tx *bolt.Tx) error {
-		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			b.ForEach(func(k, v []byte) error {
+		stats := tx.Bucket(statsBucket)
+		stats.ForEach(func(name, v []byte) error {
+			if v != nil {
+				return nil
+			}
+
+			stats.Bucket(name).ForEach(func(k, v []byte) error {
 				stat := Stat{}
 				if err := json.Unmarshal(v, &stat); err != nil {
 					// stat can't be read so just skip it and log the error.
@@ -158,19 +653,454 @@ func (s *BoltDB) List() (map[string][]Stat, error) {
 
 	// return sorted by start desc
 	for _, stats := range statMap {
-		sort.Slice(stats, func(i, j int) bool {
-			return j < i
+		sort.SliceStable(stats, func(i, j int) bool {
+			return stats[i].Start.After(stats[j].Start)
 		})
 	}
 
 	return statMap, nil
 }
 
+// Latest returns the most recent stat for each job keyed by job name.
+func (s *BoltDB) Latest() (map[string]Stat, error) {
+	latest := make(map[string]Stat)
+
+	if s.cfg().Retention < 0 {
+		return latest, nil
+	}
+
+	// the database may be reopened by compaction
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket(statsBucket)
+		return stats.ForEach(func(name, v []byte) error {
+			if v != nil {
+				return nil
+			}
+
+			cursor := stats.Bucket(name).Cursor()
+			for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+				if stat, ok := decodeStat(v); ok {
+					latest[string(name)] = stat
+					break
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+
+	return latest, nil
+}
+
+// Range returns the stats for the job name that started at or after from and before to sorted by Start in
+// descending order.
+func (s *BoltDB) Range(name string, from, to time.Time) ([]Stat, error) {
+	stats := []Stat{}
+
+	err := s.viewJob(name, func(b *bolt.Bucket) error {
+		min, max := statKey(from), statKey(to)
+
+		cursor := b.Cursor()
+		for k, v := cursor.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, v = cursor.Next() {
+			if stat, ok := decodeStat(v); ok {
+				stats = append(stats, stat)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// keys are ascending so reverse for newest first
+	for i, j := 0, len(stats)-1; i < j; i, j = i+1, j-1 {
+		stats[i], stats[j] = stats[j], stats[i]
+	}
+
+	return stats, nil
+}
+
+// LastSuccess returns the most recent successful stat for the job name or nil if there isn't one.
+func (s *BoltDB) LastSuccess(name string) (*Stat, error) {
+	return s.last(name, true)
+}
+
+// LastFailure returns the most recent failed stat for the job name or nil if there isn't one.
+func (s *BoltDB) LastFailure(name string) (*Stat, error) {
+	return s.last(name, false)
+}
+
+func (s *BoltDB) last(name string, success bool) (*Stat, error) {
+	var last *Stat
+
+	err := s.viewJob(name, func(b *bolt.Bucket) error {
+		cursor := b.Cursor()
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			// skipped backups and retried attempts aren't failures
+			if stat, ok := decodeStat(v); ok && stat.Success == success && !stat.Skip && !stat.Retried {
+				last = &stat
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return last, nil
+}
+
+// Run returns the stat of the run with runID of the job name or nil if there isn't one.
+func (s *BoltDB) Run(name, runID string) (*Stat, error) {
+	var run *Stat
+
+	err := s.viewJob(name, func(b *bolt.Bucket) error {
+		runIDs := b.Tx().Bucket(runsBucket).Bucket([]byte(name))
+		if runIDs == nil {
+			return nil
+		}
+
+		key := runIDs.Get([]byte(runID))
+		if key == nil {
+			return nil
+		}
+
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+
+		// the stat may have been replaced by another run that started at the same time
+		if stat, ok := decodeStat(v); ok && stat.RunID == runID {
+			run = &stat
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Page returns up to limit stats for the job name that started before the given time sorted by Start in
+// descending order. A zero before starts at the most recent stat.
+func (s *BoltDB) Page(name string, before time.Time, limit int) ([]Stat, error) {
+	stats := []Stat{}
+
+	err := s.viewJob(name, func(b *bolt.Bucket) error {
+		cursor := b.Cursor()
+
+		var k, v []byte
+		if before.IsZero() {
+			k, v = cursor.Last()
+		} else if k, _ = cursor.Seek(statKey(before)); k == nil {
+			// everything started before so start from the end
+			k, v = cursor.Last()
+		} else {
+			k, v = cursor.Prev()
+		}
+
+		for ; k != nil && len(stats) < limit; k, v = cursor.Prev() {
+			if stat, ok := decodeStat(v); ok {
+				stats = append(stats, stat)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Aggregate summarizes the stats for the job name that started at or after since.
+func (s *BoltDB) Aggregate(name string, since time.Time) (Aggregate, error) {
+	stats := []Stat{}
+
+	err := s.viewJob(name, func(b *bolt.Bucket) error {
+		cursor := b.Cursor()
+		for k, v := cursor.Seek(statKey(since)); k != nil; k, v = cursor.Next() {
+			if stat, ok := decodeStat(v); ok {
+				stats = append(stats, stat)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return Aggregate{}, err
+	}
+
+	return AggregateStats(name, stats), nil
+}
+
+// viewJob calls fn with the stats bucket for the job name in a read transaction. fn isn't called if the
+// job doesn't have any stats.
+func (s *BoltDB) viewJob(name string, fn func(b *bolt.Bucket) error) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// the database may be reopened by compaction
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(statsBucket).Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return fn(b)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// decodeStat decodes a stored stat. Stats that can't be read are logged and skipped. They will
+// eventually be pruned.
+func decodeStat(v []byte) (Stat, bool) {
+	stat := Stat{}
+	if err := json.Unmarshal(v, &stat); err != nil {
+		log.Error(err)
+		return stat, false
+	}
+	return stat, true
+}
+
+// InsertSuppressed adds a stat whose notification was suppressed by the notification policy.
+func (s *BoltDB) InsertSuppressed(stat Stat) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// only one goroutine can do a read/write bold transaction at a time
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		encoded, err := json.Marshal(stat)
+		if err != nil {
+			return fmt.Errorf("BoltDB: marshal json: %s", err)
+		}
+
+		// store by sortable start time and name since different jobs may start at the same time
+		key := stat.Start.UTC().Format(runLogTimeFormat) + "/" + stat.Name
+		if err := tx.Bucket(suppressedBucket).Put([]byte(key), encoded); err != nil {
+			return fmt.Errorf("BoltDB: put: %s", err)
+		}
+
+		return pruneCount(tx.Bucket(suppressedBucket), maxSuppressed)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// ListSuppressed returns all suppressed stats sorted by Start in ascending order.
+func (s *BoltDB) ListSuppressed() ([]Stat, error) {
+	stats := []Stat{}
+
+	if s.cfg().Retention < 0 {
+		return stats, nil
+	}
+
+	// the database may be reopened by compaction
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(suppressedBucket).ForEach(func(k, v []byte) error {
+			stat := Stat{}
+			if err := json.Unmarshal(v, &stat); err != nil {
+				// stat can't be read so just skip it and log the error.
+				log.Error(err)
+				return nil
+			}
+
+			stats = append(stats, stat)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+
+	return stats, nil
+}
+
+// PruneSuppressed removes the oldest n suppressed stats.
+func (s *BoltDB) PruneSuppressed(n int) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// only one goroutine can do a read/write bold transaction at a time
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(suppressedBucket)
+		return pruneCount(b, b.Stats().KeyN-n)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// PutDelivery adds or replaces an undelivered notification in the outbox.
+func (s *BoltDB) PutDelivery(delivery Delivery) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// only one goroutine can do a read/write bold transaction at a time
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		encoded, err := json.Marshal(delivery)
+		if err != nil {
+			return fmt.Errorf("BoltDB: marshal json: %s", err)
+		}
+
+		if err := tx.Bucket(outboxBucket).Put([]byte(delivery.ID), encoded); err != nil {
+			return fmt.Errorf("BoltDB: put: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// DeleteDelivery removes a delivered notification from the outbox.
+func (s *BoltDB) DeleteDelivery(id string) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// only one goroutine can do a read/write bold transaction at a time
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// DeadLetter moves a notification from the outbox to the dead letters.
+func (s *BoltDB) DeadLetter(delivery Delivery) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	// only one goroutine can do a read/write bold transaction at a time
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		encoded, err := json.Marshal(delivery)
+		if err != nil {
+			return fmt.Errorf("BoltDB: marshal json: %s", err)
+		}
+
+		if err := tx.Bucket(outboxBucket).Delete([]byte(delivery.ID)); err != nil {
+			return fmt.Errorf("BoltDB: delete: %s", err)
+		}
+
+		if err := tx.Bucket(deadLetterBucket).Put([]byte(delivery.ID), encoded); err != nil {
+			return fmt.Errorf("BoltDB: put: %s", err)
+		}
+
+		return pruneCount(tx.Bucket(deadLetterBucket), maxDeadLetters)
+	})
+	if err != nil {
+		return fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the notifications waiting in the outbox sorted by ID.
+func (s *BoltDB) ListDeliveries() ([]Delivery, error) {
+	return s.listDeliveries(outboxBucket)
+}
+
+// ListDeadLetters returns the notifications that were never delivered sorted by ID.
+func (s *BoltDB) ListDeadLetters() ([]Delivery, error) {
+	return s.listDeliveries(deadLetterBucket)
+}
+
+func (s *BoltDB) listDeliveries(bucket []byte) ([]Delivery, error) {
+	deliveries := []Delivery{}
+
+	if s.cfg().Retention < 0 {
+		return deliveries, nil
+	}
+
+	// the database may be reopened by compaction
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).ForEach(func(k, v []byte) error {
+			delivery := Delivery{}
+			if err := json.Unmarshal(v, &delivery); err != nil {
+				// delivery can't be read so just skip it and log the error.
+				log.Error(err)
+				return nil
+			}
+
+			deliveries = append(deliveries, delivery)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BoltDB: failed transaction: %s", err)
+	}
+
+	return deliveries, nil
+}
+
+// SetConfig replaces the config used for retention. The retention can't be enabled or disabled.
+func (s *BoltDB) SetConfig(config *Config) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.config = config
+}
+
+// cfg returns the current config.
+func (s *BoltDB) cfg() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
 // Close closes the bolt database file.
 func (s *BoltDB) Close() error {
-	if s.config.Retention < 0 {
+	if s.cfg().Retention < 0 {
 		return nil
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.db.Close()
 }
@@ -1,10 +1,14 @@
 package repbak
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
 )
 
 func TestDB(t *testing.T) {
@@ -21,15 +25,15 @@ func TestDB(t *testing.T) {
 	assert.Nil(t, err)
 	defer db.Close()
 
-	stat1 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat2 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat3 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat4 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
+	stat1 := NewStat("TEST").Finish(nil)
+	stat2 := NewStat("TEST").Finish(nil)
+	stat3 := NewStat("TEST").Finish(nil)
+	stat4 := NewStat("TEST").Finish(nil)
 
-	stat5 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat6 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat7 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat8 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
+	stat5 := NewStat("TEST2").Finish(nil)
+	stat6 := NewStat("TEST2").Finish(nil)
+	stat7 := NewStat("TEST2").Finish(nil)
+	stat8 := NewStat("TEST2").Finish(nil)
 
 	err = db.Insert(stat1)
 	assert.Nil(t, err)
@@ -66,6 +70,19 @@ func TestDB(t *testing.T) {
 	assert.Len(t, stats, 2)
 	assert.Len(t, stats["TEST"], int(3))
 	assert.Len(t, stats["TEST2"], 3)
+
+	// runs are found by ID until they're pruned
+	run, err := db.Run("TEST", stat4.RunID)
+	assert.Nil(t, err)
+	assert.Equal(t, run.RunID, stat4.RunID)
+
+	run, err = db.Run("TEST", stat1.RunID)
+	assert.Nil(t, err)
+	assert.Nil(t, run)
+
+	run, err = db.Run("TEST2", stat4.RunID)
+	assert.Nil(t, err)
+	assert.Nil(t, run)
 }
 
 func TestDBWithoutRetention(t *testing.T) {
@@ -82,15 +99,15 @@ func TestDBWithoutRetention(t *testing.T) {
 	assert.Nil(t, err)
 	defer db.Close()
 
-	stat1 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat2 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat3 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat4 := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
+	stat1 := NewStat("TEST").Finish(nil)
+	stat2 := NewStat("TEST").Finish(nil)
+	stat3 := NewStat("TEST").Finish(nil)
+	stat4 := NewStat("TEST").Finish(nil)
 
-	stat5 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat6 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat7 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
-	stat8 := NewStat("TEST2", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
+	stat5 := NewStat("TEST2").Finish(nil)
+	stat6 := NewStat("TEST2").Finish(nil)
+	stat7 := NewStat("TEST2").Finish(nil)
+	stat8 := NewStat("TEST2").Finish(nil)
 
 	err = db.Insert(stat1)
 	assert.Nil(t, err)
@@ -124,3 +141,436 @@ func TestDBWithoutRetention(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, stats, 0)
 }
+
+func TestDBPruneRunLogs(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 2,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 4; i++ {
+		stat := NewStat("TEST")
+		runLog, err := NewRunLog(config, stat.Name, stat.Start)
+		assert.Nil(t, err)
+		assert.Nil(t, runLog.Close())
+		stat.Log = runLog.Path()
+
+		err = db.Insert(stat.Finish(nil))
+		assert.Nil(t, err)
+	}
+
+	ids, err := ListRunLogs(config, "TEST")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+
+	stats, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, stats["TEST"], 2)
+	for _, stat := range stats["TEST"] {
+		_, err := os.Stat(stat.Log)
+		assert.Nil(t, err)
+	}
+}
+
+func TestDBSuppressed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		err = db.InsertSuppressed(NewStat("TEST").Finish(errors.New("ERROR")))
+		assert.Nil(t, err)
+	}
+
+	// suppressed stats aren't listed with the job stats
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap, 0)
+
+	stats, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 5)
+
+	err = db.PruneSuppressed(3)
+	assert.Nil(t, err)
+
+	stats, err = db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 2)
+
+	err = db.PruneSuppressed(3)
+	assert.Nil(t, err)
+
+	stats, err = db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 0)
+}
+
+func TestDBMigrateLegacyBuckets(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// older versions stored a bucket for each job at the root of the database
+	legacy, err := bolt.Open(filepath.Join(dir, "repbak.db"), 0600, nil)
+	assert.Nil(t, err)
+	err = legacy.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("mysqldump"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("2022-12-06T00:00:00.123-05:00"), []byte(`{"Name":"mysqldump","Success":true,"Start":"Tue Dec 06 12:00:00 AM EST","End":"Tue Dec 06 12:00:05 AM EST","Duration":5000000000}`))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, legacy.Close())
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap, 1)
+	assert.Len(t, statMap["mysqldump"], 1)
+	assert.True(t, statMap["mysqldump"][0].Success)
+
+	// times are recovered from the key and the stat is rekeyed by the fixed width UTC start
+	start := time.Date(2022, 12, 6, 5, 0, 0, 123000000, time.UTC)
+	stat := statMap["mysqldump"][0]
+	assert.Equal(t, stat.SchemaVersion, statSchemaVersion)
+	assert.True(t, stat.Start.Equal(start))
+	assert.True(t, stat.End.Equal(start.Add(5*time.Second)))
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		assert.NotNil(t, tx.Bucket(statsBucket).Bucket([]byte("mysqldump")).Get(statKey(start)))
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func TestDBUpgradeLegacyNotifications(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	legacy, err := bolt.Open(filepath.Join(dir, "repbak.db"), 0600, nil)
+	assert.Nil(t, err)
+	err = legacy.Update(func(tx *bolt.Tx) error {
+		for _, name := range rootBuckets {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(suppressedBucket).Put([]byte("20221206T050000.000000000Z/TEST"), []byte(`{"SchemaVersion":1,"Name":"TEST","Start":"2022-12-06T00:00:00-05:00"}`)); err != nil {
+			return err
+		}
+		return tx.Bucket(outboxBucket).Put([]byte("ID"), []byte(`{"ID":"ID","Kind":"notify","Stats":[{"SchemaVersion":1,"Name":"TEST","Start":"2022-12-06T00:00:00-05:00","Duration":1000000000}]}`))
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, legacy.Close())
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  3,
+		TimeFormat: time.RFC3339,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	start := time.Date(2022, 12, 6, 5, 0, 0, 0, time.UTC)
+
+	suppressed, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 1)
+	assert.True(t, suppressed[0].Start.Equal(start))
+
+	deliveries, err := db.ListDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.True(t, deliveries[0].Stats[0].Start.Equal(start))
+	assert.True(t, deliveries[0].Stats[0].End.Equal(start.Add(time.Second)))
+}
+
+func TestDBPruneMaxAge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:         dir,
+		Retention:       0,
+		retentionMaxAge: 48 * time.Hour,
+		MySQLDump: &MySQLDump{
+			StatsRetention: 1,
+		},
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	for _, age := range []time.Duration{72 * time.Hour, 24 * time.Hour, time.Hour} {
+		for _, name := range []string{"TEST", "mysqldump"} {
+			stat := NewStat(name)
+			stat.Start = stat.Start.Add(-age)
+			assert.Nil(t, db.Insert(stat.Finish(nil)))
+		}
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+
+	// stats older than the max age are pruned and mysqldump overrides the count
+	assert.Len(t, statMap["TEST"], 2)
+	assert.Len(t, statMap["mysqldump"], 1)
+	assert.True(t, statMap["mysqldump"][0].Start.After(time.Now().Add(-2*time.Hour)))
+}
+
+func TestDBPruneSkips(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repback_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// skipped backups don't push the runs out of the history
+	start := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Second)
+		assert.Nil(t, db.Insert(stat.Finish(nil)))
+	}
+	for i := 0; i < maxSkips+10; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i+1) * time.Minute)
+		stat.Skip = true
+		assert.Nil(t, db.Insert(stat))
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3+maxSkips)
+
+	last, err := db.LastSuccess("TEST")
+	assert.Nil(t, err)
+	assert.True(t, last.Start.Equal(start.Add(2*time.Second)))
+
+	// the oldest skips are pruned
+	skips := 0
+	for _, stat := range statMap["TEST"] {
+		if stat.Skip {
+			skips++
+			assert.False(t, stat.Start.Before(start.Add(11*time.Minute)))
+		}
+	}
+	assert.Equal(t, skips, maxSkips)
+
+	// runs past the retention are still pruned
+	stat := NewStat("TEST")
+	stat.Start = start.Add(2 * time.Hour)
+	assert.Nil(t, db.Insert(stat.Finish(nil)))
+
+	statMap, err = db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3+maxSkips)
+}
+
+func TestDBIndexSkips(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repback_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+
+	start := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < maxSkips; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Minute)
+		stat.Skip = true
+		assert.Nil(t, db.Insert(stat))
+	}
+	assert.Nil(t, db.Close())
+
+	// databases from older versions don't have the skip index
+	legacy, err := bolt.Open(filepath.Join(dir, "repbak.db"), 0600, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, legacy.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(skipsBucket)
+	}))
+	assert.Nil(t, legacy.Close())
+
+	db, err = NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// the skips are indexed again so they don't count against the retention
+	for i := 0; i < 3; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i+1) * time.Hour)
+		assert.Nil(t, db.Insert(stat.Finish(nil)))
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3+maxSkips)
+}
+
+func TestDBCompact(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: compactThreshold * 2,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < compactThreshold*2; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Millisecond)
+		assert.Nil(t, db.Insert(stat.Finish(nil)))
+	}
+
+	before, err := os.Stat(filepath.Join(dir, "repbak.db"))
+	assert.Nil(t, err)
+
+	config.Retention = 1
+	assert.Nil(t, db.Prune())
+
+	after, err := os.Stat(filepath.Join(dir, "repbak.db"))
+	assert.Nil(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	// the reopened database is still usable
+	assert.Nil(t, db.Insert(NewStat("TEST").Finish(nil)))
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 1)
+}
+
+func TestDBQueries(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 10,
+	}
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	latest, err := db.Latest()
+	assert.Nil(t, err)
+	assert.Len(t, latest, 0)
+
+	last, err := db.LastSuccess("TEST")
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+
+	// one stat an hour for the last 6 hours with the 2 most recent failing
+	start := time.Now().Truncate(time.Hour).Add(-6 * time.Hour)
+	for i := 0; i < 6; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Hour)
+		stat.BytesWritten = 100
+
+		var err error
+		if i >= 4 {
+			err = errors.New("ERROR")
+		}
+		assert.Nil(t, db.Insert(stat.Finish(err)))
+	}
+	assert.Nil(t, db.Insert(NewStat("TEST2").Finish(nil)))
+
+	latest, err = db.Latest()
+	assert.Nil(t, err)
+	assert.Len(t, latest, 2)
+	assert.True(t, latest["TEST"].Start.Equal(start.Add(5*time.Hour)))
+	assert.True(t, latest["TEST2"].Success)
+
+	stats, err := db.Range("TEST", start.Add(time.Hour), start.Add(3*time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, stats, 2)
+	assert.True(t, stats[0].Start.Equal(start.Add(2*time.Hour)))
+	assert.True(t, stats[1].Start.Equal(start.Add(time.Hour)))
+
+	last, err = db.LastSuccess("TEST")
+	assert.Nil(t, err)
+	assert.True(t, last.Start.Equal(start.Add(3*time.Hour)))
+
+	last, err = db.LastFailure("TEST")
+	assert.Nil(t, err)
+	assert.True(t, last.Start.Equal(start.Add(5*time.Hour)))
+
+	last, err = db.LastFailure("TEST2")
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+
+	page, err := db.Page("TEST", time.Time{}, 4)
+	assert.Nil(t, err)
+	assert.Len(t, page, 4)
+	assert.True(t, page[0].Start.Equal(start.Add(5*time.Hour)))
+
+	page, err = db.Page("TEST", page[3].Start, 4)
+	assert.Nil(t, err)
+	assert.Len(t, page, 2)
+	assert.True(t, page[0].Start.Equal(start.Add(time.Hour)))
+	assert.True(t, page[1].Start.Equal(start))
+
+	page, err = db.Page("MISSING", time.Time{}, 4)
+	assert.Nil(t, err)
+	assert.Len(t, page, 0)
+
+	aggregate, err := db.Aggregate("TEST", start.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, aggregate.Runs, 4)
+	assert.Equal(t, aggregate.Failures, 2)
+	assert.Equal(t, aggregate.SuccessRate, 0.5)
+	assert.Equal(t, aggregate.TotalBytes, int64(400))
+}
@@ -0,0 +1,536 @@
+package repbak
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// sqlSchema creates the tables used by SQLDB. Start times are stored with statKey so they sort by
+// time in every database. Stats are stored JSON encoded in data with the commonly queried fields
+// copied into columns for reporting.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS stats (
+		name TEXT NOT NULL,
+		start TEXT NOT NULL,
+		run_id TEXT NOT NULL,
+		end_time TEXT NOT NULL,
+		success BOOLEAN NOT NULL,
+		duration BIGINT NOT NULL,
+		bytes_written BIGINT NOT NULL,
+		error_message TEXT NOT NULL,
+		data TEXT NOT NULL,
+		log TEXT NOT NULL,
+		skip BOOLEAN NOT NULL,
+		retried BOOLEAN NOT NULL,
+		PRIMARY KEY (name, start)
+	)`,
+	`CREATE INDEX IF NOT EXISTS stats_run_id ON stats (name, run_id)`,
+	`CREATE TABLE IF NOT EXISTS suppressed (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS outbox (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS deadletter (id TEXT PRIMARY KEY, data TEXT NOT NULL)`,
+}
+
+// SQLDB stores stats in SQLite or PostgreSQL. Unlike BoltDB the database can be read by other
+// processes while repbak is running.
+type SQLDB struct {
+	config   *Config
+	configMu sync.RWMutex
+	driver   string
+	db       *sql.DB
+}
+
+// NewSQLiteDB opens the SQLite database at the database path. If retention is less than 0 then the
+// database isn't created and all calls are no-ops.
+func NewSQLiteDB(config *Config) (*SQLDB, error) {
+	if config.Retention >= 0 {
+		if err := os.MkdirAll(filepath.Dir(config.Database.Path), 0700); err != nil {
+			return nil, fmt.Errorf("SQLDB: Failed to create database directory: %w", err)
+		}
+	}
+
+	// WAL lets other processes read while repbak writes
+	dsn := config.Database.Path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	return newSQLDB(config, "sqlite", dsn)
+}
+
+// NewPostgresDB connects to the PostgreSQL database at the database dsn. If retention is less than 0
+// then the database isn't used and all calls are no-ops.
+func NewPostgresDB(config *Config) (*SQLDB, error) {
+	return newSQLDB(config, "postgres", config.Database.DSN)
+}
+
+func newSQLDB(config *Config, driver, dsn string) (*SQLDB, error) {
+	s := &SQLDB{
+		config: config,
+		driver: driver,
+	}
+
+	if config.Retention < 0 {
+		return s, nil
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("SQLDB: Failed to open %s database: %w", driver, err)
+	}
+
+	// sqlite only allows one writer at a time
+	if driver == "sqlite" {
+		db.SetMaxOpenConns(1)
+	}
+	s.db = db
+
+	for _, statement := range sqlSchema {
+		if _, err := db.Exec(statement); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("SQLDB: Failed to create %s schema: %w", driver, err)
+		}
+	}
+
+	return s, s.Prune()
+}
+
+// rebind converts ? placeholders to the numbered placeholders used by postgres.
+func (s *SQLDB) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQLDB) exec(query string, args ...interface{}) error {
+	if _, err := s.db.Exec(s.rebind(query), args...); err != nil {
+		return fmt.Errorf("SQLDB: failed exec: %s", err)
+	}
+	return nil
+}
+
+// queryData returns the data column of every row returned by query.
+func (s *SQLDB) queryData(query string, args ...interface{}) ([][]byte, error) {
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("SQLDB: failed query: %s", err)
+	}
+	defer rows.Close()
+
+	var data [][]byte
+	for rows.Next() {
+		var v []byte
+		if err := rows.Scan(&v); err != nil {
+			return nil, fmt.Errorf("SQLDB: failed scan: %s", err)
+		}
+		data = append(data, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("SQLDB: failed query: %s", err)
+	}
+	return data, nil
+}
+
+// queryStats returns the stats returned by query. Stats that can't be read are skipped.
+func (s *SQLDB) queryStats(query string, args ...interface{}) ([]Stat, error) {
+	stats := []Stat{}
+
+	if s.cfg().Retention < 0 {
+		return stats, nil
+	}
+
+	data, err := s.queryData(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range data {
+		if stat, ok := decodeStat(v); ok {
+			stats = append(stats, stat)
+		}
+	}
+	return stats, nil
+}
+
+// Insert adds one Stat to the database.
+func (s *SQLDB) Insert(stat Stat) error {
+	return s.InsertBatch([]Stat{stat})
+}
+
+// InsertBatch adds stats to the database in a single transaction and prunes once they're all added.
+func (s *SQLDB) InsertBatch(stats []Stat) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("SQLDB: failed transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(s.rebind(`INSERT INTO stats (name, start, run_id, end_time, success, duration, bytes_written, error_message, data, log, skip, retried)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (name, start) DO UPDATE SET run_id = excluded.run_id, end_time = excluded.end_time, success = excluded.success,
+		duration = excluded.duration, bytes_written = excluded.bytes_written, error_message = excluded.error_message,
+		data = excluded.data, log = excluded.log, skip = excluded.skip, retried = excluded.retried`))
+	if err != nil {
+		return fmt.Errorf("SQLDB: failed exec: %s", err)
+	}
+	defer insert.Close()
+
+	for _, stat := range stats {
+		encoded, err := json.Marshal(stat)
+		if err != nil {
+			return fmt.Errorf("SQLDB: marshal json: %s", err)
+		}
+
+		_, err = insert.Exec(
+			stat.Name,
+			string(statKey(stat.Start)),
+			stat.RunID,
+			string(statKey(stat.End)),
+			stat.Success,
+			int64(stat.Duration),
+			stat.BytesWritten,
+			stat.ErrorMessage,
+			string(encoded),
+			stat.Log,
+			stat.Skip,
+			stat.Retried,
+		)
+		if err != nil {
+			return fmt.Errorf("SQLDB: failed exec: %s", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("SQLDB: failed transaction: %s", err)
+	}
+
+	return s.Prune()
+}
+
+// Prune removes the entries for each backup that exceed the retention count or max age along with
+// their run logs.
+func (s *SQLDB) Prune() error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	names, err := s.queryData(`SELECT DISTINCT name FROM stats`)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pruned := 0
+
+	for _, name := range names {
+		max, maxAge := s.cfg().statsRetention(string(name))
+
+		// the stats past the newest max runs or maxSkips skipped backups or started before max age.
+		// Skipped backups don't count toward max.
+		var conditions []string
+		args := []interface{}{string(name)}
+		if max > 0 {
+			past := `(skip = ? AND start <= (SELECT start FROM stats WHERE name = ? AND skip = ? ORDER BY start DESC LIMIT 1 OFFSET ?))`
+			conditions = append(conditions, past, past)
+			args = append(args, false, string(name), false, max, true, string(name), true, maxSkips)
+		}
+		if maxAge > 0 {
+			conditions = append(conditions, `start < ?`)
+			args = append(args, string(statKey(now.Add(-maxAge))))
+		}
+		if len(conditions) == 0 {
+			continue
+		}
+		where := `name = ? AND (` + strings.Join(conditions, ` OR `) + `)`
+
+		logs, err := s.queryData(`SELECT log FROM stats WHERE `+where, args...)
+		if err != nil {
+			return err
+		}
+		if len(logs) == 0 {
+			continue
+		}
+
+		// remove the run logs that belong to the stats
+		for _, runLog := range logs {
+			if err := removeRunLog(s.cfg(), string(name), string(runLog)); err != nil {
+				log.Error(err)
+			}
+		}
+
+		if err := s.exec(`DELETE FROM stats WHERE `+where, args...); err != nil {
+			return err
+		}
+		pruned += len(logs)
+	}
+
+	if err := s.pruneCount("suppressed", maxSuppressed); err != nil {
+		return err
+	}
+
+	// reclaim the space freed by large prunes
+	if pruned >= compactThreshold && s.driver == "sqlite" {
+		log.Infof("SQLDB: vacuuming after pruning %d stats", pruned)
+		return s.exec(`VACUUM`)
+	}
+	return nil
+}
+
+// pruneCount deletes the oldest entries in table until at most max remain.
+func (s *SQLDB) pruneCount(table string, max int) error {
+	return s.exec(`DELETE FROM `+table+` WHERE id NOT IN (SELECT id FROM `+table+` ORDER BY id DESC LIMIT ?)`, max)
+}
+
+// List returns all stats stored as a map. The map keys are sync names and the values are a list of all stored stats for
+// that sync. Stats are returned sorted by Start in descending order.
+func (s *SQLDB) List() (map[string][]Stat, error) {
+	statMap := make(map[string][]Stat)
+
+	stats, err := s.queryStats(`SELECT data FROM stats ORDER BY name, start DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stat := range stats {
+		statMap[stat.Name] = append(statMap[stat.Name], stat)
+	}
+	return statMap, nil
+}
+
+// Latest returns the most recent stat for each job keyed by job name.
+func (s *SQLDB) Latest() (map[string]Stat, error) {
+	latest := make(map[string]Stat)
+
+	stats, err := s.queryStats(`SELECT s.data FROM stats s
+		JOIN (SELECT name, MAX(start) AS start FROM stats GROUP BY name) l ON s.name = l.name AND s.start = l.start`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stat := range stats {
+		latest[stat.Name] = stat
+	}
+	return latest, nil
+}
+
+// Range returns the stats for the job name that started at or after from and before to sorted by Start in
+// descending order.
+func (s *SQLDB) Range(name string, from, to time.Time) ([]Stat, error) {
+	return s.queryStats(`SELECT data FROM stats WHERE name = ? AND start >= ? AND start < ? ORDER BY start DESC`,
+		name, string(statKey(from)), string(statKey(to)))
+}
+
+// LastSuccess returns the most recent successful stat for the job name or nil if there isn't one.
+func (s *SQLDB) LastSuccess(name string) (*Stat, error) {
+	return s.last(name, true)
+}
+
+// LastFailure returns the most recent failed stat for the job name or nil if there isn't one.
+func (s *SQLDB) LastFailure(name string) (*Stat, error) {
+	return s.last(name, false)
+}
+
+func (s *SQLDB) last(name string, success bool) (*Stat, error) {
+	// skipped backups and retried attempts are stored as unsuccessful but aren't failures
+	stats, err := s.queryStats(`SELECT data FROM stats WHERE name = ? AND success = ? AND skip = ? AND retried = ?
+		ORDER BY start DESC LIMIT 1`, name, success, false, false)
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+	return &stats[0], nil
+}
+
+// Run returns the stat of the run with runID of the job name or nil if there isn't one.
+func (s *SQLDB) Run(name, runID string) (*Stat, error) {
+	stats, err := s.queryStats(`SELECT data FROM stats WHERE name = ? AND run_id = ?`, name, runID)
+	if err != nil || len(stats) == 0 {
+		return nil, err
+	}
+	return &stats[0], nil
+}
+
+// Page returns up to limit stats for the job name that started before the given time sorted by Start in
+// descending order. A zero before starts at the most recent stat.
+func (s *SQLDB) Page(name string, before time.Time, limit int) ([]Stat, error) {
+	if before.IsZero() {
+		return s.queryStats(`SELECT data FROM stats WHERE name = ? ORDER BY start DESC LIMIT ?`, name, limit)
+	}
+	return s.queryStats(`SELECT data FROM stats WHERE name = ? AND start < ? ORDER BY start DESC LIMIT ?`,
+		name, string(statKey(before)), limit)
+}
+
+// Aggregate summarizes the stats for the job name that started at or after since.
+func (s *SQLDB) Aggregate(name string, since time.Time) (Aggregate, error) {
+	stats, err := s.queryStats(`SELECT data FROM stats WHERE name = ? AND start >= ?`, name, string(statKey(since)))
+	if err != nil {
+		return Aggregate{}, err
+	}
+	return AggregateStats(name, stats), nil
+}
+
+// InsertSuppressed adds a stat whose notification was suppressed by the notification policy.
+func (s *SQLDB) InsertSuppressed(stat Stat) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(stat)
+	if err != nil {
+		return fmt.Errorf("SQLDB: marshal json: %s", err)
+	}
+
+	// keyed by sortable start time and name since different jobs may start at the same time
+	id := stat.Start.UTC().Format(runLogTimeFormat) + "/" + stat.Name
+	if err := s.put("suppressed", id, encoded); err != nil {
+		return err
+	}
+
+	return s.pruneCount("suppressed", maxSuppressed)
+}
+
+// ListSuppressed returns all suppressed stats sorted by Start in ascending order.
+func (s *SQLDB) ListSuppressed() ([]Stat, error) {
+	return s.queryStats(`SELECT data FROM suppressed ORDER BY id`)
+}
+
+// PruneSuppressed removes the oldest n suppressed stats.
+func (s *SQLDB) PruneSuppressed(n int) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	return s.exec(`DELETE FROM suppressed WHERE id IN (SELECT id FROM suppressed ORDER BY id LIMIT ?)`, n)
+}
+
+// put adds or replaces the data stored under id in table.
+func (s *SQLDB) put(table, id string, data []byte) error {
+	return s.exec(`INSERT INTO `+table+` (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data`, id, string(data))
+}
+
+// PutDelivery adds or replaces an undelivered notification in the outbox.
+func (s *SQLDB) PutDelivery(delivery Delivery) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("SQLDB: marshal json: %s", err)
+	}
+
+	return s.put("outbox", delivery.ID, encoded)
+}
+
+// DeleteDelivery removes a delivered notification from the outbox.
+func (s *SQLDB) DeleteDelivery(id string) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	return s.exec(`DELETE FROM outbox WHERE id = ?`, id)
+}
+
+// DeadLetter moves a notification from the outbox to the dead letters.
+func (s *SQLDB) DeadLetter(delivery Delivery) error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	encoded, err := json.Marshal(delivery)
+	if err != nil {
+		return fmt.Errorf("SQLDB: marshal json: %s", err)
+	}
+
+	if err := s.put("deadletter", delivery.ID, encoded); err != nil {
+		return err
+	}
+
+	if err := s.DeleteDelivery(delivery.ID); err != nil {
+		return err
+	}
+
+	return s.pruneCount("deadletter", maxDeadLetters)
+}
+
+// ListDeliveries returns the notifications waiting in the outbox sorted by ID.
+func (s *SQLDB) ListDeliveries() ([]Delivery, error) {
+	return s.listDeliveries("outbox")
+}
+
+// ListDeadLetters returns the notifications that were never delivered sorted by ID.
+func (s *SQLDB) ListDeadLetters() ([]Delivery, error) {
+	return s.listDeliveries("deadletter")
+}
+
+func (s *SQLDB) listDeliveries(table string) ([]Delivery, error) {
+	deliveries := []Delivery{}
+
+	if s.cfg().Retention < 0 {
+		return deliveries, nil
+	}
+
+	data, err := s.queryData(`SELECT data FROM ` + table + ` ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range data {
+		delivery := Delivery{}
+		if err := json.Unmarshal(v, &delivery); err != nil {
+			// delivery can't be read so just skip it and log the error.
+			log.Error(err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// SetConfig replaces the config used for retention. The retention can't be enabled or disabled.
+func (s *SQLDB) SetConfig(config *Config) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	s.config = config
+}
+
+// cfg returns the current config.
+func (s *SQLDB) cfg() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
+// Close closes the connection to the database.
+func (s *SQLDB) Close() error {
+	if s.cfg().Retention < 0 {
+		return nil
+	}
+
+	return s.db.Close()
+}
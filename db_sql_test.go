@@ -0,0 +1,325 @@
+package repbak
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLiteDB(t *testing.T, dir string, retention int) (*Config, *SQLDB) {
+	config := &Config{
+		LibPath:   dir,
+		Retention: retention,
+		Database: &Database{
+			Driver: "sqlite",
+			Path:   filepath.Join(dir, "repbak.sqlite"),
+		},
+	}
+
+	db, err := NewSQLiteDB(config)
+	assert.Nil(t, err)
+	return config, db
+}
+
+func TestSQLDB(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, db := newTestSQLiteDB(t, dir, 3)
+	defer db.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 4; i++ {
+		for _, name := range []string{"TEST", "TEST2"} {
+			stat := NewStat(name)
+			stat.Start = start.Add(time.Duration(i) * time.Minute)
+			assert.Nil(t, db.Insert(stat.Finish(nil)))
+		}
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap, 2)
+	assert.Len(t, statMap["TEST"], 3)
+	assert.Len(t, statMap["TEST2"], 3)
+	assert.True(t, statMap["TEST"][0].Start.Equal(start.Add(3*time.Minute)))
+	assert.True(t, statMap["TEST"][2].Start.Equal(start.Add(time.Minute)))
+}
+
+func TestSQLDBPrune(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, db := newTestSQLiteDB(t, dir, 0)
+	defer db.Close()
+	config.retentionMaxAge = 24 * time.Hour
+
+	// the run logs of pruned stats are removed
+	assert.Nil(t, os.MkdirAll(runLogDir(config, "TEST"), 0700))
+	var logs []string
+	for i := 0; i < 3; i++ {
+		stat := NewStat("TEST")
+		stat.Start = time.Now().Add(-time.Duration(i*20) * time.Hour)
+		stat.Log = filepath.Join(runLogDir(config, "TEST"), stat.Start.Format(runLogTimeFormat)+".log")
+		assert.Nil(t, os.WriteFile(stat.Log, []byte("LOG"), 0600))
+		logs = append(logs, stat.Log)
+		assert.Nil(t, db.Insert(stat.Finish(nil)))
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 2)
+
+	_, err = os.Stat(logs[2])
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(logs[1])
+	assert.Nil(t, err)
+
+	// the count is applied with the max age
+	config.Retention = 1
+	assert.Nil(t, db.Prune())
+
+	statMap, err = db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 1)
+	_, err = os.Stat(logs[1])
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSQLDBPruneSkips(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, db := newTestSQLiteDB(t, dir, 3)
+	defer db.Close()
+
+	// skipped backups don't push the runs out of the history
+	start := time.Now().Add(-24 * time.Hour)
+	for i := 0; i < 3; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Second)
+		assert.Nil(t, db.Insert(stat.Finish(nil)))
+	}
+	for i := 0; i < maxSkips+10; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i+1) * time.Minute)
+		stat.Skip = true
+		assert.Nil(t, db.Insert(stat))
+	}
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3+maxSkips)
+	assert.True(t, statMap["TEST"][maxSkips-1].Start.Equal(start.Add(11*time.Minute)))
+
+	last, err := db.LastSuccess("TEST")
+	assert.Nil(t, err)
+	assert.True(t, last.Start.Equal(start.Add(2*time.Second)))
+
+	// runs past the retention are still pruned
+	stat := NewStat("TEST")
+	stat.Start = start.Add(2 * time.Hour)
+	assert.Nil(t, db.Insert(stat.Finish(nil)))
+
+	statMap, err = db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3+maxSkips)
+}
+
+func TestSQLDBWithoutRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, db := newTestSQLiteDB(t, dir, -1)
+	defer db.Close()
+
+	assert.Nil(t, db.Insert(NewStat("TEST").Finish(nil)))
+
+	statMap, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap, 0)
+
+	_, err = os.Stat(filepath.Join(dir, "repbak.sqlite"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestSQLDBQueries(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, db := newTestSQLiteDB(t, dir, 10)
+	defer db.Close()
+
+	start := time.Now().Truncate(time.Hour).Add(-6 * time.Hour)
+	for i := 0; i < 6; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Hour)
+		stat.BytesWritten = 100
+
+		var err error
+		if i >= 4 {
+			err = errors.New("ERROR")
+		}
+		assert.Nil(t, db.Insert(stat.Finish(err)))
+	}
+	assert.Nil(t, db.Insert(NewStat("TEST2").Finish(nil)))
+
+	latest, err := db.Latest()
+	assert.Nil(t, err)
+	assert.Len(t, latest, 2)
+	assert.True(t, latest["TEST"].Start.Equal(start.Add(5*time.Hour)))
+	assert.EqualError(t, latest["TEST"].Error, "ERROR")
+
+	run, err := db.Run("TEST", latest["TEST"].RunID)
+	assert.Nil(t, err)
+	assert.True(t, run.Start.Equal(start.Add(5*time.Hour)))
+
+	run, err = db.Run("TEST2", latest["TEST"].RunID)
+	assert.Nil(t, err)
+	assert.Nil(t, run)
+
+	stats, err := db.Range("TEST", start.Add(time.Hour), start.Add(3*time.Hour))
+	assert.Nil(t, err)
+	assert.Len(t, stats, 2)
+	assert.True(t, stats[0].Start.Equal(start.Add(2*time.Hour)))
+
+	last, err := db.LastSuccess("TEST")
+	assert.Nil(t, err)
+	assert.True(t, last.Start.Equal(start.Add(3*time.Hour)))
+
+	last, err = db.LastFailure("TEST2")
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+
+	// retried attempts and skipped backups aren't failures
+	retried := NewStat("TEST2").Finish(errors.New("ERROR"))
+	retried.Start = retried.Start.Add(time.Minute)
+	retried.Retried = true
+	assert.Nil(t, db.Insert(retried))
+	skip := NewStat("TEST2")
+	skip.Start = skip.Start.Add(2 * time.Minute)
+	skip.Skip = true
+	assert.Nil(t, db.Insert(skip))
+
+	last, err = db.LastFailure("TEST2")
+	assert.Nil(t, err)
+	assert.Nil(t, last)
+
+	page, err := db.Page("TEST", time.Time{}, 4)
+	assert.Nil(t, err)
+	assert.Len(t, page, 4)
+
+	page, err = db.Page("TEST", page[3].Start, 4)
+	assert.Nil(t, err)
+	assert.Len(t, page, 2)
+	assert.True(t, page[1].Start.Equal(start))
+
+	aggregate, err := db.Aggregate("TEST", start.Add(2*time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, aggregate.Runs, 4)
+	assert.Equal(t, aggregate.SuccessRate, 0.5)
+	assert.Equal(t, aggregate.TotalBytes, int64(400))
+}
+
+func TestSQLDBNotifications(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, db := newTestSQLiteDB(t, dir, 3)
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.InsertSuppressed(NewStat("TEST").Finish(errors.New("ERROR"))))
+	}
+
+	suppressed, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 3)
+
+	assert.Nil(t, db.PruneSuppressed(2))
+
+	suppressed, err = db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 1)
+
+	delivery := Delivery{ID: "1", Notifier: "email", Kind: DeliveryNotify, Stats: []Stat{NewStat("TEST")}}
+	assert.Nil(t, db.PutDelivery(delivery))
+	assert.Nil(t, db.PutDelivery(Delivery{ID: "2", Notifier: "email", Kind: DeliveryNotify}))
+
+	delivery.Attempts = 2
+	assert.Nil(t, db.PutDelivery(delivery))
+
+	pending, err := db.ListDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 2)
+	assert.Equal(t, pending[0].Attempts, 2)
+
+	assert.Nil(t, db.DeadLetter(delivery))
+	assert.Nil(t, db.DeleteDelivery("2"))
+
+	pending, err = db.ListDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, pending, 0)
+
+	dead, err := db.ListDeadLetters()
+	assert.Nil(t, err)
+	assert.Len(t, dead, 1)
+	assert.Equal(t, dead[0].ID, "1")
+}
+
+func TestSQLDBRebind(t *testing.T) {
+	db := &SQLDB{driver: "postgres"}
+	assert.Equal(t, db.rebind("SELECT data FROM stats WHERE name = ? AND start < ?"), "SELECT data FROM stats WHERE name = $1 AND start < $2")
+
+	db.driver = "sqlite"
+	assert.Equal(t, db.rebind("SELECT data FROM stats WHERE name = ?"), "SELECT data FROM stats WHERE name = ?")
+}
+
+func TestMigrateStats(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, dst := newTestSQLiteDB(t, dir, 3)
+	defer dst.Close()
+
+	src, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer src.Close()
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		stat := NewStat("TEST")
+		stat.Start = start.Add(time.Duration(i) * time.Minute)
+		assert.Nil(t, src.Insert(stat.Finish(nil)))
+	}
+	assert.Nil(t, src.InsertSuppressed(NewStat("TEST").Finish(errors.New("ERROR"))))
+	assert.Nil(t, src.PutDelivery(Delivery{ID: "1"}))
+	assert.Nil(t, src.DeadLetter(Delivery{ID: "2"}))
+
+	assert.Nil(t, MigrateStats(src, dst))
+
+	statMap, err := dst.List()
+	assert.Nil(t, err)
+	assert.Len(t, statMap["TEST"], 3)
+	assert.True(t, statMap["TEST"][0].Start.Equal(start.Add(2*time.Minute)))
+
+	suppressed, err := dst.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 1)
+
+	status, err := ListOutbox(dst)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 1)
+	assert.Len(t, status.DeadLetters, 1)
+}
@@ -0,0 +1,348 @@
+package repbak
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Kinds of notifications that can be stored in the outbox.
+const (
+	DeliveryNotify  = "notify"
+	DeliveryHistory = "history"
+	DeliveryDigest  = "digest"
+)
+
+// Delivery is a notification that failed to be delivered by a notifier and is waiting in the
+// outbox to be retried.
+type Delivery struct {
+	// ID uniquely identifies the delivery and sorts by creation time.
+	ID string
+
+	// Notifier is the name of the notifier the delivery is for.
+	Notifier string
+
+	// Kind is the kind of notification: notify, history, or digest.
+	Kind string
+
+	// Stats are the stats of the notification. Notify deliveries have a single stat.
+	Stats []Stat
+
+	// Attempts is the number of times delivery was attempted.
+	Attempts int
+
+	// Created is when the notification was first attempted.
+	Created time.Time
+
+	// NextAttempt is when the next attempt will be made.
+	NextAttempt time.Time
+
+	// LastError is the error from the last attempt.
+	LastError string
+}
+
+// DeliveryQueue stores notifications that failed to be delivered in the outbox of the DB and
+// retries them with exponential backoff until they are delivered or older than max_age.
+type DeliveryQueue struct {
+	config    *Config
+	db        DB
+	notifiers map[string]Notifier
+	mu        sync.Mutex
+	now       func() time.Time
+	running   bool
+	stopc     chan struct{}
+	donec     chan struct{}
+}
+
+// NewDeliveryQueue creates a DeliveryQueue using the config.
+func NewDeliveryQueue(config *Config, db DB) *DeliveryQueue {
+	return &DeliveryQueue{
+		config:    config,
+		db:        db,
+		notifiers: make(map[string]Notifier),
+		mu:        sync.Mutex{},
+		now:       time.Now,
+		stopc:     make(chan struct{}),
+		donec:     make(chan struct{}),
+	}
+}
+
+// Wrap returns a Notifier that sends notifications with notifier and queues the ones that fail
+// under name. Names must be unique.
+func (q *DeliveryQueue) Wrap(name string, notifier Notifier) *QueuedNotifier {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.notifiers[name] = notifier
+
+	return &QueuedNotifier{
+		name:     name,
+		queue:    q,
+		notifier: notifier,
+	}
+}
+
+// Start retries the outbox on the retry_interval until stopped.
+func (q *DeliveryQueue) Start() {
+	if q.running {
+		return
+	}
+
+	q.running = true
+
+	go q.loop()
+}
+
+// Stop stops retrying the outbox.
+func (q *DeliveryQueue) Stop() {
+	if !q.running {
+		return
+	}
+
+	q.stopc <- struct{}{}
+	<-q.donec
+	q.running = false
+}
+
+func (q *DeliveryQueue) loop() {
+	ticker := time.NewTicker(q.config.Outbox.retryInterval)
+	defer ticker.Stop()
+
+	// retry anything left over from before a restart right away
+	if err := q.Retry(); err != nil {
+		log.Error(err)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := q.Retry(); err != nil {
+				log.Error(err)
+			}
+		case <-q.stopc:
+			q.donec <- struct{}{}
+			return
+		}
+	}
+}
+
+// Retry attempts to deliver each notification in the outbox that is due. Notifications older than
+// max_age or for notifiers that no longer exist are moved to the dead letters.
+func (q *DeliveryQueue) Retry() error {
+	deliveries, err := q.db.ListDeliveries()
+	if err != nil {
+		return err
+	}
+
+	now := q.now()
+
+	for _, delivery := range deliveries {
+		if delivery.NextAttempt.After(now) {
+			continue
+		}
+
+		if now.Sub(delivery.Created) > q.config.Outbox.maxAge {
+			log.Errorf("Outbox: giving up on %s notification %s for %s after %d attempts: %s", delivery.Kind, delivery.ID, delivery.Notifier, delivery.Attempts, delivery.LastError)
+			if err := q.db.DeadLetter(delivery); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+
+		q.mu.Lock()
+		notifier, ok := q.notifiers[delivery.Notifier]
+		q.mu.Unlock()
+
+		if !ok {
+			delivery.LastError = fmt.Sprintf("notifier %s is not configured", delivery.Notifier)
+			log.Errorf("Outbox: dropping %s notification %s: %s", delivery.Kind, delivery.ID, delivery.LastError)
+			if err := q.db.DeadLetter(delivery); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+
+		delivery.Attempts++
+
+		if err := deliver(notifier, delivery); err != nil {
+			delivery.LastError = err.Error()
+
+			if isPermanent(err) {
+				log.Errorf("Outbox: giving up on %s notification %s for %s after %d attempts: %v", delivery.Kind, delivery.ID, delivery.Notifier, delivery.Attempts, err)
+				if err := q.db.DeadLetter(delivery); err != nil {
+					log.Error(err)
+				}
+				continue
+			}
+
+			delivery.NextAttempt = now.Add(q.backoff(delivery.Attempts))
+			log.Warnf("Outbox: retry %d of %s notification %s for %s failed: %v", delivery.Attempts, delivery.Kind, delivery.ID, delivery.Notifier, err)
+
+			if err := q.db.PutDelivery(delivery); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+
+		log.Infof("Outbox: delivered %s notification %s for %s after %d attempts", delivery.Kind, delivery.ID, delivery.Notifier, delivery.Attempts)
+		if err := q.db.DeleteDelivery(delivery.ID); err != nil {
+			log.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// backoff returns the delay before the next attempt. The delay doubles with each attempt starting
+// at initial_backoff up to max_backoff.
+func (q *DeliveryQueue) backoff(attempts int) time.Duration {
+	backoff := q.config.Outbox.initialBackoff
+	for i := 1; i < attempts && backoff < q.config.Outbox.maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > q.config.Outbox.maxBackoff {
+		backoff = q.config.Outbox.maxBackoff
+	}
+	return backoff
+}
+
+// enqueue stores a notification that failed on the first attempt. Permanent errors are moved to
+// the dead letters right away. If stats aren't stored then nothing can be queued and err is returned.
+func (q *DeliveryQueue) enqueue(name, kind string, stats []Stat, err error) error {
+	if q.config.Retention < 0 {
+		return err
+	}
+
+	now := q.now()
+
+	delivery := Delivery{
+		ID:          now.UTC().Format(runLogTimeFormat) + "/" + name + "/" + kind,
+		Notifier:    name,
+		Kind:        kind,
+		Stats:       stats,
+		Attempts:    1,
+		Created:     now,
+		NextAttempt: now.Add(q.backoff(1)),
+		LastError:   err.Error(),
+	}
+
+	if isPermanent(err) {
+		log.Errorf("Outbox: %s notification for %s failed and can't be retried: %v", kind, name, err)
+		return q.db.DeadLetter(delivery)
+	}
+
+	log.Warnf("Outbox: %s notification for %s failed and will be retried: %v", kind, name, err)
+
+	return q.db.PutDelivery(delivery)
+}
+
+// permanentError is a notification error that retrying won't fix such as a template that fails to
+// render.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks err as permanent so the notification isn't retried. A nil err stays nil.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// isPermanent reports whether err was marked as permanent.
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// deliver sends the delivery with notifier.
+func deliver(notifier Notifier, delivery Delivery) error {
+	stats := delivery.Stats
+
+	switch delivery.Kind {
+	case DeliveryNotify:
+		if len(stats) != 1 {
+			return fmt.Errorf("Outbox: notify delivery %s has %d stats", delivery.ID, len(stats))
+		}
+		return notifier.Notify(stats[0])
+	case DeliveryHistory:
+		return notifier.NotifyHistory(groupStats(stats))
+	case DeliveryDigest:
+		return notifier.NotifyDigest(stats)
+	default:
+		return fmt.Errorf("Outbox: unknown delivery kind %s", delivery.Kind)
+	}
+}
+
+// OutboxStatus lists the notifications waiting in the outbox and the ones that were never delivered.
+type OutboxStatus struct {
+	Pending     []Delivery
+	DeadLetters []Delivery
+}
+
+// ListOutbox returns the status of the outbox stored in db.
+func ListOutbox(db DB) (OutboxStatus, error) {
+	pending, err := db.ListDeliveries()
+	if err != nil {
+		return OutboxStatus{}, err
+	}
+
+	dead, err := db.ListDeadLetters()
+	if err != nil {
+		return OutboxStatus{}, err
+	}
+
+	return OutboxStatus{
+		Pending:     pending,
+		DeadLetters: dead,
+	}, nil
+}
+
+// QueuedNotifier sends notifications with a Notifier and stores the ones that fail in the outbox
+// to be retried.
+type QueuedNotifier struct {
+	name     string
+	queue    *DeliveryQueue
+	notifier Notifier
+}
+
+// Notify sends stat and queues it for retry if it fails.
+func (n *QueuedNotifier) Notify(stat Stat) error {
+	if err := n.notifier.Notify(stat); err != nil {
+		return n.queue.enqueue(n.name, DeliveryNotify, []Stat{stat}, err)
+	}
+	return nil
+}
+
+// NotifyHistory sends statMap and queues it for retry if it fails.
+func (n *QueuedNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	if err := n.notifier.NotifyHistory(statMap); err != nil {
+		var stats []Stat
+		for _, s := range statMap {
+			stats = append(stats, s...)
+		}
+		return n.queue.enqueue(n.name, DeliveryHistory, stats, err)
+	}
+	return nil
+}
+
+// NotifyDigest sends stats and queues them for retry if it fails.
+func (n *QueuedNotifier) NotifyDigest(stats []Stat) error {
+	if err := n.notifier.NotifyDigest(stats); err != nil {
+		return n.queue.enqueue(n.name, DeliveryDigest, stats, err)
+	}
+	return nil
+}
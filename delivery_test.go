@@ -0,0 +1,204 @@
+package repbak
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newDeliveryTest(t *testing.T) (*DeliveryQueue, *BoltDB, func()) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+		Outbox:    &Outbox{},
+	}
+	assert.Nil(t, config.Outbox.validate())
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+
+	return NewDeliveryQueue(config, db), db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDeliveryQueue(t *testing.T) {
+	queue, db, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)
+	queue.now = func() time.Time { return now }
+
+	email := &testNotifier{err: errors.New("SMTP DOWN")}
+	notifier := queue.Wrap("email", email)
+
+	stat := NewStat("TEST").Finish(errors.New("ERROR"))
+
+	// failed notifications are queued and not returned as errors
+	err := notifier.Notify(stat)
+	assert.Nil(t, err)
+	assert.Len(t, email.stats, 1)
+
+	deliveries, err := db.ListDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, deliveries[0].Notifier, "email")
+	assert.Equal(t, deliveries[0].Kind, DeliveryNotify)
+	assert.Equal(t, deliveries[0].Attempts, 1)
+	assert.Equal(t, deliveries[0].LastError, "SMTP DOWN")
+	assert.True(t, deliveries[0].NextAttempt.Equal(now.Add(time.Minute)))
+
+	// not due yet
+	err = queue.Retry()
+	assert.Nil(t, err)
+	assert.Len(t, email.stats, 1)
+
+	// due but still failing so the backoff doubles
+	now = now.Add(time.Minute)
+	err = queue.Retry()
+	assert.Nil(t, err)
+	assert.Len(t, email.stats, 2)
+
+	deliveries, err = db.ListDeliveries()
+	assert.Nil(t, err)
+	assert.Len(t, deliveries, 1)
+	assert.Equal(t, deliveries[0].Attempts, 2)
+	assert.True(t, deliveries[0].NextAttempt.Equal(now.Add(2*time.Minute)))
+
+	// delivered once the notifier recovers and the stat error is restored
+	email.err = nil
+	now = now.Add(2 * time.Minute)
+	err = queue.Retry()
+	assert.Nil(t, err)
+	assert.Len(t, email.stats, 3)
+	assert.EqualError(t, email.stats[2].Error, "ERROR")
+
+	status, err := ListOutbox(db)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 0)
+	assert.Len(t, status.DeadLetters, 0)
+}
+
+func TestDeliveryQueueDeadLetter(t *testing.T) {
+	queue, db, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)
+	queue.now = func() time.Time { return now }
+
+	email := &testNotifier{err: errors.New("SMTP DOWN")}
+	notifier := queue.Wrap("email", email)
+
+	stat := NewStat("TEST").Finish(nil)
+
+	err := notifier.NotifyHistory(map[string][]Stat{"TEST": {stat}})
+	assert.Nil(t, err)
+
+	err = notifier.NotifyDigest([]Stat{stat})
+	assert.Nil(t, err)
+
+	now = now.Add(25 * time.Hour)
+	err = queue.Retry()
+	assert.Nil(t, err)
+
+	status, err := ListOutbox(db)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 0)
+	assert.Len(t, status.DeadLetters, 2)
+	assert.ElementsMatch(t, []string{status.DeadLetters[0].Kind, status.DeadLetters[1].Kind}, []string{DeliveryHistory, DeliveryDigest})
+}
+
+func TestDeliveryQueuePermanent(t *testing.T) {
+	queue, db, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)
+	queue.now = func() time.Time { return now }
+
+	email := &testNotifier{err: permanent(errors.New("BAD TEMPLATE"))}
+	notifier := queue.Wrap("email", email)
+
+	// permanent errors aren't retried
+	err := notifier.Notify(NewStat("TEST").Finish(errors.New("ERROR")))
+	assert.Nil(t, err)
+
+	status, err := ListOutbox(db)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 0)
+	assert.Len(t, status.DeadLetters, 1)
+	assert.Equal(t, status.DeadLetters[0].LastError, "BAD TEMPLATE")
+
+	// a retry that fails permanently gives up right away
+	email.err = errors.New("SMTP DOWN")
+	err = notifier.NotifyDigest([]Stat{NewStat("TEST").Finish(nil)})
+	assert.Nil(t, err)
+
+	email.err = permanent(errors.New("BAD TEMPLATE"))
+	now = now.Add(time.Minute)
+	err = queue.Retry()
+	assert.Nil(t, err)
+
+	status, err = ListOutbox(db)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 0)
+	assert.Len(t, status.DeadLetters, 2)
+}
+
+func TestDeliveryQueueWithoutRetention(t *testing.T) {
+	queue, _, cleanup := newDeliveryTest(t)
+	defer cleanup()
+	queue.config.Retention = -1
+
+	email := &testNotifier{err: errors.New("SMTP DOWN")}
+	notifier := queue.Wrap("email", email)
+
+	// nothing can be queued so the error is returned
+	err := notifier.Notify(NewStat("TEST").Finish(errors.New("ERROR")))
+	assert.EqualError(t, err, "SMTP DOWN")
+}
+
+func TestDeliveryQueueMissingNotifier(t *testing.T) {
+	queue, db, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	err := queue.enqueue("removed", DeliveryNotify, []Stat{NewStat("TEST").Finish(nil)}, errors.New("ERROR"))
+	assert.Nil(t, err)
+
+	queue.now = func() time.Time { return time.Now().Add(time.Hour) }
+	err = queue.Retry()
+	assert.Nil(t, err)
+
+	status, err := ListOutbox(db)
+	assert.Nil(t, err)
+	assert.Len(t, status.Pending, 0)
+	assert.Len(t, status.DeadLetters, 1)
+	assert.Equal(t, status.DeadLetters[0].LastError, "notifier removed is not configured")
+}
+
+func TestDeliveryQueueBackoff(t *testing.T) {
+	queue, _, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	assert.Equal(t, queue.backoff(1), time.Minute)
+	assert.Equal(t, queue.backoff(2), 2*time.Minute)
+	assert.Equal(t, queue.backoff(3), 4*time.Minute)
+	assert.Equal(t, queue.backoff(7), time.Hour)
+	assert.Equal(t, queue.backoff(100), time.Hour)
+}
+
+func TestDeliveryQueueStartStop(t *testing.T) {
+	queue, _, cleanup := newDeliveryTest(t)
+	defer cleanup()
+
+	queue.Start()
+	queue.Start()
+	queue.Stop()
+	queue.Stop()
+}
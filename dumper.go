@@ -1,10 +1,55 @@
 package repbak
 
+import (
+	"io"
+	"time"
+)
+
+// maxStderrLines is the number of trailing STDERR lines from a dumper that are kept on a Stat.
+const maxStderrLines = 100
+
 // Dumper defines an interface for backing up a database.
 type Dumper interface {
-	// Dump does a backup of the database
-	Dump() Stat
+	// Name is the name of the backup job stats are stored under.
+	Name() string
+
+	// Dump does a backup of the database. Trigger is what started the backup: schedule, manual, or api.
+	Dump(trigger string) Stat
+
+	// RunningSince returns when the running backup started or the zero time if a backup isn't running.
+	RunningSince() time.Time
 
 	// Stop stops the database backup if one is running
 	Stop()
 }
+
+// tail keeps the last n lines written to it.
+type tail struct {
+	n     int
+	lines []string
+}
+
+func newTail(n int) *tail {
+	return &tail{
+		n: n,
+	}
+}
+
+func (t *tail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.n {
+		t.lines = t.lines[len(t.lines)-t.n:]
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
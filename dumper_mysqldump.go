@@ -2,22 +2,38 @@ package repbak
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
-	lumberjack "gopkg.in/natefinch/lumberjack.v2"
 )
 
+// artifactTimeFormat is the timestamp used in backup file names.
+const artifactTimeFormat = "2006-01-02T15-04-05.000"
+
+// linkFile hard links output_path to the newest backup. It's replaced in tests.
+var linkFile = os.Link
+
 // MySQLDumpDumper dumps a mysql backup to a file.
 type MySQLDumpDumper struct {
 	config  *Config
 	running bool
+	started time.Time
 	mu      sync.Mutex
 	cancel  context.CancelFunc
 }
@@ -30,107 +46,548 @@ func NewMySQLDumpDumper(config *Config) *MySQLDumpDumper {
 	}
 }
 
-// Dump dumps the mysql data to a file based on the settings in config.
-func (d *MySQLDumpDumper) Dump() Stat {
+// Name returns mysqldump.
+func (d *MySQLDumpDumper) Name() string {
+	return "mysqldump"
+}
+
+// Dump dumps the mysql data to a file based on the settings in config. Trigger is recorded in the
+// Stat as what started the backup.
+func (d *MySQLDumpDumper) Dump(trigger string) Stat {
+	stat := NewStat(d.Name())
+	stat.Trigger = trigger
+
+	// check if already running
+	d.mu.Lock()
+
+	// the dump uses the config it started with even if the config is reloaded while it runs
+	run := &MySQLDumpDumper{config: d.config}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if d.config.MySQLDump.timeLimit != 0 {
-		ctx, cancel = context.WithTimeout(ctx, d.config.MySQLDump.timeLimit)
+	if run.config.MySQLDump.timeLimit != 0 {
+		ctx, cancel = context.WithTimeout(ctx, run.config.MySQLDump.timeLimit)
 		defer cancel()
 	}
 
-	stat := NewStat("mysqldump", d.config.TimeFormat)
-
-	log.Info("Running: mysqldump")
-
-	// check if already running
-	d.mu.Lock()
 	if d.running {
 		stat.Skip = true
+		stat.SkipReason = "the previous dump is still running"
+		stat.End = stat.Start
 		log.Warn("MySQL Dumper: skipping because the previous scheduled dump is still running")
 		d.mu.Unlock()
 		return stat
 	}
 
 	d.running = true
+	d.started = stat.Start
 	d.cancel = cancel
 	d.mu.Unlock()
 
-	stat = func(stat Stat) Stat {
-		// Rotate the dump files
-		logger := &lumberjack.Logger{
-			Filename:   d.config.MySQLDump.OutputPath,
-			MaxSize:    0,
-			MaxBackups: d.config.MySQLDump.Retention,
-		}
-		if err := logger.Rotate(); err != nil {
-			stat = stat.Finish(err)
-			return stat
-		}
+	runLog, err := NewRunLog(run.config, stat.Name, stat.Start)
+	if err != nil {
+		log.Error(err)
+	}
+	stat.Log = runLog.Path()
 
-		args := strings.Fields(d.config.MySQLDump.ExecutableArgs)
+	runLog.Infof("Running: mysqldump (run %s triggered by %s)", stat.RunID, trigger)
 
-		cmd := exec.CommandContext(ctx, d.config.MySQLDump.ExecutablePath, args...)
+	stat = run.dump(ctx, stat, runLog)
 
-		if err := os.MkdirAll(filepath.Dir(d.config.MySQLDump.OutputPath), 0644); err != nil {
-			stat.Finish(fmt.Errorf("MySQL Dumper: failed to create dump directory %s: %v", filepath.Dir(d.config.MySQLDump.OutputPath), err))
-			return stat
-		}
+	if stat.Success {
+		runLog.Infof("Finished %s after %s", stat.Name, stat.Duration)
+	} else {
+		runLog.Errorf("Error %s: after %s: %s", stat.Name, stat.Duration, stat.Error)
+	}
+
+	if err := runLog.Close(); err != nil {
+		log.Error(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running = false
+	d.started = time.Time{}
+	d.cancel = nil
+
+	return stat
+}
+
+// SetConfig replaces the config used by the next dump. A running dump isn't changed.
+func (d *MySQLDumpDumper) SetConfig(config *Config) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.config = config
+}
+
+// RunningSince returns when the current dump started or the zero time if a dump isn't running.
+func (d *MySQLDumpDumper) RunningSince() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.started
+}
+
+// dump runs the executable writing the backup to a new artifact named after the start of the run.
+// Once the backup succeeds output_path is linked to the artifact and old artifacts are rotated out.
+func (d *MySQLDumpDumper) dump(ctx context.Context, stat Stat, runLog *RunLog) Stat {
+	args, err := mysqldumpArgs(d.config.MySQLDump)
+	if err != nil {
+		return stat.Finish(err)
+	}
 
-		// write output into the dump file
-		dump, err := os.Create(d.config.MySQLDump.OutputPath)
+	// the credentials are passed in an option file so they aren't visible in the process list
+	if d.config.MySQLDump.User != "" || d.config.MySQLDump.Password != "" {
+		defaultsFile, err := writeDefaultsFile(d.config.LibPath, d.config.MySQLDump.User, d.config.MySQLDump.Password)
 		if err != nil {
-			stat.Finish(fmt.Errorf("MySQL Dumper: failed to create dump file %s: %v", d.config.MySQLDump.OutputPath, err))
-			return stat
+			return stat.Finish(err)
 		}
-		cmd.Stdout = dump
+		defer os.Remove(defaultsFile)
+
+		// mysqldump requires --defaults-extra-file to be the first argument
+		args = append([]string{"--defaults-extra-file=" + defaultsFile}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, d.config.MySQLDump.ExecutablePath, args...)
+
+	if err := os.MkdirAll(filepath.Dir(d.config.MySQLDump.OutputPath), 0644); err != nil {
+		return stat.Finish(fmt.Errorf("MySQL Dumper: failed to create dump directory %s: %v", filepath.Dir(d.config.MySQLDump.OutputPath), err))
+	}
+
+	// write output into the artifact
+	artifact := d.artifactPath(stat.Start)
+	dump, err := os.Create(artifact)
+	if err != nil {
+		return stat.Finish(fmt.Errorf("MySQL Dumper: failed to create dump file %s: %v", artifact, err))
+	}
+
+	hash := sha256.New()
+	var out io.Writer = io.MultiWriter(dump, hash)
+
+	var gz *gzip.Writer
+	if d.config.MySQLDump.Compress {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
 
+	counter := &countingWriter{w: out}
+	cmd.Stdout = counter
+
+	err = func() error {
 		stderr, err := cmd.StderrPipe()
 		if err != nil {
-			stat.Finish(fmt.Errorf("MySQL Dumper: failed to get STDERR pipe: %v", err))
-			return stat
+			return fmt.Errorf("MySQL Dumper: failed to get STDERR pipe: %v", err)
+		}
 
+		// start the command
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("MySQL Dumper: failed to start backup: %v", err)
 		}
 
-		// write any errors into the log file
+		// write any errors into the run log. All reads must finish before calling Wait.
+		stderrTail := newTail(maxStderrLines)
 		scanner := bufio.NewScanner(stderr)
-		go func() {
-			// Read line by line and process it
-			for scanner.Scan() {
-				line := scanner.Text()
-				log.Error(line)
+		for scanner.Scan() {
+			runLog.Stderr(scanner.Text())
+			stderrTail.add(scanner.Text())
+		}
+		stat.Stderr = stderrTail.lines
+
+		return cmd.Wait()
+	}()
+
+	// record why the dump was stopped early
+	if err != nil && ctx.Err() != nil {
+		err = &stoppedError{reason: ctx.Err(), err: err}
+	}
+
+	if gz != nil {
+		if closeErr := gz.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("MySQL Dumper: failed to compress dump file %s: %v", artifact, closeErr)
+		}
+	}
+
+	if closeErr := dump.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("MySQL Dumper: failed to write dump file %s: %v", artifact, closeErr)
+	}
+
+	stat.BytesWritten = counter.n
+
+	// don't keep partial backups
+	if err != nil {
+		if removeErr := os.Remove(artifact); removeErr != nil {
+			log.Error(removeErr)
+		}
+		return stat.Finish(err)
+	}
+
+	stat.Artifacts = []string{artifact}
+	stat.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if d.config.MySQLDump.Compress {
+		info, err := os.Stat(artifact)
+		if err != nil {
+			return stat.Finish(fmt.Errorf("MySQL Dumper: failed to stat dump file %s: %v", artifact, err))
+		}
+		stat.CompressedSize = info.Size()
+	}
+
+	if err := d.rotate(artifact); err != nil {
+		return stat.Finish(err)
+	}
+
+	return stat.Finish(nil)
+}
+
+// stoppedError is the error of a dump stopped because it was canceled or ran past its time_limit. The
+// error of the process is kept so its exit status is recorded.
+type stoppedError struct {
+	reason error
+	err    error
+}
+
+func (e *stoppedError) Error() string {
+	if errors.Is(e.reason, context.DeadlineExceeded) {
+		return fmt.Sprintf("MySQL Dumper: backup ran past its time limit: %v", e.err)
+	}
+	return fmt.Sprintf("MySQL Dumper: backup canceled: %v", e.err)
+}
+
+func (e *stoppedError) Unwrap() error {
+	return e.err
+}
+
+// Is returns true if target is why the dump was stopped.
+func (e *stoppedError) Is(target error) bool {
+	return target == e.reason
+}
+
+// mysqldumpArgs returns the arguments passed to mysqldump after the option file: the connection, the
+// extra executable_args, and the databases.
+func mysqldumpArgs(config *MySQLDump) ([]string, error) {
+	var args []string
+
+	if config.Socket != "" {
+		args = append(args, "--socket="+config.Socket)
+	}
+	if config.Host != "" {
+		args = append(args, "--host="+config.Host)
+	}
+	if config.Port != 0 {
+		args = append(args, "--port="+strconv.Itoa(config.Port))
+	}
+
+	if tls := config.TLS; tls != nil {
+		args = append(args, "--ssl-mode="+strings.ToUpper(tls.Mode))
+		if tls.CAFile != "" {
+			args = append(args, "--ssl-ca="+tls.CAFile)
+		}
+		if tls.CertFile != "" {
+			args = append(args, "--ssl-cert="+tls.CertFile, "--ssl-key="+tls.KeyFile)
+		}
+	}
+
+	extra, err := splitArgs(config.ExecutableArgs)
+	if err != nil {
+		return nil, fmt.Errorf("MySQL Dumper: failed to parse executable_args: %v", err)
+	}
+	args = append(args, extra...)
+
+	if len(config.Databases) > 0 {
+		args = append(args, "--databases")
+		args = append(args, config.Databases...)
+	}
+
+	return args, nil
+}
+
+// optionEscaper escapes the special characters of a mysql option file value.
+var optionEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// writeDefaultsFile writes a mysql option file readable only by the owner to dir with the user and
+// password in the client group and returns its path. Empty options aren't written.
+func writeDefaultsFile(dir, user, password string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("MySQL Dumper: failed to create option file directory %s: %v", dir, err)
+	}
+
+	// CreateTemp creates the file with 0600 permissions
+	f, err := os.CreateTemp(dir, "mysqldump-*.cnf")
+	if err != nil {
+		return "", fmt.Errorf("MySQL Dumper: failed to create option file: %v", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("[client]\n")
+	if user != "" {
+		fmt.Fprintf(&b, "user=\"%s\"\n", optionEscaper.Replace(user))
+	}
+	if password != "" {
+		fmt.Fprintf(&b, "password=\"%s\"\n", optionEscaper.Replace(password))
+	}
+
+	_, err = f.WriteString(b.String())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("MySQL Dumper: failed to write option file %s: %v", f.Name(), err)
+	}
+
+	return f.Name(), nil
+}
+
+// latestPath is the path that is linked to the latest successful backup.
+func (d *MySQLDumpDumper) latestPath() string {
+	if d.config.MySQLDump.Compress {
+		return d.config.MySQLDump.OutputPath + ".gz"
+	}
+	return d.config.MySQLDump.OutputPath
+}
+
+// artifactPath is the path of the backup for a run that started at start. The name matches the
+// format of the backups rotated by previous versions of repbak.
+func (d *MySQLDumpDumper) artifactPath(start time.Time) string {
+	dir, prefix, ext := d.artifactName()
+
+	name := fmt.Sprintf("%s-%s%s", prefix, start.UTC().Format(artifactTimeFormat), ext)
+	if d.config.MySQLDump.Compress {
+		name += ".gz"
+	}
+	return filepath.Join(dir, name)
+}
+
+func (d *MySQLDumpDumper) artifactName() (string, string, string) {
+	dir := filepath.Dir(d.config.MySQLDump.OutputPath)
+	base := filepath.Base(d.config.MySQLDump.OutputPath)
+	ext := filepath.Ext(base)
+	return dir, strings.TrimSuffix(base, ext), ext
+}
+
+// rotate links output_path to artifact and removes the oldest artifacts beyond the retention.
+func (d *MySQLDumpDumper) rotate(artifact string) error {
+	artifacts, err := d.artifacts()
+	if err != nil {
+		return err
+	}
+
+	// keep an existing backup at output_path that isn't an artifact from an older version of repbak
+	latest := d.latestPath()
+	if info, err := os.Stat(latest); err == nil && !sameFileAsAny(info, artifacts) {
+		backup := d.artifactPath(info.ModTime())
+		if err := os.Rename(latest, backup); err != nil {
+			return fmt.Errorf("MySQL Dumper: failed to rotate %s: %v", latest, err)
+		}
+		artifacts = append(artifacts, backup)
+		sort.Sort(sort.Reverse(sort.StringSlice(artifacts)))
+	}
+
+	// the backup is stored so a failure to update output_path only leaves the previous one in place
+	if err := linkLatest(artifact, latest); err != nil {
+		log.Warnf("MySQL Dumper: failed to link %s to %s: %v", latest, artifact, err)
+	}
+
+	// as with the rotated backups of older versions of repbak the latest backup doesn't count toward
+	// the retention and a retention less than 1 keeps every backup
+	if retention := d.config.MySQLDump.Retention; retention > 0 {
+		for i := retention + 1; i < len(artifacts); i++ {
+			if err := os.Remove(artifacts[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("MySQL Dumper: failed to remove old backup %s: %v", artifacts[i], err)
 			}
-		}()
+		}
+	}
 
-		// start the command
-		if err := cmd.Start(); err != nil {
-			stat.Finish(fmt.Errorf("MySQL Dumper: failed to start backup: %v", err))
-			return stat
+	return nil
+}
+
+// linkLatest atomically replaces latest with a hard link to artifact. A relative symlink is used when
+// the file system doesn't support hard links.
+func linkLatest(artifact, latest string) error {
+	tmp := latest + ".tmp"
+	os.Remove(tmp)
+	if err := linkFile(artifact, tmp); err != nil {
+		if err := os.Symlink(filepath.Base(artifact), tmp); err != nil {
+			return err
 		}
+	}
+	if err := os.Rename(tmp, latest); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
 
-		err = cmd.Wait()
-		stat = stat.Finish(err)
-		return stat
-	}(stat)
+// artifacts returns the paths of the stored backups sorted newest first.
+func (d *MySQLDumpDumper) artifacts() ([]string, error) {
+	dir, prefix, ext := d.artifactName()
 
-	if stat.Success {
-		log.Infof("Finished %s after %s", stat.Name, stat.Duration)
-	} else {
-		log.Errorf("Error %s: after %s: %s", stat.Name, stat.Duration, stat.Error)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("MySQL Dumper: failed to read dump directory %s: %v", dir, err)
+	}
+
+	var artifacts []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
 
+		if _, ok := artifactStart(entry.Name(), prefix, ext); !ok {
+			continue
+		}
+
+		artifacts = append(artifacts, filepath.Join(dir, entry.Name()))
 	}
 
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	d.running = false
-	d.cancel = nil
+	sort.Sort(sort.Reverse(sort.StringSlice(artifacts)))
 
-	return stat
+	return artifacts, nil
+}
+
+// artifactStart returns the start time in the artifact file name and false if name isn't an
+// artifact of the output path with prefix and ext.
+func artifactStart(name, prefix, ext string) (time.Time, bool) {
+	if !strings.HasPrefix(name, prefix+"-") {
+		return time.Time{}, false
+	}
+
+	timestamp := strings.TrimPrefix(name, prefix+"-")
+	timestamp = strings.TrimSuffix(timestamp, ".gz")
+	if !strings.HasSuffix(timestamp, ext) {
+		return time.Time{}, false
+	}
+	timestamp = strings.TrimSuffix(timestamp, ext)
+
+	start, err := time.Parse(artifactTimeFormat, timestamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return start, true
+}
+
+// Backups returns the artifacts on disk sorted newest first. The run ID and checksum of each
+// artifact are taken from the stat of the run that created it.
+func (d *MySQLDumpDumper) Backups(stats []Stat) ([]Backup, error) {
+	artifacts, err := d.artifacts()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make(map[string]Stat)
+	for _, stat := range stats {
+		if stat.Name != d.Name() || !stat.Success {
+			continue
+		}
+		for _, artifact := range stat.Artifacts {
+			runs[artifact] = stat
+		}
+	}
+
+	_, prefix, ext := d.artifactName()
+
+	backups := make([]Backup, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		info, err := os.Stat(artifact)
+		if err != nil {
+			continue
+		}
+
+		backup := Backup{
+			Name: d.Name(),
+			Path: artifact,
+			Size: info.Size(),
+		}
+		backup.Start, _ = artifactStart(filepath.Base(artifact), prefix, ext)
+
+		if stat, ok := runs[artifact]; ok {
+			backup.Start = stat.Start
+			backup.RunID = stat.RunID
+			backup.Checksum = stat.Checksum
+		}
+
+		backups = append(backups, backup)
+	}
+
+	return backups, nil
+}
+
+// Restore streams the backup read from r into the database at dsn using the mysql client. The dsn
+// is in the format of github.com/go-sql-driver/mysql such as user:pass@tcp(host:3306)/db. The
+// password is passed in the environment so it isn't visible in the process list.
+func (d *MySQLDumpDumper) Restore(ctx context.Context, r io.Reader, dsn string, out io.Writer) error {
+	args, user, password, err := mysqlClientArgs(dsn)
+	if err != nil {
+		return err
+	}
+
+	// the credentials are passed in an option file like they are to mysqldump
+	if user != "" || password != "" {
+		defaultsFile, err := writeDefaultsFile(d.config.LibPath, user, password)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(defaultsFile)
+
+		// mysql requires --defaults-extra-file to be the first argument
+		args = append([]string{"--defaults-extra-file=" + defaultsFile}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, d.config.MySQLDump.RestoreExecutablePath, args...)
+	cmd.Stdin = r
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("MySQL Dumper: failed to restore backup: %w", err)
+	}
+
+	return nil
+}
+
+// mysqlClientArgs returns the mysql client arguments, user, and password to connect to dsn.
+func mysqlClientArgs(dsn string) ([]string, string, string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("MySQL Dumper: invalid dsn: %w", err)
+	}
+
+	var args []string
+	switch cfg.Net {
+	case "unix":
+		args = append(args, "--socket="+cfg.Addr)
+	default:
+		host, port, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, "", "", fmt.Errorf("MySQL Dumper: invalid dsn address %s: %w", cfg.Addr, err)
+		}
+		args = append(args, "--host="+host, "--port="+port, "--protocol=TCP")
+	}
+
+	if cfg.DBName != "" {
+		args = append(args, cfg.DBName)
+	}
+
+	return args, cfg.User, cfg.Passwd, nil
+}
+
+func sameFileAsAny(info os.FileInfo, paths []string) bool {
+	for _, path := range paths {
+		other, err := os.Stat(path)
+		if err == nil && os.SameFile(info, other) {
+			return true
+		}
+	}
+	return false
 }
 
 // Stop stops the current dump if one is running.
 func (d *MySQLDumpDumper) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	if d.cancel != nil {
 		d.cancel()
 	}
@@ -1,7 +1,15 @@
 package repbak
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
@@ -13,8 +21,290 @@ func TestMySQLDumpDumper(t *testing.T) {
 
 	dumper := NewMySQLDumpDumper(config)
 
-	stat := dumper.Dump()
+	stat := dumper.Dump(TriggerManual)
 	assert.Error(t, stat.Error)
 
 	dumper.Stop()
 }
+
+func TestMySQLDumpDumperArtifacts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "backups", "mysql.dump")
+
+	// a backup left by a previous version of repbak
+	assert.Nil(t, os.MkdirAll(filepath.Dir(output), 0755))
+	assert.Nil(t, os.WriteFile(output, []byte("old"), 0644))
+	assert.Nil(t, os.Chtimes(output, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour)))
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:      2,
+			OutputPath:     output,
+			ExecutablePath: "echo",
+			ExecutableArgs: "backup",
+		},
+	}
+
+	dumper := NewMySQLDumpDumper(config)
+
+	stat := dumper.Dump(TriggerManual)
+	assert.Nil(t, stat.Error)
+	assert.True(t, stat.Success)
+	assert.Equal(t, stat.Trigger, TriggerManual)
+	assert.Equal(t, stat.ExitCode, 0)
+	assert.Len(t, stat.Artifacts, 1)
+	assert.Equal(t, stat.BytesWritten, int64(len("backup\n")))
+	assert.Equal(t, stat.CompressedSize, int64(0))
+
+	sum := sha256.Sum256([]byte("backup\n"))
+	assert.Equal(t, stat.Checksum, hex.EncodeToString(sum[:]))
+
+	data, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "backup\n")
+
+	artifacts, err := dumper.artifacts()
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, artifacts[0], stat.Artifacts[0])
+
+	// rotation keeps the latest and the newest retention backups before it
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond * 2)
+		stat = dumper.Dump(TriggerManual)
+		assert.True(t, stat.Success)
+	}
+
+	artifacts, err = dumper.artifacts()
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 3)
+	assert.Equal(t, artifacts[0], stat.Artifacts[0])
+
+	// failed backups don't leave partial artifacts
+	config.MySQLDump.ExecutablePath = "false"
+	time.Sleep(time.Millisecond * 2)
+	stat = dumper.Dump(TriggerManual)
+	assert.False(t, stat.Success)
+	assert.Equal(t, stat.ExitCode, 1)
+	assert.Len(t, stat.Artifacts, 0)
+
+	artifacts, err = dumper.artifacts()
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 3)
+}
+
+func TestMySQLDumpDumperSymlink(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// file systems without hard links
+	linkFile = func(string, string) error { return &os.LinkError{Op: "link", Err: os.ErrPermission} }
+	defer func() { linkFile = os.Link }()
+
+	output := filepath.Join(dir, "mysql.dump")
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:      2,
+			OutputPath:     output,
+			ExecutablePath: "echo",
+			ExecutableArgs: "backup",
+		},
+	}
+
+	dumper := NewMySQLDumpDumper(config)
+
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 2)
+		stat := dumper.Dump(TriggerManual)
+		assert.Nil(t, stat.Error)
+		assert.True(t, stat.Success)
+
+		target, err := os.Readlink(output)
+		assert.Nil(t, err)
+		assert.Equal(t, target, filepath.Base(stat.Artifacts[0]))
+	}
+
+	// the symlink isn't mistaken for a backup from an older version of repbak
+	artifacts, err := dumper.artifacts()
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 3)
+
+	data, err := os.ReadFile(output)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "backup\n")
+}
+
+func TestMySQLDumpDumperKeepAll(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:      -1,
+			OutputPath:     filepath.Join(dir, "mysql.dump"),
+			ExecutablePath: "echo",
+			ExecutableArgs: "backup",
+		},
+	}
+
+	dumper := NewMySQLDumpDumper(config)
+
+	// a retention less than 1 keeps every backup
+	for i := 0; i < 3; i++ {
+		time.Sleep(time.Millisecond * 2)
+		stat := dumper.Dump(TriggerManual)
+		assert.Nil(t, stat.Error)
+		assert.True(t, stat.Success)
+	}
+
+	artifacts, err := dumper.artifacts()
+	assert.Nil(t, err)
+	assert.Len(t, artifacts, 3)
+}
+
+func TestMySQLDumpDumperCompress(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "mysql.dump")
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:      2,
+			OutputPath:     output,
+			ExecutablePath: "echo",
+			ExecutableArgs: "backup",
+			Compress:       true,
+		},
+	}
+
+	stat := NewMySQLDumpDumper(config).Dump(TriggerManual)
+	assert.Nil(t, stat.Error)
+	assert.Len(t, stat.Artifacts, 1)
+	assert.True(t, strings.HasSuffix(stat.Artifacts[0], ".dump.gz"))
+	assert.Equal(t, stat.BytesWritten, int64(len("backup\n")))
+
+	info, err := os.Stat(output + ".gz")
+	assert.Nil(t, err)
+	assert.Equal(t, stat.CompressedSize, info.Size())
+
+	f, err := os.Open(output + ".gz")
+	assert.Nil(t, err)
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	assert.Nil(t, err)
+	data, err := io.ReadAll(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "backup\n")
+}
+
+func TestMySQLDumpDumperPassword(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:      2,
+			OutputPath:     filepath.Join(dir, "backups", "mysql.dump"),
+			ExecutablePath: "echo",
+			ExecutableArgs: "backup",
+			Password:       "s3cr3t",
+		},
+	}
+
+	stat := NewMySQLDumpDumper(config).Dump(TriggerManual)
+	assert.True(t, stat.Success)
+
+	// the password is passed in an option file that's removed after the dump
+	data, err := os.ReadFile(stat.Artifacts[0])
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "--defaults-extra-file="+dir))
+	assert.True(t, strings.HasSuffix(string(data), " backup\n"))
+	assert.NotContains(t, string(data), "s3cr3t")
+
+	matches, err := filepath.Glob(filepath.Join(dir, "mysqldump-*.cnf"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 0)
+
+	path, err := writeDefaultsFile(dir, "", `pa"ss\word`)
+	assert.Nil(t, err)
+	defer os.Remove(path)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+
+	data, err = os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "[client]\npassword=\"pa\\\"ss\\\\word\"\n")
+}
+
+func TestMySQLDumpArgs(t *testing.T) {
+	args, err := mysqldumpArgs(&MySQLDump{ExecutableArgs: "--add-drop-database --all-databases"})
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"--add-drop-database", "--all-databases"})
+
+	args, err = mysqldumpArgs(&MySQLDump{
+		Host:           "db.example.com",
+		Port:           3307,
+		TLS:            &MySQLTLS{Mode: "verify_ca", CAFile: "ca.pem", CertFile: "cert.pem", KeyFile: "key.pem"},
+		ExecutableArgs: `--single-transaction --where='id > 10'`,
+		Databases:      []string{"app", "users"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{
+		"--host=db.example.com",
+		"--port=3307",
+		"--ssl-mode=VERIFY_CA",
+		"--ssl-ca=ca.pem",
+		"--ssl-cert=cert.pem",
+		"--ssl-key=key.pem",
+		"--single-transaction",
+		"--where=id > 10",
+		"--databases",
+		"app",
+		"users",
+	})
+
+	args, err = mysqldumpArgs(&MySQLDump{Socket: "/run/mysqld/mysqld.sock"})
+	assert.Nil(t, err)
+	assert.Equal(t, args, []string{"--socket=/run/mysqld/mysqld.sock"})
+
+	_, err = mysqldumpArgs(&MySQLDump{ExecutableArgs: "'unterminated"})
+	assert.Error(t, err)
+
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path, err := writeDefaultsFile(dir, "backup user", "")
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "[client]\nuser=\"backup user\"\n")
+}
@@ -0,0 +1,180 @@
+package repbak
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownJob is returned when a backup job isn't configured.
+var ErrUnknownJob = errors.New("unknown backup job")
+
+// States of a backup job reported by the health checks.
+const (
+	HealthOK       = "ok"
+	HealthFailed   = "failed"
+	HealthStale    = "stale"
+	HealthOverdue  = "overdue"
+	HealthNeverRun = "never_run"
+
+	// HealthSkipped is a job whose latest scheduled backup was skipped. It's healthy.
+	HealthSkipped = "skipped"
+
+	// HealthMaintenance is a job paused by maintenance mode. It's healthy.
+	HealthMaintenance = "maintenance"
+)
+
+// JobHealth describes the health of a single backup job.
+type JobHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+
+	// State is ok, failed, stale, overdue, never_run, skipped, or maintenance.
+	State string `json:"state"`
+
+	// Reason explains why the job is unhealthy, skipped, or paused.
+	Reason string `json:"reason,omitempty"`
+
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+
+	// RunningSince is when the running backup started if one is running.
+	RunningSince *time.Time `json:"running_since,omitempty"`
+}
+
+// HealthReport is the health of every backup job. It's healthy only if every job is healthy.
+type HealthReport struct {
+	Healthy bool        `json:"healthy"`
+	Jobs    []JobHealth `json:"jobs"`
+
+	// Maintenance is set while maintenance mode is enabled.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// Health returns the health of every backup job.
+func (r *RepBak) Health() (HealthReport, error) {
+	job, err := r.JobHealth(r.dumper.Name())
+	if err != nil {
+		return HealthReport{}, err
+	}
+
+	report := HealthReport{
+		Healthy: job.Healthy,
+		Jobs:    []JobHealth{job},
+	}
+	if maintenance := r.Maintenance(); maintenance.Enabled {
+		report.Maintenance = &maintenance
+	}
+	return report, nil
+}
+
+// JobHealth returns the health of the backup job name. A job is unhealthy if its last run failed, it's
+// been running longer than its time_limit, or its last success is older than its max_age. A job that
+// never succeeded is only unhealthy once repbak has been running longer than max_age.
+func (r *RepBak) JobHealth(name string) (JobHealth, error) {
+	if _, err := r.Job(name); err != nil {
+		return JobHealth{}, err
+	}
+
+	now := r.now()
+	timeLimit, maxAge := r.currentConfig().healthLimits(name)
+
+	health := JobHealth{
+		Name:    name,
+		Healthy: true,
+		State:   HealthOK,
+	}
+
+	latest, err := r.db.Latest()
+	if err != nil {
+		return JobHealth{}, err
+	}
+
+	lastSuccess, err := r.db.LastSuccess(name)
+	if err != nil {
+		return JobHealth{}, err
+	}
+
+	// skipped backups are reported but the failure of the run before them isn't hidden
+	var skipReason string
+	if stat, ok := latest[name]; ok && stat.Skip {
+		skipReason = stat.SkipReason
+
+		run, err := r.lastRun(name, stat.Start)
+		if err != nil {
+			return JobHealth{}, err
+		}
+		if run == nil {
+			delete(latest, name)
+		} else {
+			latest[name] = *run
+		}
+	}
+
+	lastFailed := false
+	if stat, ok := latest[name]; ok {
+		health.LastRun = &stat.End
+		health.LastError = stat.ErrorMessage
+		// a failed attempt that's being retried isn't reported until the final attempt
+		lastFailed = !stat.Success && !stat.Retried
+	}
+	if lastSuccess != nil {
+		health.LastSuccess = &lastSuccess.End
+	}
+	if since := r.dumper.RunningSince(); !since.IsZero() {
+		health.RunningSince = &since
+	}
+
+	unhealthy := func(state, reason string) {
+		health.Healthy = false
+		health.State = state
+		health.Reason = reason
+	}
+
+	maintenance := r.Maintenance()
+
+	switch {
+	case health.RunningSince != nil && timeLimit > 0 && now.Sub(*health.RunningSince) > timeLimit:
+		unhealthy(HealthOverdue, fmt.Sprintf("running for %s which is past the time limit of %s", now.Sub(*health.RunningSince).Round(time.Second), timeLimit))
+	case maintenance.Enabled:
+		health.State = HealthMaintenance
+		health.Reason = "maintenance mode is enabled"
+		if maintenance.Reason != "" {
+			health.Reason += ": " + maintenance.Reason
+		}
+	case lastFailed:
+		unhealthy(HealthFailed, fmt.Sprintf("last run failed: %s", health.LastError))
+	case maxAge > 0 && lastSuccess != nil && now.Sub(lastSuccess.End) > maxAge:
+		unhealthy(HealthStale, fmt.Sprintf("last success was %s ago which is older than the max age of %s", now.Sub(lastSuccess.End).Round(time.Second), maxAge))
+	case maxAge > 0 && lastSuccess == nil && now.Sub(r.started) > maxAge:
+		unhealthy(HealthNeverRun, fmt.Sprintf("no successful backup since repbak started %s ago", now.Sub(r.started).Round(time.Second)))
+	case skipReason != "":
+		health.State = HealthSkipped
+		health.Reason = "last scheduled backup was skipped: " + skipReason
+	}
+
+	return health, nil
+}
+
+// lastRun returns the latest stat of the job name started before before that wasn't skipped or nil if
+// there isn't one.
+func (r *RepBak) lastRun(name string, before time.Time) (*Stat, error) {
+	for {
+		stats, err := r.db.Page(name, before, 20)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stat := range stats {
+			if !stat.Skip {
+				return &stat, nil
+			}
+		}
+
+		if len(stats) < 20 {
+			return nil, nil
+		}
+		before = stats[len(stats)-1].Start
+	}
+}
@@ -0,0 +1,181 @@
+package repbak
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testDumper returns stat from Dump and reports running since started.
+type testDumper struct {
+	mu      sync.Mutex
+	stat    Stat
+	results []Stat
+	started time.Time
+	dumps   []string
+	stops   int
+
+	// block holds each dump until it's closed if set
+	block chan struct{}
+}
+
+func (d *testDumper) Name() string {
+	return "mysqldump"
+}
+
+func (d *testDumper) Dump(trigger string) Stat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dumps = append(d.dumps, trigger)
+
+	if d.block != nil {
+		d.mu.Unlock()
+		<-d.block
+		d.mu.Lock()
+	}
+
+	// results are returned in order before stat
+	if len(d.results) > 0 {
+		stat := d.results[0]
+		d.results = d.results[1:]
+		return stat
+	}
+	return d.stat
+}
+
+func (d *testDumper) RunningSince() time.Time {
+	return d.started
+}
+
+func (d *testDumper) Stop() {
+	d.stops++
+}
+
+// triggers returns the triggers of each dump.
+func (d *testDumper) triggers() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.dumps...)
+}
+
+func TestRepBakHealth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+	config.MySQLDump.timeLimit = time.Hour
+	config.MySQLDump.maxAge = 26 * time.Hour
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	dumper := &testDumper{}
+	rb := New(config, db, dumper, &testNotifier{})
+
+	now := time.Now()
+	rb.now = func() time.Time { return now }
+
+	// healthy until max_age passes without a success
+	report, err := rb.Health()
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy)
+	assert.Len(t, report.Jobs, 1)
+	assert.Equal(t, report.Jobs[0].State, HealthOK)
+	assert.Nil(t, report.Jobs[0].LastRun)
+
+	rb.started = now.Add(-27 * time.Hour)
+	health, err := rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, health.State, HealthNeverRun)
+
+	// stale success
+	success := NewStat("mysqldump").Finish(nil)
+	success.End = now.Add(-30 * time.Hour)
+	assert.Nil(t, db.Insert(success))
+
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, health.State, HealthStale)
+	assert.True(t, health.LastSuccess.Equal(success.End))
+
+	// recent success
+	success = NewStat("mysqldump").Finish(nil)
+	assert.Nil(t, db.Insert(success))
+
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, health.State, HealthOK)
+
+	// running past the time limit
+	dumper.started = now.Add(-2 * time.Hour)
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, health.State, HealthOverdue)
+	assert.True(t, health.RunningSince.Equal(dumper.started))
+
+	dumper.started = now.Add(-time.Minute)
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.True(t, health.Healthy)
+
+	// failed
+	assert.Nil(t, db.Insert(NewStat("mysqldump").Finish(errors.New("ERROR"))))
+
+	report, err = rb.Health()
+	assert.Nil(t, err)
+	assert.False(t, report.Healthy)
+	assert.Equal(t, report.Jobs[0].State, HealthFailed)
+	assert.Equal(t, report.Jobs[0].LastError, "ERROR")
+
+	// a skipped backup doesn't hide the failure before it
+	skip := NewStat("mysqldump")
+	skip.Skip = true
+	skip.SkipReason = "blackout window"
+	assert.Nil(t, db.Insert(skip))
+
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.False(t, health.Healthy)
+	assert.Equal(t, health.State, HealthFailed)
+
+	// skipped after a success
+	assert.Nil(t, db.Insert(NewStat("mysqldump").Finish(nil)))
+	skip = NewStat("mysqldump")
+	skip.Skip = true
+	skip.SkipReason = "blackout window"
+	assert.Nil(t, db.Insert(skip))
+
+	health, err = rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.True(t, health.Healthy)
+	assert.Equal(t, health.State, HealthSkipped)
+	assert.Contains(t, health.Reason, "blackout window")
+
+	// maintenance mode is healthy
+	_, err = rb.SetMaintenance(true, "upgrade")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Insert(NewStat("mysqldump").Finish(errors.New("ERROR"))))
+
+	report, err = rb.Health()
+	assert.Nil(t, err)
+	assert.True(t, report.Healthy)
+	assert.Equal(t, report.Jobs[0].State, HealthMaintenance)
+	assert.Equal(t, report.Maintenance.Reason, "upgrade")
+
+	_, err = rb.JobHealth("MISSING")
+	assert.ErrorIs(t, err, ErrUnknownJob)
+}
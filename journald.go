@@ -0,0 +1,152 @@
+package repbak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// journaldSocket is the socket for the systemd journal native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldWriter writes entries directly to the systemd journal using the native protocol.
+type journaldWriter struct {
+	socket     string
+	identifier string
+	mu         sync.Mutex
+	conn       *net.UnixConn
+}
+
+func newJournaldWriter(identifier string) *journaldWriter {
+	return &journaldWriter{
+		socket:     journaldSocket,
+		identifier: identifier,
+	}
+}
+
+// write sends msg with the syslog priority and extra fields to the journal. Field names must be
+// uppercase letters, digits, and underscores.
+func (w *journaldWriter) write(priority int, msg string, fields map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: w.socket, Net: "unixgram"})
+		if err != nil {
+			return fmt.Errorf("Journald: failed to connect to %s: %w", w.socket, err)
+		}
+		w.conn = conn
+	}
+
+	if _, err := w.conn.Write(w.format(priority, msg, fields)); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("Journald: failed to write entry: %w", err)
+	}
+
+	return nil
+}
+
+// format serializes an entry in the journal native protocol.
+func (w *journaldWriter) format(priority int, msg string, fields map[string]string) []byte {
+	var b bytes.Buffer
+
+	journaldField(&b, "MESSAGE", msg)
+	journaldField(&b, "PRIORITY", fmt.Sprint(priority))
+	journaldField(&b, "SYSLOG_IDENTIFIER", w.identifier)
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		journaldField(&b, key, fields[key])
+	}
+
+	return b.Bytes()
+}
+
+// Close closes the connection to the journal.
+func (w *journaldWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// journaldField writes a single field. Values containing newlines use the binary length prefixed form.
+func journaldField(b *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journaldFieldName converts a logrus field name into a valid journal field name.
+func journaldFieldName(name string) string {
+	name = strings.ToUpper(name)
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	// fields starting with an underscore are trusted fields set by journald
+	return strings.TrimLeft(name, "_")
+}
+
+// JournaldHook is a logrus hook that writes repbak's log to the systemd journal.
+type JournaldHook struct {
+	writer *journaldWriter
+}
+
+// NewJournaldHook creates a JournaldHook using the journald configuration.
+func NewJournaldHook(config *Config) *JournaldHook {
+	identifier := "repbak"
+	if config.Journald != nil {
+		identifier = config.Journald.Identifier
+	}
+
+	return &JournaldHook{
+		writer: newJournaldWriter(identifier),
+	}
+}
+
+// Levels returns all log levels.
+func (h *JournaldHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire writes the log entry to the journal.
+func (h *JournaldHook) Fire(entry *log.Entry) error {
+	fields := make(map[string]string, len(entry.Data))
+	for key, value := range entry.Data {
+		fields[key] = fmt.Sprint(value)
+	}
+
+	return h.writer.write(syslogSeverity(entry.Level), entry.Message, journaldFields(fields))
+}
+
+// Close closes the connection to the journal.
+func (h *JournaldHook) Close() error {
+	return h.writer.Close()
+}
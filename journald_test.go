@@ -0,0 +1,86 @@
+package repbak
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournaldWriter(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	writer := newJournaldWriter("repbak")
+	writer.socket = socket
+	defer writer.Close()
+
+	err = writer.write(syslogErr, "Backup failed", map[string]string{
+		"REPBAK_JOB":   "mysqldump",
+		"REPBAK_ERROR": "line 1\nline 2",
+	})
+	assert.Nil(t, err)
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+
+	var expected bytes.Buffer
+	expected.WriteString("MESSAGE=Backup failed\nPRIORITY=3\nSYSLOG_IDENTIFIER=repbak\nREPBAK_ERROR\n")
+	binary.Write(&expected, binary.LittleEndian, uint64(len("line 1\nline 2")))
+	expected.WriteString("line 1\nline 2\nREPBAK_JOB=mysqldump\n")
+
+	assert.Equal(t, expected.Bytes(), buf[:n])
+}
+
+func TestJournaldWriterConnectError(t *testing.T) {
+	writer := newJournaldWriter("repbak")
+	writer.socket = "/nonexistent/journal.sock"
+
+	err := writer.write(syslogInfo, "hello", nil)
+	assert.Error(t, err)
+}
+
+func TestJournaldFieldName(t *testing.T) {
+	assert.Equal(t, journaldFieldName("job"), "JOB")
+	assert.Equal(t, journaldFieldName("run-id"), "RUN_ID")
+	assert.Equal(t, journaldFieldName("_private"), "PRIVATE")
+}
+
+func TestJournaldHook(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	hook := NewJournaldHook(&Config{})
+	hook.writer.socket = socket
+	defer hook.Close()
+
+	logger := log.New()
+	logger.AddHook(hook)
+	logger.SetOutput(io.Discard)
+	logger.WithField("stream", "stderr").Error("hook message")
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "MESSAGE=hook message\nPRIORITY=3\nSYSLOG_IDENTIFIER=repbak\nREPBAK_STREAM=stderr\n", string(buf[:n]))
+}
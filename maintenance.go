@@ -0,0 +1,109 @@
+package repbak
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Maintenance is the state of maintenance mode. Scheduled backups of every job are skipped while it's
+// enabled. It's stored under lib_path so it's kept across restarts.
+type Maintenance struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+// maintenancePath is the path of the stored maintenance mode.
+func maintenancePath(config *Config) string {
+	return filepath.Join(config.LibPath, "maintenance.json")
+}
+
+// LoadMaintenance returns the stored maintenance mode. Maintenance mode is disabled if it isn't stored.
+func LoadMaintenance(config *Config) (Maintenance, error) {
+	var maintenance Maintenance
+
+	data, err := os.ReadFile(maintenancePath(config))
+	if errors.Is(err, os.ErrNotExist) {
+		return maintenance, nil
+	}
+	if err != nil {
+		return maintenance, fmt.Errorf("Maintenance: failed to read %s: %w", maintenancePath(config), err)
+	}
+
+	if err := json.Unmarshal(data, &maintenance); err != nil {
+		return maintenance, fmt.Errorf("Maintenance: failed to decode %s: %w", maintenancePath(config), err)
+	}
+	return maintenance, nil
+}
+
+// StoreMaintenance stores maintenance. The stored state is removed if maintenance mode is disabled.
+func StoreMaintenance(config *Config, maintenance Maintenance) error {
+	path := maintenancePath(config)
+
+	if !maintenance.Enabled {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("Maintenance: failed to remove %s: %w", path, err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(maintenance)
+	if err != nil {
+		return fmt.Errorf("Maintenance: failed to encode: %w", err)
+	}
+
+	if err := os.MkdirAll(config.LibPath, 0755); err != nil {
+		return fmt.Errorf("Maintenance: failed to create %s: %w", config.LibPath, err)
+	}
+
+	// write atomically so a crash doesn't leave a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("Maintenance: failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Maintenance: failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Maintenance returns the maintenance mode.
+func (r *RepBak) Maintenance() Maintenance {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.maintenance
+}
+
+// SetMaintenance enables or disables maintenance mode. Reason is recorded with the backups skipped
+// while it's enabled.
+func (r *RepBak) SetMaintenance(enabled bool, reason string) (Maintenance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	maintenance := Maintenance{Enabled: enabled}
+	if enabled {
+		now := r.now()
+		maintenance.Since = &now
+		maintenance.Reason = reason
+	}
+
+	if err := StoreMaintenance(r.config, maintenance); err != nil {
+		return r.maintenance, err
+	}
+	r.maintenance = maintenance
+
+	if enabled {
+		log.Warnf("Maintenance mode enabled: %s", reason)
+	} else {
+		log.Warn("Maintenance mode disabled")
+	}
+
+	return maintenance, nil
+}
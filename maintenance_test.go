@@ -0,0 +1,44 @@
+package repbak
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	// disabled without a stored state
+	maintenance, err := LoadMaintenance(config)
+	assert.Nil(t, err)
+	assert.False(t, maintenance.Enabled)
+
+	since := time.Now().Truncate(time.Second)
+	assert.Nil(t, StoreMaintenance(config, Maintenance{Enabled: true, Since: &since, Reason: "upgrade"}))
+
+	maintenance, err = LoadMaintenance(config)
+	assert.Nil(t, err)
+	assert.True(t, maintenance.Enabled)
+	assert.True(t, maintenance.Since.Equal(since))
+	assert.Equal(t, maintenance.Reason, "upgrade")
+
+	// disabling removes the stored state
+	assert.Nil(t, StoreMaintenance(config, Maintenance{}))
+	_, err = os.Stat(maintenancePath(config))
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, StoreMaintenance(config, Maintenance{}))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "maintenance.json"), []byte("{"), 0600))
+	_, err = LoadMaintenance(config)
+	assert.Error(t, err)
+}
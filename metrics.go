@@ -0,0 +1,340 @@
+package repbak
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jobMetrics are the metrics of a single backup job.
+type jobMetrics struct {
+	lastRun      time.Time
+	lastSuccess  time.Time
+	lastDuration time.Duration
+	lastSize     int64
+	runs         int
+	failures     int
+	skips        int
+	retries      int
+	running      int
+}
+
+// notifierMetrics are the delivery metrics of a single notifier.
+type notifierMetrics struct {
+	failures int
+}
+
+// Metrics collects backup and notification metrics and serves them in the Prometheus text
+// exposition format. All methods are safe to call on a nil Metrics.
+type Metrics struct {
+	mu        sync.Mutex
+	jobs      map[string]*jobMetrics
+	notifiers map[string]*notifierMetrics
+}
+
+// NewMetrics creates Metrics with the last run and last success of each job loaded from db so the
+// timestamps survive restarts. Counters start at 0.
+func NewMetrics(db DB) (*Metrics, error) {
+	m := &Metrics{
+		jobs:      make(map[string]*jobMetrics),
+		notifiers: make(map[string]*notifierMetrics),
+	}
+
+	latest, err := db.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	for name, stat := range latest {
+		job := m.job(name)
+		job.lastRun = stat.End
+		job.lastDuration = stat.Duration
+		job.lastSize = statSize(stat)
+
+		success, err := db.LastSuccess(name)
+		if err != nil {
+			return nil, err
+		}
+		if success != nil {
+			job.lastSuccess = success.End
+		}
+	}
+
+	return m, nil
+}
+
+// job returns the metrics for the job name. The lock must be held.
+func (m *Metrics) job(name string) *jobMetrics {
+	job, ok := m.jobs[name]
+	if !ok {
+		job = &jobMetrics{}
+		m.jobs[name] = job
+	}
+	return job
+}
+
+// notifier returns the metrics for the notifier name. The lock must be held.
+func (m *Metrics) notifier(name string) *notifierMetrics {
+	notifier, ok := m.notifiers[name]
+	if !ok {
+		notifier = &notifierMetrics{}
+		m.notifiers[name] = notifier
+	}
+	return notifier
+}
+
+// AddJob adds the job name so its metrics are reported before it first runs.
+func (m *Metrics) AddJob(name string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.job(name)
+}
+
+// Started records that a run of the job name started.
+func (m *Metrics) Started(name string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.job(name).running++
+}
+
+// Finished records the stat of a run started with Started. Failed attempts that are retried are only
+// counted as retries.
+func (m *Metrics) Finished(stat Stat) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.job(stat.Name)
+	if job.running > 0 {
+		job.running--
+	}
+
+	m.record(job, stat)
+}
+
+// RetryCanceled records a retried attempt as the final attempt when its retry is canceled.
+func (m *Metrics) RetryCanceled(stat Stat) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.job(stat.Name)
+	if job.retries > 0 {
+		job.retries--
+	}
+
+	m.record(job, stat)
+}
+
+// record counts stat. The lock must be held.
+func (m *Metrics) record(job *jobMetrics, stat Stat) {
+	switch {
+	case stat.Skip:
+		job.skips++
+		return
+	case stat.Retried:
+		job.retries++
+		return
+	}
+
+	job.runs++
+	job.lastRun = stat.End
+	job.lastDuration = stat.Duration
+	job.lastSize = statSize(stat)
+
+	if stat.Success {
+		job.lastSuccess = stat.End
+	} else {
+		job.failures++
+	}
+}
+
+// Skipped records a skipped run that wasn't started with Started.
+func (m *Metrics) Skipped(name string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.job(name).skips++
+}
+
+// Wrap returns a Notifier that counts the delivery failures of notifier under name.
+func (m *Metrics) Wrap(name string, notifier Notifier) Notifier {
+	if m == nil {
+		return notifier
+	}
+
+	m.mu.Lock()
+	m.notifier(name)
+	m.mu.Unlock()
+
+	return &metricsNotifier{
+		name:     name,
+		metrics:  m,
+		notifier: notifier,
+	}
+}
+
+func (m *Metrics) delivered(name string, err error) {
+	if err == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.notifier(name).failures++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	if m != nil {
+		m.mu.Lock()
+		m.write(&b)
+		m.mu.Unlock()
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) write(b *strings.Builder) {
+	jobs := make([]string, 0, len(m.jobs))
+	for name := range m.jobs {
+		jobs = append(jobs, name)
+	}
+	sort.Strings(jobs)
+
+	notifiers := make([]string, 0, len(m.notifiers))
+	for name := range m.notifiers {
+		notifiers = append(notifiers, name)
+	}
+	sort.Strings(notifiers)
+
+	jobMetric := func(name, kind, help string, value func(job *jobMetrics) float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, job := range jobs {
+			fmt.Fprintf(b, "%s{backup=\"%s\"} %v\n", name, metricsEscaper.Replace(job), value(m.jobs[job]))
+		}
+	}
+
+	notifierMetric := func(name, help string, value func(notifier *notifierMetrics) float64) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, notifier := range notifiers {
+			fmt.Fprintf(b, "%s{notifier=\"%s\"} %v\n", name, metricsEscaper.Replace(notifier), value(m.notifiers[notifier]))
+		}
+	}
+
+	jobMetric("repbak_last_run_timestamp_seconds", "gauge", "Unix time the last run of the backup finished.", func(job *jobMetrics) float64 {
+		return unixSeconds(job.lastRun)
+	})
+	jobMetric("repbak_last_success_timestamp_seconds", "gauge", "Unix time the last successful run of the backup finished.", func(job *jobMetrics) float64 {
+		return unixSeconds(job.lastSuccess)
+	})
+	jobMetric("repbak_last_duration_seconds", "gauge", "Duration of the last run of the backup.", func(job *jobMetrics) float64 {
+		return job.lastDuration.Seconds()
+	})
+	jobMetric("repbak_last_size_bytes", "gauge", "Size on disk of the backup written by the last run.", func(job *jobMetrics) float64 {
+		return float64(job.lastSize)
+	})
+	jobMetric("repbak_runs_total", "counter", "Number of finished runs of the backup.", func(job *jobMetrics) float64 {
+		return float64(job.runs)
+	})
+	jobMetric("repbak_failures_total", "counter", "Number of failed runs of the backup.", func(job *jobMetrics) float64 {
+		return float64(job.failures)
+	})
+	jobMetric("repbak_skips_total", "counter", "Number of runs skipped because the previous run was still running.", func(job *jobMetrics) float64 {
+		return float64(job.skips)
+	})
+	jobMetric("repbak_retries_total", "counter", "Number of failed attempts of the backup that were retried.", func(job *jobMetrics) float64 {
+		return float64(job.retries)
+	})
+	jobMetric("repbak_running", "gauge", "1 if the backup is currently running.", func(job *jobMetrics) float64 {
+		if job.running > 0 {
+			return 1
+		}
+		return 0
+	})
+
+	notifierMetric("repbak_notifier_delivery_failures_total", "Number of notification delivery attempts that failed including retries.", func(notifier *notifierMetrics) float64 {
+		return float64(notifier.failures)
+	})
+}
+
+// metricsEscaper escapes label values as defined by the Prometheus text exposition format.
+var metricsEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// statSize is the size on disk of the backup written by stat.
+func statSize(stat Stat) int64 {
+	if stat.CompressedSize > 0 {
+		return stat.CompressedSize
+	}
+	return stat.BytesWritten
+}
+
+// unixSeconds returns t as fractional unix seconds or 0 if t is zero.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// metricsNotifier counts the delivery failures of a Notifier.
+type metricsNotifier struct {
+	name     string
+	metrics  *Metrics
+	notifier Notifier
+}
+
+// Notify sends stat with the notifier.
+func (n *metricsNotifier) Notify(stat Stat) error {
+	err := n.notifier.Notify(stat)
+	n.metrics.delivered(n.name, err)
+	return err
+}
+
+// NotifyHistory sends statMap with the notifier.
+func (n *metricsNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	err := n.notifier.NotifyHistory(statMap)
+	n.metrics.delivered(n.name, err)
+	return err
+}
+
+// NotifyDigest sends stats with the notifier.
+func (n *metricsNotifier) NotifyDigest(stats []Stat) error {
+	err := n.notifier.NotifyDigest(stats)
+	n.metrics.delivered(n.name, err)
+	return err
+}
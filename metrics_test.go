@@ -0,0 +1,95 @@
+package repbak
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := NewBoltDB(&Config{
+		LibPath:   dir,
+		Retention: 3,
+	})
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// the last success is loaded from the database
+	success := NewStat("TEST")
+	success.Start = time.Unix(1000, 0)
+	success = success.Finish(nil)
+	success.End = time.Unix(1010, 0)
+	assert.Nil(t, db.Insert(success))
+
+	metrics, err := NewMetrics(db)
+	assert.Nil(t, err)
+
+	metrics.AddJob("OTHER")
+
+	metrics.Started("TEST")
+	metrics.Started("TEST")
+	metrics.Finished(Stat{Name: "TEST", Skip: true})
+
+	failure := NewStat("TEST").Finish(errors.New("ERROR"))
+	failure.End = time.Unix(2000, 500000000)
+	failure.Duration = 90 * time.Second
+	failure.BytesWritten = 2048
+	failure.CompressedSize = 512
+
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, recorder.Body.String(), `repbak_running{backup="TEST"} 1`)
+
+	metrics.Finished(failure)
+
+	notifier := &testNotifier{err: errors.New("ERROR")}
+	wrapped := metrics.Wrap("email", notifier)
+	assert.Error(t, wrapped.Notify(failure))
+	assert.Error(t, wrapped.NotifyDigest([]Stat{failure}))
+	notifier.err = nil
+	assert.Nil(t, wrapped.NotifyHistory(nil))
+	metrics.Wrap("syslog", notifier)
+
+	var b strings.Builder
+	_, err = metrics.WriteTo(&b)
+	assert.Nil(t, err)
+	out := b.String()
+
+	assert.Contains(t, out, "# TYPE repbak_last_run_timestamp_seconds gauge\n")
+	assert.Contains(t, out, `repbak_last_run_timestamp_seconds{backup="TEST"} 2000.5`)
+	assert.Contains(t, out, `repbak_last_success_timestamp_seconds{backup="TEST"} 1010`)
+	assert.Contains(t, out, `repbak_last_success_timestamp_seconds{backup="OTHER"} 0`)
+	assert.Contains(t, out, `repbak_last_duration_seconds{backup="TEST"} 90`)
+	assert.Contains(t, out, `repbak_last_size_bytes{backup="TEST"} 512`)
+	assert.Contains(t, out, "# TYPE repbak_runs_total counter\n")
+	assert.Contains(t, out, `repbak_runs_total{backup="TEST"} 1`)
+	assert.Contains(t, out, `repbak_failures_total{backup="TEST"} 1`)
+	assert.Contains(t, out, `repbak_skips_total{backup="TEST"} 1`)
+	assert.Contains(t, out, `repbak_running{backup="TEST"} 0`)
+	assert.Contains(t, out, `repbak_notifier_delivery_failures_total{notifier="email"} 2`)
+	assert.Contains(t, out, `repbak_notifier_delivery_failures_total{notifier="syslog"} 0`)
+}
+
+func TestMetricsNil(t *testing.T) {
+	var metrics *Metrics
+	metrics.AddJob("TEST")
+	metrics.Started("TEST")
+	metrics.Finished(NewStat("TEST"))
+
+	notifier := &testNotifier{}
+	assert.Equal(t, metrics.Wrap("email", notifier), Notifier(notifier))
+
+	var b strings.Builder
+	_, err := metrics.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, b.String(), "")
+}
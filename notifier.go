@@ -1,10 +1,138 @@
 package repbak
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 // Notifier defines a notification method.
 type Notifier interface {
-	// Notify sends a notification
+	// Notify is called with the stat of each finished backup. Notifiers decide which stats they send
+	// notifications for based on their configuration.
 	Notify(stat Stat) error
 
 	// Notify History sends a notification with the backup history.
 	NotifyHistory(map[string][]Stat) error
+
+	// NotifyDigest sends a single notification summarizing stats whose notifications were suppressed.
+	NotifyDigest([]Stat) error
+}
+
+// MultiNotifier sends notifications to each of its notifiers.
+type MultiNotifier []Notifier
+
+// Notify sends stat to all notifiers. Every notifier is called even if some fail.
+func (m MultiNotifier) Notify(stat Stat) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(stat); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinNotifierErrors(errs)
+}
+
+// NotifyHistory sends statMap to all notifiers. Every notifier is called even if some fail.
+func (m MultiNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.NotifyHistory(statMap); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinNotifierErrors(errs)
+}
+
+// NotifyDigest sends stats to all notifiers. Every notifier is called even if some fail.
+func (m MultiNotifier) NotifyDigest(stats []Stat) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.NotifyDigest(stats); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return joinNotifierErrors(errs)
+}
+
+func joinNotifierErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("%d notifiers failed: %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// statMessage is a one line description of a finished backup.
+func statMessage(stat Stat) string {
+	if stat.Success {
+		return fmt.Sprintf("Backup %s succeeded after %s", stat.Name, stat.Duration)
+	}
+	return fmt.Sprintf("Backup %s failed after %s: %v", stat.Name, stat.Duration, stat.Error)
+}
+
+// statFields are the structured fields of a finished backup used by structured notifiers.
+func statFields(stat Stat) map[string]string {
+	fields := map[string]string{
+		"job":       stat.Name,
+		"run_id":    stat.RunID,
+		"trigger":   stat.Trigger,
+		"success":   fmt.Sprint(stat.Success),
+		"duration":  fmt.Sprint(stat.Duration.Seconds()),
+		"start":     stat.Start.Format(time.RFC3339Nano),
+		"end":       stat.End.Format(time.RFC3339Nano),
+		"exit_code": fmt.Sprint(stat.ExitCode),
+	}
+
+	if stat.Error != nil {
+		fields["error"] = stat.Error.Error()
+	}
+
+	if stat.Log != "" {
+		fields["log"] = stat.Log
+	}
+
+	return fields
+}
+
+// groupStats groups stats by name.
+func groupStats(stats []Stat) map[string][]Stat {
+	statMap := make(map[string][]Stat)
+	for _, stat := range stats {
+		statMap[stat.Name] = append(statMap[stat.Name], stat)
+	}
+	return statMap
+}
+
+// historyFields summarizes the stored stats of a job for structured notifiers.
+func historyFields(name string, stats []Stat) map[string]string {
+	var runs, failures, skips, retries int
+	for _, stat := range stats {
+		switch {
+		case stat.Skip:
+			skips++
+		case stat.Retried:
+			retries++
+		case !stat.Success:
+			runs++
+			failures++
+		default:
+			runs++
+		}
+	}
+
+	return map[string]string{
+		"job":      name,
+		"runs":     fmt.Sprint(runs),
+		"failures": fmt.Sprint(failures),
+		"skips":    fmt.Sprint(skips),
+		"retries":  fmt.Sprint(retries),
+	}
 }
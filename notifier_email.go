@@ -5,7 +5,11 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"text/template"
+	htmlTemplate "html/template"
+	"os"
+	"path/filepath"
+	textTemplate "text/template"
+	"time"
 
 	gomail "gopkg.in/gomail.v2"
 )
@@ -22,17 +26,137 @@ func NewEmailNotifier(config *Config) *EmailNotifier {
 	}
 }
 
-// Notify sends a failure notification
+// FailureEmail is the data available to the failure email subject and body templates.
+type FailureEmail struct {
+	// Name is the name of the backup that failed.
+	Name string
+
+	// Host is the hostname of the server running repbak.
+	Host string
+
+	// Start is the formatted start time of the backup.
+	Start string
+
+	// End is the formatted end time of the backup.
+	End string
+
+	// Duration is how long the backup ran.
+	Duration time.Duration
+
+	// ExitCode is the exit code of the dumper process or -1 if the process didn't exit normally.
+	ExitCode int
+
+	// Error is the error message of the failure.
+	Error string
+
+	// Stderr is the last tail_lines lines written to STDERR by the dumper.
+	Stderr []string
+}
+
+// Notify sends a failure notification if on_failure is set. Successful backups are ignored.
 func (n *EmailNotifier) Notify(stat Stat) error {
+	if stat.Success || !n.config.Email.OnFailure {
+		return nil
+	}
+
+	message, err := n.failureMessage(stat)
+	if err != nil {
+		return permanent(err)
+	}
+
+	return n.send(message)
+}
+
+// failureMessage renders the failure email for stat from the subject, text, and HTML templates.
+// Templates that fail to render fail the same way when retried so callers mark its errors permanent.
+func (n *EmailNotifier) failureMessage(stat Stat) (*gomail.Message, error) {
+	data := n.failureEmail(stat)
+
+	subjectTmpl, err := textTemplate.New("subject").Parse(n.config.Email.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("Email Notifier: failed to parse subject template: %w", err)
+	}
+
+	var subject bytes.Buffer
+	if err := subjectTmpl.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("Email Notifier: failed to execute subject template: %w", err)
+	}
+
+	var textTmpl *textTemplate.Template
+	if n.config.Email.FailureTextTemplate != "" {
+		textTmpl, err = textTemplate.ParseFiles(n.config.Email.FailureTextTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Email Notifier: failed to parse custom failure text template %s: %w", n.config.Email.FailureTextTemplate, err)
+		}
+	} else {
+		textTmpl, err = textTemplate.New("failure").Parse(failureTextTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Email Notifier: failed to parse failure text template: %w", err)
+		}
+	}
+
+	var htmlTmpl *htmlTemplate.Template
+	if n.config.Email.FailureTemplate != "" {
+		htmlTmpl, err = htmlTemplate.ParseFiles(n.config.Email.FailureTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Email Notifier: failed to parse custom failure template %s: %w", n.config.Email.FailureTemplate, err)
+		}
+	} else {
+		htmlTmpl, err = htmlTemplate.New("failure").Parse(failureHTMLTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("Email Notifier: failed to parse failure template: %w", err)
+		}
+	}
+
+	var text bytes.Buffer
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("Email Notifier: failed to execute failure text template: %w", err)
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("Email Notifier: failed to execute failure template: %w", err)
+	}
+
 	message := gomail.NewMessage()
 	message.SetHeader("From", n.config.Email.From)
 	message.SetHeader("To", n.config.Email.To...)
-	message.SetHeader("Subject", n.config.Email.Subject)
-	message.Attach(n.config.LogPath)
+	message.SetHeader("Subject", subject.String())
+	message.SetBody("text/plain", text.String())
+	message.AddAlternative("text/html", html.String())
 
-	return n.send(message)
+	// attach the captured output of the failed run
+	if _, err := os.Stat(stat.Log); stat.Log != "" && err == nil {
+		message.Attach(stat.Log)
+	}
+
+	return message, nil
+}
+
+// failureEmail builds the template data for a failed stat.
+func (n *EmailNotifier) failureEmail(stat Stat) FailureEmail {
+	data := FailureEmail{
+		Name:     stat.Name,
+		Host:     stat.Host,
+		Start:    stat.Start.Format(n.config.TimeFormat),
+		End:      stat.End.Format(n.config.TimeFormat),
+		Duration: stat.Duration,
+		ExitCode: stat.ExitCode,
+		Stderr:   stat.Stderr,
+	}
+
+	if stat.Error != nil {
+		data.Error = stat.Error.Error()
+	}
+
+	if len(data.Stderr) > n.config.Email.TailLines {
+		data.Stderr = data.Stderr[len(data.Stderr)-n.config.Email.TailLines:]
+	}
+
+	return data
 }
 
+// NotifyHistory sends an email with the backup history.
 func (n *EmailNotifier) NotifyHistory(statMap map[string][]Stat) error {
 	if n.config.Retention < 0 || n.config.Email == nil {
 		return nil
@@ -42,37 +166,78 @@ func (n *EmailNotifier) NotifyHistory(statMap map[string][]Stat) error {
 		return errors.New("Email Notifier: no stats found when trying to send stats email")
 	}
 
+	message, err := n.historyMessage(n.config.Email.HistorySubject, statMap)
+	if err != nil {
+		return permanent(err)
+	}
+
+	return n.send(message)
+}
+
+// NotifyDigest sends an email listing the failures whose notifications were suppressed. The
+// digest is rendered with the history template.
+func (n *EmailNotifier) NotifyDigest(stats []Stat) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	message, err := n.historyMessage(n.config.Email.DigestSubject, groupStats(stats))
+	if err != nil {
+		return permanent(err)
+	}
+
+	return n.send(message)
+}
+
+// historyMessage renders statMap with the default or custom history template.
+func (n *EmailNotifier) historyMessage(subject string, statMap map[string][]Stat) (*gomail.Message, error) {
 	message := gomail.NewMessage()
 	message.SetHeader("From", n.config.Email.From)
 	message.SetHeader("To", n.config.Email.To...)
-	message.SetHeader("Subject", n.config.Email.HistorySubject)
+	message.SetHeader("Subject", subject)
+
+	// stat times are formatted with time_format using {{formatTime .Start}}
+	funcs := textTemplate.FuncMap{
+		"formatTime": func(t time.Time) string {
+			return t.Format(n.config.TimeFormat)
+		},
+	}
 
-	var emailTmpl *template.Template
+	var emailTmpl *textTemplate.Template
 	var err error
 	if n.config.Email.HistoryTemplate != "" {
-		emailTmpl, err = template.ParseFiles(n.config.Email.HistoryTemplate)
+		emailTmpl, err = textTemplate.New(filepath.Base(n.config.Email.HistoryTemplate)).Funcs(funcs).ParseFiles(n.config.Email.HistoryTemplate)
 		if err != nil {
-			return fmt.Errorf("Email Notifier: failed to parse custom email template %s: %w", n.config.Email.HistoryTemplate, err)
+			return nil, fmt.Errorf("Email Notifier: failed to parse custom email template %s: %w", n.config.Email.HistoryTemplate, err)
 		}
 	} else {
-		tmpl := template.New("history")
+		tmpl := textTemplate.New("history").Funcs(funcs)
 		emailTmpl, err = tmpl.Parse(emailTemplate)
 		if err != nil {
-			return fmt.Errorf("Email Notifier: failed to parse email template: %w", err)
+			return nil, fmt.Errorf("Email Notifier: failed to parse email template: %w", err)
 		}
 	}
 
 	var tpl bytes.Buffer
 	if err := emailTmpl.Execute(&tpl, statMap); err != nil {
-		return fmt.Errorf("Email Notifier: failed to execute email template: %w", err)
+		return nil, fmt.Errorf("Email Notifier: failed to execute email template: %w", err)
 	}
 
 	message.SetBody("text/html", tpl.String())
 
-	return n.send(message)
+	return message, nil
 }
 
 func (n *EmailNotifier) send(message *gomail.Message) error {
+	err := n.dialer().DialAndSend(message)
+	if err != nil {
+		return fmt.Errorf("Email Notifer: failed to send email: %w", err)
+	}
+	return nil
+}
+
+// dialer returns a dialer for the configured SMTP server.
+func (n *EmailNotifier) dialer() *gomail.Dialer {
 	dialer := gomail.NewDialer(
 		n.config.Email.Host,
 		n.config.Email.Port,
@@ -88,11 +253,29 @@ func (n *EmailNotifier) send(message *gomail.Message) error {
 	}
 	dialer.SSL = n.config.Email.SSL
 
-	err := dialer.DialAndSend(message)
+	return dialer
+}
+
+// checkTemplates renders the failure and history emails for sample stats so template errors are
+// found before a backup fails.
+func (n *EmailNotifier) checkTemplates() error {
+	stat := NewStat("check").Finish(errors.New("check"))
+
+	if _, err := n.failureMessage(stat); err != nil {
+		return err
+	}
+
+	_, err := n.historyMessage(n.config.Email.HistorySubject, map[string][]Stat{stat.Name: {stat}})
+	return err
+}
+
+// checkSMTP connects and authenticates to the SMTP server without sending an email.
+func (n *EmailNotifier) checkSMTP() error {
+	closer, err := n.dialer().Dial()
 	if err != nil {
-		return fmt.Errorf("Email Notifer: failed to send email: %w", err)
+		return fmt.Errorf("Email Notifier: failed to connect to %s:%d: %w", n.config.Email.Host, n.config.Email.Port, err)
 	}
-	return nil
+	return closer.Close()
 }
 
 var emailTemplate = `<html>
@@ -155,6 +338,16 @@ var emailTemplate = `<html>
   color: #D32F2F !important;
   font-weight: bold;
 }
+
+.skipped {
+  color: #757575 !important;
+  font-weight: bold;
+}
+
+.retried {
+  color: #FB8C00 !important;
+  font-weight: bold;
+}
 </style>
 
 </head>
@@ -178,11 +371,15 @@ var emailTemplate = `<html>
                 <tr>
                         {{if .Success}}
                           <td class="success">Success</td>
+                        {{else if .Skip}}
+                          <td class="skipped">Skipped</td>
+                        {{else if .Retried}}
+                          <td class="retried">Retried</td>
                         {{else}}
                           <td class="failure">Failed</td>
                         {{end}}
-                        <td class="tg-data">{{.Start}}</td>
-                        <td class="tg-data">{{.End}}</td>
+                        <td class="tg-data">{{formatTime .Start}}</td>
+                        <td class="tg-data">{{formatTime .End}}</td>
                         <td class="tg-data">{{.Duration}}</td>
                 </tr>
                 {{ end}}
@@ -193,3 +390,100 @@ var emailTemplate = `<html>
 
 </body>
 </html>`
+
+var failureTextTemplate = `Backup {{.Name}} failed on {{.Host}}
+
+Start:     {{.Start}}
+End:       {{.End}}
+Duration:  {{.Duration}}
+Exit Code: {{.ExitCode}}
+Error:     {{.Error}}
+{{if .Stderr}}
+STDERR:
+{{range .Stderr}}{{.}}
+{{end}}{{end}}`
+
+var failureHTMLTemplate = `<html>
+<head>
+
+<style type="text/css">
+.tg {
+  border-collapse:separate;
+  border-spacing:0;
+  width: 100%;
+  font-family:Roboto,"Helvetica Neue",sans-serif;
+}
+
+.tg td {
+  color:#444;
+  font-size:14px;
+  padding:3px 10px 3px 0px;
+  border-bottom: 1px solid;
+  border-bottom-color: #BDBDBD;
+  height: 40px;
+}
+
+.tg th {
+  background-color:#D32F2F;
+  color:#FFFFFF;
+  font-size:14px;
+  font-weight:bold;
+  text-align:center;
+  height: 60px;
+}
+
+.tg .tg-header {
+  text-align:left;
+  font-weight: bold;
+  color: #9E9E9E;
+  width: 120px;
+}
+
+pre {
+  background-color:#F5F5F5;
+  padding: 10px;
+  font-size: 12px;
+  white-space: pre-wrap;
+}
+</style>
+
+</head>
+<body>
+
+<table class="tg">
+        <thead>
+                <tr>
+                        <th colspan="2">Backup {{.Name}} failed on {{.Host}}</th>
+                </tr>
+        </thead>
+        <tbody>
+                <tr>
+                        <td class="tg-header">Start</td>
+                        <td>{{.Start}}</td>
+                </tr>
+                <tr>
+                        <td class="tg-header">End</td>
+                        <td>{{.End}}</td>
+                </tr>
+                <tr>
+                        <td class="tg-header">Duration</td>
+                        <td>{{.Duration}}</td>
+                </tr>
+                <tr>
+                        <td class="tg-header">Exit Code</td>
+                        <td>{{.ExitCode}}</td>
+                </tr>
+                <tr>
+                        <td class="tg-header">Error</td>
+                        <td>{{.Error}}</td>
+                </tr>
+        </tbody>
+</table>
+{{if .Stderr}}
+<h4>STDERR</h4>
+<pre>{{range .Stderr}}{{.}}
+{{end}}</pre>
+{{end}}
+
+</body>
+</html>`
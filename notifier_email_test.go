@@ -1,7 +1,10 @@
 package repbak
 
 import (
+	"bytes"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -14,13 +17,126 @@ func TestEmailNotifier(t *testing.T) {
 
 	notifier := NewEmailNotifier(config)
 
-	stat := NewStat("TEST", "Mon Jan 02 03:04:05 PM MST").Finish(nil)
+	stat := NewStat("TEST").Finish(nil)
 
+	// successful backups don't send emails
 	err = notifier.Notify(stat)
+	assert.Nil(t, err)
+
+	stat = NewStat("TEST").Finish(errors.New("ERROR"))
+
+	err = notifier.Notify(stat)
+	assert.Error(t, err)
+	assert.False(t, isPermanent(err))
+}
+
+func TestEmailNotifierFailureMessage(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Email.Subject = "[repbak] {{.Name}} failed on {{.Host}}"
+	config.Email.TailLines = 2
+
+	notifier := NewEmailNotifier(config)
+
+	stat := NewStat("TEST")
+	stat.Stderr = []string{"line 1", "line 2", "<line 3>"}
+	stat = stat.Finish(errors.New("exit status 2"))
+
+	message, err := notifier.failureMessage(stat)
+	assert.Nil(t, err)
+
+	host, err := os.Hostname()
+	assert.Nil(t, err)
+	assert.Equal(t, message.GetHeader("Subject"), []string{"[repbak] TEST failed on " + host})
+
+	var buf bytes.Buffer
+	_, err = message.WriteTo(&buf)
+	assert.Nil(t, err)
+
+	email := buf.String()
+	assert.Contains(t, email, "multipart/alternative")
+	assert.Contains(t, email, "text/plain")
+	assert.Contains(t, email, "text/html")
+	assert.Contains(t, email, "exit status 2")
+	assert.Contains(t, email, "line 2")
+	assert.NotContains(t, email, "line 1")
+	assert.Contains(t, email, "&lt;line 3&gt;")
+}
+
+func TestEmailNotifierCustomFailureTemplates(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config.Email.FailureTextTemplate = filepath.Join(dir, "failure.txt")
+	err = os.WriteFile(config.Email.FailureTextTemplate, []byte("custom text {{.Name}} {{.ExitCode}}"), 0600)
+	assert.Nil(t, err)
+
+	config.Email.FailureTemplate = filepath.Join(dir, "failure.html")
+	err = os.WriteFile(config.Email.FailureTemplate, []byte("<p>custom html {{.Error}}</p>"), 0600)
+	assert.Nil(t, err)
+
+	notifier := NewEmailNotifier(config)
+
+	stat := NewStat("TEST").Finish(errors.New("ERROR"))
+
+	message, err := notifier.failureMessage(stat)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	_, err = message.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "custom text TEST -1")
+	assert.Contains(t, buf.String(), "<p>custom html ERROR</p>")
+
+	config.Email.FailureTemplate = filepath.Join(dir, "missing.html")
+	_, err = notifier.failureMessage(stat)
 	assert.Error(t, err)
 
-	stat = stat.Finish(errors.New("ERROR"))
+	// templates that fail aren't retried
+	err = notifier.Notify(stat)
+	assert.True(t, isPermanent(err))
+}
+
+func TestEmailNotifierOnFailureDisabled(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Email.OnFailure = false
+
+	notifier := NewEmailNotifier(config)
+
+	stat := NewStat("TEST").Finish(errors.New("ERROR"))
 
 	err = notifier.Notify(stat)
+	assert.Nil(t, err)
+}
+
+func TestEmailNotifierDigestMessage(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	notifier := NewEmailNotifier(config)
+
+	err = notifier.NotifyDigest([]Stat{})
+	assert.Nil(t, err)
+
+	stats := []Stat{NewStat("TEST").Finish(errors.New("ERROR"))}
+
+	message, err := notifier.historyMessage(config.Email.DigestSubject, groupStats(stats))
+	assert.Nil(t, err)
+	assert.Equal(t, message.GetHeader("Subject"), []string{"Database Backup Notification Digest"})
+
+	var buf bytes.Buffer
+	_, err = message.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "TEST")
+	assert.Contains(t, buf.String(), "Failed")
+
+	err = notifier.NotifyDigest(stats)
 	assert.Error(t, err)
 }
@@ -0,0 +1,72 @@
+package repbak
+
+import "fmt"
+
+// JournaldNotifier writes structured backup events directly to the systemd journal. Fields are
+// prefixed with REPBAK_ such as REPBAK_JOB, REPBAK_SUCCESS, and REPBAK_DURATION.
+type JournaldNotifier struct {
+	config *Config
+	writer *journaldWriter
+}
+
+// NewJournaldNotifier creates a JournaldNotifier using the config
+func NewJournaldNotifier(config *Config) *JournaldNotifier {
+	return &JournaldNotifier{
+		config: config,
+		writer: newJournaldWriter(config.Journald.Identifier),
+	}
+}
+
+// Notify writes an entry for the backup based on the on_failure and on_success options.
+func (n *JournaldNotifier) Notify(stat Stat) error {
+	if stat.Success && !n.config.Journald.OnSuccess || !stat.Success && !n.config.Journald.OnFailure {
+		return nil
+	}
+
+	priority := syslogNotice
+	if !stat.Success {
+		priority = syslogErr
+	}
+
+	return n.writer.write(priority, statMessage(stat), journaldFields(statFields(stat)))
+}
+
+// NotifyHistory writes a summary entry for each backup.
+func (n *JournaldNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	for name, stats := range statMap {
+		fields := historyFields(name, stats)
+		msg := fmt.Sprintf("Backup history for %s: %s runs, %s failed", name, fields["runs"], fields["failures"])
+
+		if err := n.writer.write(syslogInfo, msg, journaldFields(fields)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyDigest writes a summary entry for each backup with suppressed notifications.
+func (n *JournaldNotifier) NotifyDigest(stats []Stat) error {
+	for name, stats := range groupStats(stats) {
+		fields := historyFields(name, stats)
+		msg := fmt.Sprintf("Suppressed %s notifications for %s", fields["runs"], name)
+
+		if err := n.writer.write(syslogWarning, msg, journaldFields(fields)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to the journal.
+func (n *JournaldNotifier) Close() error {
+	return n.writer.Close()
+}
+
+// journaldFields converts structured notifier fields into REPBAK_ prefixed journal fields.
+func journaldFields(fields map[string]string) map[string]string {
+	converted := make(map[string]string, len(fields))
+	for key, value := range fields {
+		converted["REPBAK_"+journaldFieldName(key)] = value
+	}
+	return converted
+}
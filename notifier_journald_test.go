@@ -0,0 +1,52 @@
+package repbak
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournaldNotifier(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Journald = &Journald{
+		OnSuccess: true,
+	}
+	err = config.validate()
+	assert.Nil(t, err)
+
+	notifier := NewJournaldNotifier(config)
+	notifier.writer.socket = socket
+	defer notifier.Close()
+
+	// failures are ignored without on_failure
+	failure := NewStat("TEST").Finish(errors.New("ERROR"))
+	err = notifier.Notify(failure)
+	assert.Nil(t, err)
+
+	success := NewStat("TEST").Finish(nil)
+	err = notifier.Notify(success)
+	assert.Nil(t, err)
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf[:n]), "REPBAK_JOB=TEST\n")
+	assert.Contains(t, string(buf[:n]), "REPBAK_SUCCESS=true\n")
+	assert.Contains(t, string(buf[:n]), "REPBAK_DURATION=")
+	assert.Contains(t, string(buf[:n]), "SYSLOG_IDENTIFIER=repbak\n")
+}
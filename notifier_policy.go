@@ -0,0 +1,120 @@
+package repbak
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PolicyNotifier applies the notification policy in front of another Notifier. Failure
+// notifications that are duplicates, exceed the hourly limit, or happen during quiet hours
+// without being critical are stored in the DB instead of being sent so they can be included in a
+// digest. Successful backups are always passed through and reset the policy state for the backup.
+type PolicyNotifier struct {
+	config   *Config
+	db       DB
+	notifier Notifier
+	mu       sync.Mutex
+	now      func() time.Time
+	sent     []time.Time
+	lastSent map[dedupKey]time.Time
+	failures map[string]int
+}
+
+// dedupKey identifies identical failures.
+type dedupKey struct {
+	name string
+	err  string
+}
+
+// NewPolicyNotifier creates a PolicyNotifier that sends allowed notifications to notifier.
+func NewPolicyNotifier(config *Config, db DB, notifier Notifier) *PolicyNotifier {
+	return &PolicyNotifier{
+		config:   config,
+		db:       db,
+		notifier: notifier,
+		mu:       sync.Mutex{},
+		now:      time.Now,
+		lastSent: make(map[dedupKey]time.Time),
+		failures: make(map[string]int),
+	}
+}
+
+// Notify sends the notification for stat unless the policy suppresses it.
+func (p *PolicyNotifier) Notify(stat Stat) error {
+	if stat.Success {
+		p.reset(stat.Name)
+		return p.notifier.Notify(stat)
+	}
+
+	if reason := p.suppress(stat); reason != "" {
+		log.Infof("Notification Policy: suppressed notification for %s: %s", stat.Name, reason)
+		return p.db.InsertSuppressed(stat)
+	}
+
+	return p.notifier.Notify(stat)
+}
+
+// NotifyHistory sends the history notification without applying the policy.
+func (p *PolicyNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	return p.notifier.NotifyHistory(statMap)
+}
+
+// NotifyDigest sends the digest notification without applying the policy.
+func (p *PolicyNotifier) NotifyDigest(stats []Stat) error {
+	return p.notifier.NotifyDigest(stats)
+}
+
+// suppress returns the reason the failure notification for stat should be suppressed or an
+// empty string if it should be sent.
+func (p *PolicyNotifier) suppress(stat Stat) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	policy := p.config.NotificationPolicy
+	now := p.now()
+
+	p.failures[stat.Name]++
+	critical := p.failures[stat.Name] >= policy.CriticalFailures
+
+	key := dedupKey{name: stat.Name}
+	if stat.Error != nil {
+		key.err = stat.Error.Error()
+	}
+
+	if last, ok := p.lastSent[key]; ok && policy.dedupWindow > 0 && now.Sub(last) < policy.dedupWindow {
+		return "duplicate failure"
+	}
+
+	if policy.QuietHours != nil && policy.QuietHours.contains(now) && !critical {
+		return "quiet hours"
+	}
+
+	// only keep the send times from the last hour
+	for len(p.sent) > 0 && now.Sub(p.sent[0]) >= time.Hour {
+		p.sent = p.sent[1:]
+	}
+
+	if policy.MaxPerHour > 0 && len(p.sent) >= policy.MaxPerHour {
+		return "hourly limit reached"
+	}
+
+	p.sent = append(p.sent, now)
+	p.lastSent[key] = now
+
+	return ""
+}
+
+// reset clears the consecutive failures and sent failures for the backup name.
+func (p *PolicyNotifier) reset(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.failures, name)
+	for key := range p.lastSent {
+		if key.name == name {
+			delete(p.lastSent, key)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package repbak
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPolicyTest(t *testing.T, policy *NotificationPolicy) (*PolicyNotifier, *testNotifier, *BoltDB, func()) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+
+	config := &Config{
+		LibPath:            dir,
+		Retention:          3,
+		NotificationPolicy: policy,
+	}
+	assert.Nil(t, policy.validate())
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+
+	notifier := &testNotifier{}
+
+	return NewPolicyNotifier(config, db, notifier), notifier, db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPolicyNotifierDedup(t *testing.T) {
+	policy, notifier, db, cleanup := newPolicyTest(t, &NotificationPolicy{
+		DedupWindow: "1h",
+	})
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)
+	policy.now = func() time.Time { return now }
+
+	failure := NewStat("TEST").Finish(errors.New("ERROR"))
+
+	assert.Nil(t, policy.Notify(failure))
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 1)
+
+	// a different error isn't a duplicate
+	other := NewStat("TEST").Finish(errors.New("OTHER"))
+	assert.Nil(t, policy.Notify(other))
+	assert.Len(t, notifier.stats, 2)
+
+	// after the window the failure is sent again
+	now = now.Add(time.Hour)
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 3)
+
+	// a success resets the dedup window
+	assert.Nil(t, policy.Notify(NewStat("TEST").Finish(nil)))
+	assert.Len(t, notifier.stats, 4)
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 5)
+
+	suppressed, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 1)
+}
+
+func TestPolicyNotifierMaxPerHour(t *testing.T) {
+	policy, notifier, db, cleanup := newPolicyTest(t, &NotificationPolicy{
+		MaxPerHour: 2,
+	})
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)
+	policy.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		assert.Nil(t, policy.Notify(NewStat("TEST").Finish(errors.New("ERROR"))))
+		now = now.Add(time.Minute)
+	}
+	assert.Len(t, notifier.stats, 2)
+
+	now = now.Add(time.Hour)
+	assert.Nil(t, policy.Notify(NewStat("TEST").Finish(errors.New("ERROR"))))
+	assert.Len(t, notifier.stats, 3)
+
+	suppressed, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, suppressed, 2)
+
+	assert.Nil(t, policy.NotifyDigest(suppressed))
+	assert.Len(t, notifier.digests, 1)
+}
+
+func TestPolicyNotifierQuietHours(t *testing.T) {
+	policy, notifier, _, cleanup := newPolicyTest(t, &NotificationPolicy{
+		QuietHours: &QuietHours{
+			Start: "22:00",
+			End:   "07:00",
+		},
+		CriticalFailures: 2,
+	})
+	defer cleanup()
+
+	now := time.Date(2022, 12, 6, 23, 0, 0, 0, time.Local)
+	policy.now = func() time.Time { return now }
+
+	failure := NewStat("TEST").Finish(errors.New("ERROR"))
+
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 0)
+
+	// the second consecutive failure is critical
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 1)
+
+	// outside quiet hours failures are sent
+	assert.Nil(t, policy.Notify(NewStat("TEST").Finish(nil)))
+	now = time.Date(2022, 12, 7, 7, 0, 0, 0, time.Local)
+	assert.Nil(t, policy.Notify(failure))
+	assert.Len(t, notifier.stats, 3)
+}
+
+func TestQuietHours(t *testing.T) {
+	quiet := &QuietHours{Start: "01:00", End: "05:30"}
+	assert.Nil(t, quiet.validate())
+	assert.True(t, quiet.contains(time.Date(2022, 12, 6, 1, 0, 0, 0, time.Local)))
+	assert.True(t, quiet.contains(time.Date(2022, 12, 6, 5, 29, 0, 0, time.Local)))
+	assert.False(t, quiet.contains(time.Date(2022, 12, 6, 5, 30, 0, 0, time.Local)))
+	assert.False(t, quiet.contains(time.Date(2022, 12, 6, 0, 59, 0, 0, time.Local)))
+
+	quiet = &QuietHours{Start: "22:00", End: "07:00"}
+	assert.Nil(t, quiet.validate())
+	assert.True(t, quiet.contains(time.Date(2022, 12, 6, 23, 0, 0, 0, time.Local)))
+	assert.True(t, quiet.contains(time.Date(2022, 12, 6, 3, 0, 0, 0, time.Local)))
+	assert.False(t, quiet.contains(time.Date(2022, 12, 6, 12, 0, 0, 0, time.Local)))
+
+	quiet = &QuietHours{Start: "25:00", End: "07:00"}
+	assert.Error(t, quiet.validate())
+}
@@ -0,0 +1,62 @@
+package repbak
+
+import "fmt"
+
+// SyslogNotifier writes structured backup events to syslog using RFC 5424.
+type SyslogNotifier struct {
+	config *Config
+	writer *syslogWriter
+}
+
+// NewSyslogNotifier creates a SyslogNotifier using the config
+func NewSyslogNotifier(config *Config) *SyslogNotifier {
+	return &SyslogNotifier{
+		config: config,
+		writer: newSyslogWriter(config.Syslog),
+	}
+}
+
+// Notify writes an event for the backup based on the on_failure and on_success options.
+func (n *SyslogNotifier) Notify(stat Stat) error {
+	if stat.Success && !n.config.Syslog.OnSuccess || !stat.Success && !n.config.Syslog.OnFailure {
+		return nil
+	}
+
+	severity := syslogNotice
+	if !stat.Success {
+		severity = syslogErr
+	}
+
+	return n.writer.write(severity, "backup", statMessage(stat), statFields(stat))
+}
+
+// NotifyHistory writes a summary event for each backup.
+func (n *SyslogNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	for name, stats := range statMap {
+		fields := historyFields(name, stats)
+		msg := fmt.Sprintf("Backup history for %s: %s runs, %s failed", name, fields["runs"], fields["failures"])
+
+		if err := n.writer.write(syslogInfo, "history", msg, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NotifyDigest writes a summary event for each backup with suppressed notifications.
+func (n *SyslogNotifier) NotifyDigest(stats []Stat) error {
+	for name, stats := range groupStats(stats) {
+		fields := historyFields(name, stats)
+		msg := fmt.Sprintf("Suppressed %s notifications for %s", fields["runs"], name)
+
+		if err := n.writer.write(syslogWarning, "digest", msg, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connection to syslog.
+func (n *SyslogNotifier) Close() error {
+	return n.writer.Close()
+}
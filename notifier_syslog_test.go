@@ -0,0 +1,55 @@
+package repbak
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogNotifier(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.Syslog = &Syslog{
+		Network:   "udp",
+		Addr:      conn.LocalAddr().String(),
+		OnFailure: true,
+	}
+	err = config.validate()
+	assert.Nil(t, err)
+
+	notifier := NewSyslogNotifier(config)
+	defer notifier.Close()
+
+	// successful backups are ignored without on_success
+	success := NewStat("TEST").Finish(nil)
+	err = notifier.Notify(success)
+	assert.Nil(t, err)
+
+	failure := NewStat("TEST").Finish(errors.New("ERROR"))
+	err = notifier.Notify(failure)
+	assert.Nil(t, err)
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf[:n]), `error="ERROR"`)
+	assert.Contains(t, string(buf[:n]), `success="false"`)
+
+	err = notifier.NotifyHistory(map[string][]Stat{"TEST": {success, failure}})
+	assert.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = conn.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf[:n]), `failures="1"`)
+	assert.Contains(t, string(buf[:n]), `runs="2"`)
+}
@@ -0,0 +1,87 @@
+package repbak
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testNotifier struct {
+	err     error
+	stats   []Stat
+	history []map[string][]Stat
+	digests [][]Stat
+}
+
+func (n *testNotifier) Notify(stat Stat) error {
+	n.stats = append(n.stats, stat)
+	return n.err
+}
+
+func (n *testNotifier) NotifyHistory(statMap map[string][]Stat) error {
+	n.history = append(n.history, statMap)
+	return n.err
+}
+
+func (n *testNotifier) NotifyDigest(stats []Stat) error {
+	n.digests = append(n.digests, stats)
+	return n.err
+}
+
+func TestMultiNotifier(t *testing.T) {
+	notifier1 := &testNotifier{}
+	notifier2 := &testNotifier{}
+
+	notifier := MultiNotifier{notifier1, notifier2}
+
+	stat := NewStat("TEST").Finish(nil)
+
+	err := notifier.Notify(stat)
+	assert.Nil(t, err)
+	assert.Len(t, notifier1.stats, 1)
+	assert.Len(t, notifier2.stats, 1)
+
+	err = notifier.NotifyHistory(map[string][]Stat{"TEST": {stat}})
+	assert.Nil(t, err)
+	assert.Len(t, notifier1.history, 1)
+	assert.Len(t, notifier2.history, 1)
+
+	err = notifier.NotifyDigest([]Stat{stat})
+	assert.Nil(t, err)
+	assert.Len(t, notifier1.digests, 1)
+	assert.Len(t, notifier2.digests, 1)
+
+	notifier1.err = errors.New("ERROR 1")
+	err = notifier.Notify(stat)
+	assert.EqualError(t, err, "ERROR 1")
+	assert.Len(t, notifier2.stats, 2)
+
+	notifier2.err = errors.New("ERROR 2")
+	err = notifier.Notify(stat)
+	assert.EqualError(t, err, "2 notifiers failed: ERROR 1; ERROR 2")
+
+	err = MultiNotifier{}.Notify(stat)
+	assert.Nil(t, err)
+}
+
+func TestStatFields(t *testing.T) {
+	stat := NewStat("TEST").Finish(errors.New("ERROR"))
+
+	fields := statFields(stat)
+	assert.Equal(t, fields["job"], "TEST")
+	assert.Equal(t, fields["success"], "false")
+	assert.Equal(t, fields["error"], "ERROR")
+	assert.Contains(t, statMessage(stat), "Backup TEST failed")
+
+	statMap := groupStats([]Stat{stat, NewStat("TEST2").Finish(nil), stat})
+	assert.Len(t, statMap, 2)
+	assert.Len(t, statMap["TEST"], 2)
+
+	retried := stat
+	retried.Retried = true
+	fields = historyFields("TEST", []Stat{stat, NewStat("TEST").Finish(nil), retried})
+	assert.Equal(t, fields["runs"], "2")
+	assert.Equal(t, fields["failures"], "1")
+	assert.Equal(t, fields["retries"], "1")
+}
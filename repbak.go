@@ -1,81 +1,369 @@
 package repbak
 
 import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	cron "github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 )
 
+// Errors returned when running or canceling a backup job.
+var (
+	ErrJobRunning    = errors.New("backup job is already running")
+	ErrJobNotRunning = errors.New("backup job isn't running")
+)
+
 // RepBak reforms scheduled database backups.
 type RepBak struct {
 	config   *Config
 	db       DB
 	dumper   Dumper
 	notifier Notifier
+	metrics  *Metrics
 	crontab  *cron.Cron
-	running  bool
-	stopc    chan struct{}
-	donec    chan struct{}
+	entry    cron.EntryID
+	retryc   chan struct{}
+	quitc    chan struct{}
+	deferred bool
+	reserved bool
+
+	maintenance Maintenance
+	mu          sync.RWMutex
+	now         func() time.Time
+	started     time.Time
+	running     bool
+	stopc       chan struct{}
+	donec       chan struct{}
 }
 
 // New returns a new RepBak instance.
 func New(config *Config, db DB, dumper Dumper, notifier Notifier) *RepBak {
+	maintenance, err := LoadMaintenance(config)
+	if err != nil {
+		log.Error(err)
+	}
+
 	return &RepBak{
-		config:   config,
-		db:       db,
-		dumper:   dumper,
-		notifier: notifier,
-		crontab:  cron.New(),
-		stopc:    make(chan struct{}),
-		donec:    make(chan struct{}),
+		maintenance: maintenance,
+		config:      config,
+		db:          db,
+		dumper:      dumper,
+		notifier:    notifier,
+		crontab:     newCrontab(),
+		now:         time.Now,
+		started:     time.Now(),
+		stopc:       make(chan struct{}),
+		donec:       make(chan struct{}),
 	}
 }
 
+// SetMetrics records the runs of each backup in metrics.
+func (r *RepBak) SetMetrics(metrics *Metrics) {
+	r.metrics = metrics
+	r.metrics.AddJob(r.dumper.Name())
+}
+
 // Start runs until stopped and does scheduled database backups.
 func (r *RepBak) Start() error {
 	if r.running {
 		return nil
 	}
 
+	r.mu.Lock()
+	r.crontab = newCrontab()
+	r.quitc = make(chan struct{})
+	config := r.config
+	entry, err := r.schedule(config, r.crontab)
+	r.entry = entry
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
 	r.running = true
 
-	log.Infof("Adding Schedule For mysqldump: %s", r.config.MySQLDump.Schedule)
+	go r.loop()
+
+	if config.MySQLDump.CatchUp {
+		go r.catchUp(config)
+	}
+
+	return nil
+}
+
+// catchUp runs a backup if a scheduled backup was missed since the last successful backup.
+func (r *RepBak) catchUp(config *Config) {
+	name := r.dumper.Name()
+
+	if config.Retention < 0 {
+		log.Warnf("Can't catch up on %s because stats aren't stored", name)
+		return
+	}
+
+	schedule, err := parseSchedule(config.MySQLDump.Schedule, config.MySQLDump.Timezone)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	lastSuccess, err := r.db.LastSuccess(name)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	if !missedRun(schedule, lastSuccess, r.now()) {
+		return
+	}
+
+	if !r.sleep(randomDelay(config.MySQLDump.jitter)) {
+		return
+	}
+
+	log.Infof("Catching up on the missed backup of %s", name)
+	r.scheduledBackup(TriggerCatchUp)
+}
+
+// scheduledBackup runs a scheduled backup unless maintenance mode is enabled, a blackout window is
+// active, or the previous backup is waiting to be retried. Backups that aren't run are stored as skipped
+// with the reason.
+func (r *RepBak) scheduledBackup(trigger string) {
+	name := r.dumper.Name()
+
+	if maintenance := r.Maintenance(); maintenance.Enabled {
+		reason := "maintenance mode"
+		if maintenance.Reason != "" {
+			reason += ": " + maintenance.Reason
+		}
+		r.skip(trigger, reason)
+		return
+	}
+
+	now := r.now()
+	blackout, end := r.currentConfig().blackout(name, now)
+	if blackout != nil {
+		reason := "blackout window until " + end.Format(time.RFC3339)
+		if blackout.Reason != "" {
+			reason += ": " + blackout.Reason
+		}
+
+		if blackout.Action == BlackoutSkip {
+			r.skip(trigger, reason)
+			return
+		}
+
+		// only one backup is deferred to the end of the window
+		r.mu.Lock()
+		deferred := r.deferred
+		r.deferred = true
+		r.mu.Unlock()
+		if deferred {
+			r.skip(trigger, reason+" with a backup already deferred")
+			return
+		}
+
+		log.Infof("Deferring the backup of %s until the end of the %s", name, reason)
+		ok := r.sleep(end.Sub(now))
+
+		r.mu.Lock()
+		r.deferred = false
+		r.mu.Unlock()
+		if !ok {
+			return
+		}
+
+		// maintenance mode or another window may have started
+		r.scheduledBackup(trigger)
+		return
+	}
+
+	// the pending retry is the scheduled backup that's still running
+	if r.retryPending() {
+		r.skip(trigger, "a retry of the previous backup is pending")
+		return
+	}
+
+	if !r.reserve() {
+		r.skip(trigger, "the previous backup is still running")
+		return
+	}
+	defer r.release()
+
+	if stat := r.backup(trigger); stat.Error != nil {
+		log.Errorf("Backup failed: %v", stat.Error)
+	}
+}
+
+// skip stores a skipped backup with reason.
+func (r *RepBak) skip(trigger, reason string) {
+	log.Warnf("Skipping the backup of %s: %s", r.dumper.Name(), reason)
+
+	stat := NewStat(r.dumper.Name())
+	stat.Trigger = trigger
+	stat.Skip = true
+	stat.SkipReason = reason
+	stat.End = stat.Start
+
+	r.metrics.Skipped(stat.Name)
+	r.store(stat)
+}
+
+// sleep waits d and returns false if repbak was stopped first.
+func (r *RepBak) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	r.mu.RLock()
+	quitc := r.quitc
+	r.mu.RUnlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-quitc:
+		return false
+	}
+}
+
+// schedule adds the backups, emails, digests, and pruning scheduled in config to crontab. The entry
+// of the backup is returned.
+func (r *RepBak) schedule(config *Config, crontab *cron.Cron) (cron.EntryID, error) {
+	spec := scheduleSpec(config.MySQLDump.Schedule, config.MySQLDump.Timezone)
+	jitter := config.MySQLDump.jitter
+
+	log.Infof("Adding Schedule For mysqldump: %s", spec)
 	// When more dumpers are added this can be generalized
-	_, err := r.crontab.AddFunc(r.config.MySQLDump.Schedule, func() {
+	entry, err := crontab.AddFunc(spec, func() {
+		// spread the start of backups scheduled at the same time on many hosts
+		if !r.sleep(randomDelay(jitter)) {
+			return
+		}
+
 		log.Info("Dumping MySQL database")
 
-		if err := r.backup(); err != nil {
-			log.Errorf("Backup failed: %v", err)
-		}
+		r.scheduledBackup(TriggerSchedule)
 	})
 	if err != nil {
-		return err
+		return entry, err
 	}
 
 	// setup scheduled stats email
-	if r.config.Email != nil && r.config.Email.HistorySchedule != "" {
-		_, err := r.crontab.AddFunc(r.config.Email.HistorySchedule, func() {
+	if config.Email != nil && config.Email.HistorySchedule != "" {
+		_, err := crontab.AddFunc(config.Email.HistorySchedule, func() {
 			statMap, err := r.db.List()
 			if err != nil {
 				log.Error(err)
 				return
 			}
 
-			if err := r.notifier.NotifyHistory(statMap); err != nil {
+			if err := r.currentNotifier().NotifyHistory(statMap); err != nil {
 				log.Error(err)
 			}
 		})
 		if err != nil {
-			return err
+			return entry, err
 		}
 
-		log.Infof("History Email Scheduled: %s", r.config.Email.HistorySchedule)
+		log.Infof("History Email Scheduled: %s", config.Email.HistorySchedule)
 	}
 
-	go r.loop()
+	// setup scheduled digest of suppressed notifications
+	if config.NotificationPolicy != nil && config.NotificationPolicy.DigestSchedule != "" {
+		_, err := crontab.AddFunc(config.NotificationPolicy.DigestSchedule, func() {
+			if err := r.digest(); err != nil {
+				log.Error(err)
+			}
+		})
+		if err != nil {
+			return entry, err
+		}
+
+		log.Infof("Notification Digest Scheduled: %s", config.NotificationPolicy.DigestSchedule)
+	}
+
+	// setup scheduled pruning of old stats so max age is enforced between backups
+	if config.Retention > -1 {
+		_, err := crontab.AddFunc(config.PruneSchedule, func() {
+			if err := r.db.Prune(); err != nil {
+				log.Error(err)
+			}
+		})
+		if err != nil {
+			return entry, err
+		}
+
+		log.Infof("Stats Pruning Scheduled: %s", config.PruneSchedule)
+	}
+
+	return entry, nil
+}
+
+// Reload replaces the config and notifier. The schedules are replaced atomically and a running
+// backup isn't interrupted. If config is invalid or changes settings that require a restart then an
+// error is returned and the current config keeps running.
+func (r *RepBak) Reload(config *Config, notifier Notifier) error {
+	current := r.currentConfig()
+	if (current.Retention < 0) != (config.Retention < 0) {
+		return errors.New("Reload: enabling or disabling stats with retention requires a restart")
+	}
+	if current.LibPath != config.LibPath {
+		return errors.New("Reload: changing lib_path requires a restart")
+	}
+	if *current.Database != *config.Database {
+		return errors.New("Reload: changing the database requires a restart")
+	}
+
+	crontab := newCrontab()
+	entry, err := r.schedule(config, crontab)
+	if err != nil {
+		return fmt.Errorf("Reload: %w", err)
+	}
+
+	r.mu.Lock()
+	old := r.crontab
+	r.config = config
+	r.notifier = notifier
+	r.crontab = crontab
+	r.entry = entry
+	if r.running {
+		crontab.Start()
+		old.Stop()
+	}
+	r.mu.Unlock()
+
+	r.db.SetConfig(config)
+	if dumper, ok := r.dumper.(interface{ SetConfig(*Config) }); ok {
+		dumper.SetConfig(config)
+	}
+
+	log.Info("RepBak reloaded")
 
 	return nil
 }
 
+// currentConfig returns the config in use.
+func (r *RepBak) currentConfig() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.config
+}
+
+// currentNotifier returns the notifier in use.
+func (r *RepBak) currentNotifier() Notifier {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.notifier
+}
+
 // Stop stops repmon from running for schedule database backups.
 func (r *RepBak) Stop() {
 	if !r.running {
@@ -88,36 +376,281 @@ func (r *RepBak) Stop() {
 }
 
 func (r *RepBak) loop() {
+	r.mu.RLock()
 	r.crontab.Start()
+	r.mu.RUnlock()
 
 	log.Infof("RepBak started")
 
 	<-r.stopc
 
+	r.mu.RLock()
 	r.crontab.Stop()
+	close(r.quitc)
+	r.mu.RUnlock()
+	r.cancelRetry()
 	r.dumper.Stop()
 	r.donec <- struct{}{}
 	log.Info("RepBak shutdown")
 }
 
-func (r *RepBak) backup() error {
-	// Create a new dump
-	stat := r.dumper.Dump()
-	if stat.Skip {
+// Job describes a scheduled backup job.
+type Job struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+
+	// Timezone is the timezone the schedule is evaluated in. It's empty for the local time.
+	Timezone string `json:"timezone,omitempty"`
+
+	// NextRun is the next scheduled run. It's zero if the schedule is invalid.
+	NextRun time.Time `json:"next_run"`
+
+	// RunningSince is when the running backup started if one is running.
+	RunningSince *time.Time `json:"running_since,omitempty"`
+}
+
+// Jobs returns the scheduled backup jobs.
+func (r *RepBak) Jobs() []Job {
+	r.mu.RLock()
+	job := Job{
+		Name:     r.dumper.Name(),
+		Schedule: r.config.MySQLDump.Schedule,
+		Timezone: r.config.MySQLDump.Timezone,
+		NextRun:  r.crontab.Entry(r.entry).Next,
+	}
+	r.mu.RUnlock()
+
+	// the next run is calculated from the schedule when repbak isn't started
+	if job.NextRun.IsZero() {
+		if schedule, err := parseSchedule(job.Schedule, job.Timezone); err == nil {
+			job.NextRun = schedule.Next(r.now())
+		}
+	}
+
+	if since := r.dumper.RunningSince(); !since.IsZero() {
+		job.RunningSince = &since
+	}
+
+	return []Job{job}
+}
+
+// Job returns the backup job name.
+func (r *RepBak) Job(name string) (Job, error) {
+	for _, job := range r.Jobs() {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return Job{}, fmt.Errorf("%w: %s", ErrUnknownJob, name)
+}
+
+// Run starts a backup of the job name in the background. Trigger is recorded as what started it.
+func (r *RepBak) Run(name, trigger string) error {
+	if _, err := r.Job(name); err != nil {
+		return err
+	}
+
+	// the backup is reserved before returning so concurrent runs are refused
+	if !r.reserve() {
+		return fmt.Errorf("%w: %s", ErrJobRunning, name)
+	}
+
+	log.Infof("Running %s triggered by %s", name, trigger)
+
+	go func() {
+		defer r.release()
+
+		if stat := r.backup(trigger); stat.Error != nil {
+			log.Errorf("Backup failed: %v", stat.Error)
+		}
+	}()
+
+	return nil
+}
+
+// Cancel stops the running backup of the job name or its pending retry.
+func (r *RepBak) Cancel(name string) error {
+	if _, err := r.Job(name); err != nil {
+		return err
+	}
+
+	if r.cancelRetry() {
+		log.Warnf("Canceled the retry of %s", name)
 		return nil
 	}
 
-	if !stat.Success && r.config.Email != nil && r.config.Email.OnFailure {
-		if err := r.notifier.Notify(stat); err != nil {
-			log.Error(err)
+	if r.dumper.RunningSince().IsZero() {
+		return fmt.Errorf("%w: %s", ErrJobNotRunning, name)
+	}
+
+	log.Warnf("Canceling %s", name)
+	r.dumper.Stop()
+
+	return nil
+}
+
+// Backup runs a backup of the job name in the foreground and returns its stat. A failed backup is
+// reported by the stat's Error rather than the returned error, which is only set if the backup
+// couldn't be started.
+func (r *RepBak) Backup(name, trigger string) (Stat, error) {
+	if _, err := r.Job(name); err != nil {
+		return Stat{}, err
+	}
+
+	if !r.reserve() {
+		return Stat{}, fmt.Errorf("%w: %s", ErrJobRunning, name)
+	}
+	defer r.release()
+
+	return r.backup(trigger), nil
+}
+
+// backup runs a backup, notifies about it, and stores its stat. Failed backups are retried by the
+// job's retry policy and only the final attempt is notified about. The stat of every attempt is stored.
+func (r *RepBak) backup(trigger string) Stat {
+	name := r.dumper.Name()
+	retry := r.currentConfig().retryPolicy(name)
+
+	for attempt := 1; ; attempt++ {
+		// Create a new dump
+		r.metrics.Started(name)
+		stat := r.dumper.Dump(trigger)
+		stat.Attempt = attempt
+		if stat.Skip {
+			r.metrics.Finished(stat)
+			r.store(stat)
+			return stat
+		}
+
+		if !retry.retryable(stat, attempt) {
+			r.metrics.Finished(stat)
+			r.finish(stat)
+			return stat
+		}
+
+		delay := retry.delay(attempt)
+		log.Warnf("Backup %s attempt %d failed, retrying in %s: %v", name, attempt, delay, stat.Error)
+
+		stat.Retried = true
+		r.metrics.Finished(stat)
+		r.store(stat)
+
+		if !r.waitRetry(delay) {
+			log.Warnf("Retry of %s canceled", name)
+
+			// the attempt is stored again as the final attempt
+			stat.Retried = false
+			r.metrics.RetryCanceled(stat)
+			r.finish(stat)
+			return stat
 		}
 	}
+}
+
+// finish notifies about the final attempt of a backup and stores its stat.
+func (r *RepBak) finish(stat Stat) {
+	if err := r.currentNotifier().Notify(stat); err != nil {
+		log.Error(err)
+	}
 
-	if r.config.Retention > -1 {
+	r.store(stat)
+}
+
+// store stores stat if stats are retained.
+func (r *RepBak) store(stat Stat) {
+	if r.currentConfig().Retention > -1 {
 		if err := r.db.Insert(stat); err != nil {
 			log.Errorf("Failed to write stats for %s: %v", stat.Name, err)
 		}
 	}
+}
+
+// waitRetry waits delay before retrying a failed backup. False is returned if the retry was canceled
+// or another retry is already pending.
+func (r *RepBak) waitRetry(delay time.Duration) bool {
+	retryc := make(chan struct{})
+
+	r.mu.Lock()
+	if r.retryc != nil {
+		r.mu.Unlock()
+		return false
+	}
+	r.retryc = retryc
+	r.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		r.mu.Lock()
+		if r.retryc == retryc {
+			r.retryc = nil
+		}
+		r.mu.Unlock()
+		return true
+	case <-retryc:
+		return false
+	}
+}
+
+// reserve marks a backup as running so only one runs at a time. False is returned if a backup is
+// already running, including one waiting to be retried.
+func (r *RepBak) reserve() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reserved || r.retryc != nil || !r.dumper.RunningSince().IsZero() {
+		return false
+	}
+	r.reserved = true
+	return true
+}
+
+// release ends the reservation of a backup.
+func (r *RepBak) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reserved = false
+}
+
+// retryPending returns true while a failed backup waits to be retried.
+func (r *RepBak) retryPending() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.retryc != nil
+}
+
+// cancelRetry cancels the pending retry of a failed backup. False is returned if no retry is pending.
+func (r *RepBak) cancelRetry() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.retryc == nil {
+		return false
+	}
+
+	close(r.retryc)
+	r.retryc = nil
+	return true
+}
+
+// digest sends a digest of the suppressed notifications and removes them once sent.
+func (r *RepBak) digest() error {
+	stats, err := r.db.ListSuppressed()
+	if err != nil {
+		return err
+	}
+
+	if len(stats) == 0 {
+		return nil
+	}
+
+	if err := r.currentNotifier().NotifyDigest(stats); err != nil {
+		return err
+	}
 
-	return stat.Error
+	return r.db.PruneSuppressed(len(stats))
 }
@@ -1,7 +1,11 @@
 package repbak
 
 import (
+	"errors"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
@@ -25,3 +29,429 @@ func TestRepBak(t *testing.T) {
 	rm.Stop()
 	rm.Stop()
 }
+
+func TestRepBakDigest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	notifier := &testNotifier{}
+
+	rb := New(config, db, NewMySQLDumpDumper(config), notifier)
+
+	// nothing is sent without suppressed notifications
+	err = rb.digest()
+	assert.Nil(t, err)
+	assert.Len(t, notifier.digests, 0)
+
+	err = db.InsertSuppressed(NewStat("TEST").Finish(errors.New("ERROR")))
+	assert.Nil(t, err)
+
+	err = rb.digest()
+	assert.Nil(t, err)
+	assert.Len(t, notifier.digests, 1)
+	assert.Len(t, notifier.digests[0], 1)
+
+	stats, err := db.ListSuppressed()
+	assert.Nil(t, err)
+	assert.Len(t, stats, 0)
+}
+
+func TestRepBakReload(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	rb := New(config, db, NewMySQLDumpDumper(config), &testNotifier{})
+	assert.Nil(t, rb.Start())
+	defer rb.Stop()
+
+	newConfig := func() *Config {
+		config, err := OpenConfig("./testdata/repbak.yaml")
+		assert.Nil(t, err)
+		config.LibPath = dir
+		return config
+	}
+
+	// the schedule and notifier are replaced
+	reloaded := newConfig()
+	reloaded.MySQLDump.Schedule = "0 3 1 1 *"
+	notifier := &testNotifier{}
+	assert.Nil(t, rb.Reload(reloaded, notifier))
+	assert.Equal(t, rb.Jobs()[0].Schedule, "0 3 1 1 *")
+	assert.Equal(t, rb.Jobs()[0].NextRun.Month(), time.January)
+	assert.Equal(t, rb.currentNotifier(), Notifier(notifier))
+
+	// invalid schedules are rejected and the current config keeps running
+	invalid := newConfig()
+	invalid.MySQLDump.Schedule = "invalid"
+	assert.Error(t, rb.Reload(invalid, &testNotifier{}))
+	assert.Equal(t, rb.currentConfig(), reloaded)
+	assert.Equal(t, rb.Jobs()[0].Schedule, "0 3 1 1 *")
+
+	// settings that require a restart are rejected
+	disabled := newConfig()
+	disabled.Retention = -1
+	assert.Error(t, rb.Reload(disabled, &testNotifier{}))
+
+	moved := newConfig()
+	moved.LibPath = os.TempDir()
+	assert.Error(t, rb.Reload(moved, &testNotifier{}))
+	assert.Equal(t, rb.currentConfig(), reloaded)
+}
+
+func TestRepBakRetry(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+	config.Retention = 100
+	config.MySQLDump.Retry = &Retry{InitialDelay: "1ms"}
+	assert.Nil(t, config.validate())
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	start := time.Now()
+	result := func(i int, err error, stderr ...string) Stat {
+		stat := NewStat("mysqldump")
+		stat.Start = start.Add(time.Duration(i) * time.Second)
+		stat = stat.Finish(err)
+		stat.Stderr = stderr
+		return stat
+	}
+	connectErr := errors.New("exit status 2")
+
+	// connect failures are retried and only the final attempt is notified about
+	notifier := &testNotifier{}
+	dumper := &testDumper{results: []Stat{
+		result(0, connectErr, "mysqldump: Got error: 2003: Can't connect to MySQL server on '127.0.0.1'"),
+		result(1, connectErr, "mysqldump: Got error: 2013: Lost connection to MySQL server"),
+		result(2, nil),
+	}}
+	metrics, err := NewMetrics(db)
+	assert.Nil(t, err)
+	rb := New(config, db, dumper, notifier)
+	rb.SetMetrics(metrics)
+
+	stat := rb.backup(TriggerManual)
+	assert.True(t, stat.Success)
+	assert.Equal(t, stat.Attempt, 3)
+	assert.Len(t, dumper.triggers(), 3)
+	assert.Len(t, notifier.stats, 1)
+	assert.True(t, notifier.stats[0].Success)
+
+	stats, err := db.Page("mysqldump", time.Time{}, 10)
+	assert.Nil(t, err)
+	assert.Len(t, stats, 3)
+	assert.False(t, stats[0].Retried)
+	assert.True(t, stats[1].Retried)
+	assert.Equal(t, stats[1].Attempt, 2)
+	assert.True(t, stats[2].Retried)
+
+	// retried attempts aren't failures
+	failure, err := db.LastFailure("mysqldump")
+	assert.Nil(t, err)
+	assert.Nil(t, failure)
+
+	aggregate, err := db.Aggregate("mysqldump", time.Time{})
+	assert.Nil(t, err)
+	assert.Equal(t, aggregate.Runs, 1)
+	assert.Equal(t, aggregate.Retries, 2)
+	assert.Equal(t, aggregate.SuccessRate, float64(1))
+
+	var b strings.Builder
+	_, err = metrics.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `repbak_runs_total{backup="mysqldump"} 1`)
+	assert.Contains(t, b.String(), `repbak_failures_total{backup="mysqldump"} 0`)
+	assert.Contains(t, b.String(), `repbak_retries_total{backup="mysqldump"} 2`)
+	assert.Contains(t, b.String(), `repbak_running{backup="mysqldump"} 0`)
+
+	// other failures aren't retried
+	notifier = &testNotifier{}
+	dumper = &testDumper{results: []Stat{result(3, errors.New("exit status 2"), "mysqldump: Couldn't find table")}}
+	rb = New(config, db, dumper, notifier)
+
+	stat = rb.backup(TriggerManual)
+	assert.False(t, stat.Success)
+	assert.Equal(t, stat.Attempt, 1)
+	assert.Len(t, notifier.stats, 1)
+
+	// the final failure is notified about after max_attempts
+	notifier = &testNotifier{}
+	dumper = &testDumper{results: []Stat{
+		result(4, connectErr, "Can't connect"),
+		result(5, connectErr, "Can't connect"),
+		result(6, connectErr, "Can't connect"),
+		result(7, nil),
+	}}
+	rb = New(config, db, dumper, notifier)
+
+	stat = rb.backup(TriggerManual)
+	assert.False(t, stat.Success)
+	assert.Equal(t, stat.Attempt, 3)
+	assert.Len(t, notifier.stats, 1)
+	assert.False(t, notifier.stats[0].Retried)
+
+	health, err := rb.JobHealth("mysqldump")
+	assert.Nil(t, err)
+	assert.Equal(t, health.State, HealthFailed)
+
+	// canceling a pending retry ends the backup with the failed attempt
+	config.MySQLDump.Retry = &Retry{InitialDelay: "1h"}
+	assert.Nil(t, config.validate())
+
+	notifier = &testNotifier{}
+	dumper = &testDumper{results: []Stat{result(8, connectErr, "Can't connect")}}
+	metrics, err = NewMetrics(db)
+	assert.Nil(t, err)
+	rb = New(config, db, dumper, notifier)
+	rb.SetMetrics(metrics)
+
+	assert.ErrorIs(t, rb.Cancel("mysqldump"), ErrJobNotRunning)
+
+	done := make(chan Stat)
+	go func() { done <- rb.backup(TriggerManual) }()
+
+	assert.Eventually(t, rb.retryPending, time.Second, time.Millisecond)
+
+	// nothing else runs while the retry is pending
+	rb.scheduledBackup(TriggerSchedule)
+	assert.Len(t, dumper.triggers(), 1)
+	assert.ErrorIs(t, rb.Run("mysqldump", TriggerAPI), ErrJobRunning)
+	assert.False(t, rb.waitRetry(time.Hour))
+
+	stats, err = db.Page("mysqldump", time.Time{}, 20)
+	assert.Nil(t, err)
+	skips := 0
+	for _, stat := range stats {
+		if stat.Skip {
+			skips++
+			assert.Contains(t, stat.SkipReason, "retry")
+		}
+	}
+	assert.Equal(t, skips, 1)
+
+	assert.Nil(t, rb.Cancel("mysqldump"))
+
+	stat = <-done
+	assert.False(t, stat.Success)
+	assert.False(t, stat.Retried)
+	assert.Len(t, notifier.stats, 1)
+
+	b.Reset()
+	_, err = metrics.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Contains(t, b.String(), `repbak_failures_total{backup="mysqldump"} 1`)
+	assert.Contains(t, b.String(), `repbak_retries_total{backup="mysqldump"} 0`)
+}
+
+func TestRepBakRun(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	dumper := &testDumper{stat: NewStat("mysqldump").Finish(nil), block: make(chan struct{})}
+	rb := New(config, db, dumper, &testNotifier{})
+
+	// the run is reserved before the dumper starts so a second run is refused
+	assert.Nil(t, rb.Run("mysqldump", TriggerAPI))
+	assert.ErrorIs(t, rb.Run("mysqldump", TriggerAPI), ErrJobRunning)
+	_, err = rb.Backup("mysqldump", TriggerManual)
+	assert.ErrorIs(t, err, ErrJobRunning)
+
+	// scheduled backups are skipped
+	rb.scheduledBackup(TriggerSchedule)
+	stats, err := db.Page("mysqldump", time.Time{}, 1)
+	assert.Nil(t, err)
+	assert.Len(t, stats, 1)
+	assert.True(t, stats[0].Skip)
+	assert.Equal(t, stats[0].SkipReason, "the previous backup is still running")
+
+	close(dumper.block)
+	assert.Eventually(t, func() bool {
+		return rb.Run("mysqldump", TriggerAPI) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return len(dumper.triggers()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, dumper.triggers(), []string{TriggerAPI, TriggerAPI})
+}
+
+func TestRepBakCatchUp(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+	config.MySQLDump.Timezone = "UTC"
+	config.MySQLDump.CatchUp = true
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	// a backup is run at startup without a successful backup
+	dumper := &testDumper{stat: NewStat("mysqldump").Finish(nil)}
+	rb := New(config, db, dumper, &testNotifier{})
+	assert.Nil(t, rb.Start())
+	assert.Eventually(t, func() bool {
+		return len(dumper.triggers()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, dumper.triggers(), []string{TriggerCatchUp})
+	rb.Stop()
+
+	jobs := rb.Jobs()
+	assert.Equal(t, jobs[0].Timezone, "UTC")
+	assert.Equal(t, jobs[0].NextRun.UTC().Hour(), 0)
+
+	// nothing is run if the last successful backup is after the last scheduled run
+	assert.Eventually(t, func() bool {
+		stat, err := db.LastSuccess("mysqldump")
+		return err == nil && stat != nil
+	}, time.Second, 10*time.Millisecond)
+
+	dumper = &testDumper{stat: NewStat("mysqldump").Finish(nil)}
+	rb = New(config, db, dumper, &testNotifier{})
+	assert.Nil(t, rb.Start())
+	time.Sleep(50 * time.Millisecond)
+	rb.Stop()
+	assert.Len(t, dumper.triggers(), 0)
+}
+
+func TestRepBakBlackout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	config.MySQLDump.Blackouts = []Blackout{{Start: now.Add(-time.Hour), End: now.Add(time.Hour), Reason: "migration"}}
+	assert.Nil(t, config.validate())
+
+	// skipped backups are stored without running the dumper
+	dumper := &testDumper{stat: NewStat("mysqldump").Finish(nil)}
+	rb := New(config, db, dumper, &testNotifier{})
+	rb.scheduledBackup(TriggerSchedule)
+	assert.Len(t, dumper.triggers(), 0)
+
+	stats, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, stats["mysqldump"], 1)
+	assert.True(t, stats["mysqldump"][0].Skip)
+	assert.Contains(t, stats["mysqldump"][0].SkipReason, "migration")
+
+	// a deferred backup runs at the end of the window and later backups in the window are skipped
+	config.MySQLDump.Blackouts = []Blackout{{Start: now.Add(-time.Hour), End: time.Now().Add(200 * time.Millisecond), Action: BlackoutDefer}}
+	assert.Nil(t, config.validate())
+
+	done := make(chan struct{})
+	go func() {
+		rb.scheduledBackup(TriggerSchedule)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		rb.mu.RLock()
+		defer rb.mu.RUnlock()
+		return rb.deferred
+	}, time.Second, 10*time.Millisecond)
+
+	rb.scheduledBackup(TriggerSchedule)
+	assert.Len(t, dumper.triggers(), 0)
+
+	<-done
+	assert.Equal(t, dumper.triggers(), []string{TriggerSchedule})
+
+	stats, err = db.List()
+	assert.Nil(t, err)
+	assert.Len(t, stats["mysqldump"], 3)
+}
+
+func TestRepBakMaintenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	dumper := &testDumper{stat: NewStat("mysqldump").Finish(nil)}
+	rb := New(config, db, dumper, &testNotifier{})
+
+	maintenance, err := rb.SetMaintenance(true, "upgrade")
+	assert.Nil(t, err)
+	assert.True(t, maintenance.Enabled)
+	assert.NotNil(t, maintenance.Since)
+
+	rb.scheduledBackup(TriggerSchedule)
+	assert.Len(t, dumper.triggers(), 0)
+
+	// skipped backups aren't failures
+	stat, err := db.LastFailure("mysqldump")
+	assert.Nil(t, err)
+	assert.Nil(t, stat)
+
+	stats, err := db.List()
+	assert.Nil(t, err)
+	assert.Len(t, stats["mysqldump"], 1)
+	assert.Equal(t, stats["mysqldump"][0].SkipReason, "maintenance mode: upgrade")
+
+	// manual backups still run
+	_, err = rb.Backup("mysqldump", TriggerManual)
+	assert.Nil(t, err)
+	assert.Equal(t, dumper.triggers(), []string{TriggerManual})
+
+	// maintenance mode survives a restart
+	rb = New(config, db, dumper, &testNotifier{})
+	assert.True(t, rb.Maintenance().Enabled)
+	assert.Equal(t, rb.Maintenance().Reason, "upgrade")
+
+	_, err = rb.SetMaintenance(false, "")
+	assert.Nil(t, err)
+	rb.scheduledBackup(TriggerSchedule)
+	assert.Equal(t, dumper.triggers(), []string{TriggerManual, TriggerSchedule})
+}
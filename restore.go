@@ -0,0 +1,137 @@
+package repbak
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBackupNotFound is returned when no backup matches the requested ID.
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Restorer is implemented by dumpers whose backups can be restored.
+type Restorer interface {
+	// Backups returns the stored backups sorted newest first. Stats are the recorded runs of the dumper
+	// and are used to find the run and checksum of each backup.
+	Backups(stats []Stat) ([]Backup, error)
+
+	// Restore streams the uncompressed backup read from r into the database at dsn. The output of the
+	// client is written to out.
+	Restore(ctx context.Context, r io.Reader, dsn string, out io.Writer) error
+}
+
+// Backup is a stored backup artifact.
+type Backup struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	Path  string    `json:"path"`
+	Size  int64     `json:"size"`
+
+	// RunID is the run that created the backup. It's empty if the run's stat was pruned.
+	RunID string `json:"run_id,omitempty"`
+
+	// Checksum is the hex encoded SHA-256 of the artifact. It's empty if the run's stat was pruned.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// FindBackup returns the backup matching id from backups sorted newest first. The id is latest or
+// empty for the newest backup, a run ID, an artifact file name, or a timestamp for the newest backup
+// started at or before it. Timestamps are RFC 3339 or formatted like the artifact names.
+func FindBackup(backups []Backup, id string) (Backup, error) {
+	if len(backups) == 0 {
+		return Backup{}, ErrBackupNotFound
+	}
+
+	if id == "" || id == "latest" {
+		return backups[0], nil
+	}
+
+	for _, backup := range backups {
+		if backup.RunID == id || filepath.Base(backup.Path) == id {
+			return backup, nil
+		}
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, id)
+	if err != nil {
+		if t, err = time.Parse(artifactTimeFormat, id); err != nil {
+			return Backup{}, fmt.Errorf("%w: %s", ErrBackupNotFound, id)
+		}
+	}
+
+	// artifact names only have millisecond precision
+	for _, backup := range backups {
+		if !backup.Start.Truncate(time.Millisecond).After(t) {
+			return backup, nil
+		}
+	}
+
+	return Backup{}, fmt.Errorf("%w: no backup started at or before %s", ErrBackupNotFound, id)
+}
+
+// VerifyBackup checks the artifact of backup against its recorded checksum.
+func VerifyBackup(backup Backup) error {
+	if backup.Checksum == "" {
+		return fmt.Errorf("Restore: no checksum is recorded for %s", backup.Path)
+	}
+
+	f, err := os.Open(backup.Path)
+	if err != nil {
+		return fmt.Errorf("Restore: failed to open backup %s: %w", backup.Path, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("Restore: failed to read backup %s: %w", backup.Path, err)
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != backup.Checksum {
+		return fmt.Errorf("Restore: checksum mismatch for %s: expected %s got %s", backup.Path, backup.Checksum, sum)
+	}
+
+	return nil
+}
+
+// OpenBackup opens the artifact of backup and decompresses it if needed.
+func OpenBackup(backup Backup) (io.ReadCloser, error) {
+	f, err := os.Open(backup.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Restore: failed to open backup %s: %w", backup.Path, err)
+	}
+
+	if !strings.HasSuffix(backup.Path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Restore: failed to decompress backup %s: %w", backup.Path, err)
+	}
+
+	return &gzipFile{Reader: gz, file: f}, nil
+}
+
+// gzipFile closes both the gzip reader and the underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+// Close closes the gzip reader and the file.
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
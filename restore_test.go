@@ -0,0 +1,127 @@
+package repbak
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindBackup(t *testing.T) {
+	now := time.Now().UTC()
+	backups := []Backup{
+		{RunID: "new", Start: now, Path: "/backups/mysql-new.dump"},
+		{RunID: "old", Start: now.Add(-24 * time.Hour), Path: "/backups/mysql-old.dump"},
+	}
+
+	_, err := FindBackup(nil, "latest")
+	assert.ErrorIs(t, err, ErrBackupNotFound)
+
+	backup, err := FindBackup(backups, "")
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "new")
+
+	backup, err = FindBackup(backups, "latest")
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "new")
+
+	backup, err = FindBackup(backups, "old")
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "old")
+
+	backup, err = FindBackup(backups, "mysql-old.dump")
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "old")
+
+	backup, err = FindBackup(backups, now.Add(-time.Hour).Format(time.RFC3339))
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "old")
+
+	backup, err = FindBackup(backups, now.Format(artifactTimeFormat))
+	assert.Nil(t, err)
+	assert.Equal(t, backup.RunID, "new")
+
+	_, err = FindBackup(backups, now.Add(-48*time.Hour).Format(time.RFC3339))
+	assert.ErrorIs(t, err, ErrBackupNotFound)
+
+	_, err = FindBackup(backups, "missing")
+	assert.ErrorIs(t, err, ErrBackupNotFound)
+}
+
+func TestRestoreBackups(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:    dir,
+		Retention:  -1,
+		TimeFormat: time.RFC3339,
+		MySQLDump: &MySQLDump{
+			Retention:             2,
+			OutputPath:            filepath.Join(dir, "mysql.dump"),
+			ExecutablePath:        "echo",
+			ExecutableArgs:        "backup",
+			RestoreExecutablePath: "echo",
+			Compress:              true,
+		},
+	}
+
+	dumper := NewMySQLDumpDumper(config)
+	stat := dumper.Dump(TriggerManual)
+	assert.True(t, stat.Success)
+
+	// artifacts without a stat have no checksum
+	backups, err := dumper.Backups(nil)
+	assert.Nil(t, err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, backups[0].Path, stat.Artifacts[0])
+	assert.Equal(t, backups[0].Checksum, "")
+	assert.True(t, backups[0].Start.Equal(stat.Start.Truncate(time.Millisecond)))
+	assert.Error(t, VerifyBackup(backups[0]))
+
+	backups, err = dumper.Backups([]Stat{stat})
+	assert.Nil(t, err)
+	assert.Len(t, backups, 1)
+	assert.Equal(t, backups[0].RunID, stat.RunID)
+	assert.Equal(t, backups[0].Checksum, stat.Checksum)
+	assert.Nil(t, VerifyBackup(backups[0]))
+
+	r, err := OpenBackup(backups[0])
+	assert.Nil(t, err)
+	data, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, string(data), "backup\n")
+
+	// corrupted backups fail verification
+	assert.Nil(t, os.WriteFile(backups[0].Path, []byte("corrupt"), 0644))
+	assert.Error(t, VerifyBackup(backups[0]))
+
+	// the mysql client is passed the connection from the dsn
+	var out bytes.Buffer
+	assert.Nil(t, dumper.Restore(context.Background(), strings.NewReader(""), "user:pass@tcp(db.example.com:3307)/app", &out))
+	assert.True(t, strings.HasPrefix(out.String(), "--defaults-extra-file="+dir))
+	assert.True(t, strings.HasSuffix(out.String(), " --host=db.example.com --port=3307 --protocol=TCP app\n"))
+	assert.NotContains(t, out.String(), "pass")
+
+	// the credentials are passed in an option file that's removed after the restore
+	matches, err := filepath.Glob(filepath.Join(dir, "mysqldump-*.cnf"))
+	assert.Nil(t, err)
+	assert.Len(t, matches, 0)
+
+	out.Reset()
+	assert.Nil(t, dumper.Restore(context.Background(), strings.NewReader(""), "unix(/run/mysqld/mysqld.sock)/", &out))
+	assert.Equal(t, out.String(), "--socket=/run/mysqld/mysqld.sock\n")
+
+	assert.Error(t, dumper.Restore(context.Background(), strings.NewReader(""), "bad dsn", &out))
+
+	config.MySQLDump.RestoreExecutablePath = "false"
+	assert.Error(t, dumper.Restore(context.Background(), strings.NewReader(""), "root@/", &out))
+}
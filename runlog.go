@@ -0,0 +1,190 @@
+package repbak
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// runLogTimeFormat is used to name run log files so they sort by start time.
+const runLogTimeFormat = "20060102T150405.000000000Z"
+
+// RunLog captures the output of a single backup run. Both the dumper's STDERR and repbak's own
+// log lines for the run are written to a file under LibPath. A nil RunLog only writes to the
+// repbak log.
+type RunLog struct {
+	path   string
+	file   *os.File
+	logger *log.Logger
+}
+
+// NewRunLog creates the log file for a run of the job name that started at start. If retention
+// is less than 0 no run logs are kept and nil is returned.
+func NewRunLog(config *Config, name string, start time.Time) (*RunLog, error) {
+	if config.Retention < 0 {
+		return nil, nil
+	}
+
+	dir := runLogDir(config, name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("RunLog: failed to create run log directory %s: %w", dir, err)
+	}
+
+	path := filepath.Join(dir, start.UTC().Format(runLogTimeFormat)+".log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("RunLog: failed to create run log %s: %w", path, err)
+	}
+
+	logger := log.New()
+	logger.SetOutput(file)
+	logger.SetLevel(log.TraceLevel)
+	logger.SetFormatter(&log.TextFormatter{
+		DisableColors: true,
+		FullTimestamp: true,
+	})
+
+	return &RunLog{
+		path:   path,
+		file:   file,
+		logger: logger,
+	}, nil
+}
+
+// Path returns the path of the run log file.
+func (l *RunLog) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Infof logs to both the repbak log and the run log.
+func (l *RunLog) Infof(format string, args ...interface{}) {
+	log.Infof(format, args...)
+	if l != nil {
+		l.logger.Infof(format, args...)
+	}
+}
+
+// Warnf logs to both the repbak log and the run log.
+func (l *RunLog) Warnf(format string, args ...interface{}) {
+	log.Warnf(format, args...)
+	if l != nil {
+		l.logger.Warnf(format, args...)
+	}
+}
+
+// Errorf logs to both the repbak log and the run log.
+func (l *RunLog) Errorf(format string, args ...interface{}) {
+	log.Errorf(format, args...)
+	if l != nil {
+		l.logger.Errorf(format, args...)
+	}
+}
+
+// Stderr records a line written to STDERR by the dumper.
+func (l *RunLog) Stderr(line string) {
+	log.Error(line)
+	if l != nil {
+		l.logger.WithField("stream", "stderr").Error(line)
+	}
+}
+
+// Close closes the run log file.
+func (l *RunLog) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// ListRunLogs returns the IDs of the stored run logs for the job name sorted newest first.
+func ListRunLogs(config *Config, name string) ([]string, error) {
+	if !validRunLogName(name) {
+		return nil, fmt.Errorf("RunLog: invalid job name %q", name)
+	}
+
+	entries, err := os.ReadDir(runLogDir(config, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("RunLog: failed to read run logs for %s: %w", name, err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".log" {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(entry.Name(), ".log"))
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	return ids, nil
+}
+
+// OpenRunLog opens the run log with id for the job name. If id is empty or latest then the most
+// recent run log is opened.
+func OpenRunLog(config *Config, name, id string) (*os.File, error) {
+	if id == "" || id == "latest" {
+		ids, err := ListRunLogs(config, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, fmt.Errorf("RunLog: no run logs found for %s: %w", name, os.ErrNotExist)
+		}
+		id = ids[0]
+	}
+
+	if !validRunLogName(name) || !validRunLogName(id) {
+		return nil, fmt.Errorf("RunLog: invalid run log %s/%s", name, id)
+	}
+
+	return os.Open(filepath.Join(runLogDir(config, name), id+".log"))
+}
+
+// removeRunLog deletes the run log at path of the job name. Missing files are ignored and paths
+// outside the run log directory of the job are refused.
+func removeRunLog(config *Config, name, path string) error {
+	if path == "" {
+		return nil
+	}
+	if !isRunLogPath(config, name, path) {
+		return fmt.Errorf("RunLog: refusing to remove %s outside the run logs of %s", path, name)
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("RunLog: failed to remove run log %s: %w", path, err)
+	}
+	return nil
+}
+
+// isRunLogPath reports whether path is a run log file in the run log directory of the job name.
+// Stats can be imported so the log path they store isn't trusted.
+func isRunLogPath(config *Config, name, path string) bool {
+	if !validRunLogName(name) {
+		return false
+	}
+
+	rel, err := filepath.Rel(runLogDir(config, name), filepath.Clean(path))
+	return err == nil && validRunLogName(rel) && filepath.Ext(rel) == ".log"
+}
+
+func runLogDir(config *Config, name string) string {
+	return filepath.Join(config.LibPath, "logs", name)
+}
+
+// validRunLogName protects against path traversal when names and IDs come from users.
+func validRunLogName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,100 @@
+package repbak
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{
+		LibPath:   dir,
+		Retention: 3,
+	}
+
+	start := time.Now()
+
+	runLog1, err := NewRunLog(config, "TEST", start)
+	assert.Nil(t, err)
+	runLog1.Infof("first run")
+	assert.Nil(t, runLog1.Close())
+
+	runLog2, err := NewRunLog(config, "TEST", start.Add(time.Second))
+	assert.Nil(t, err)
+	runLog2.Infof("second run")
+	runLog2.Stderr("mysqldump: Got error: 2002")
+	assert.Nil(t, runLog2.Close())
+
+	ids, err := ListRunLogs(config, "TEST")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 2)
+
+	f, err := OpenRunLog(config, "TEST", "")
+	assert.Nil(t, err)
+	data, err := io.ReadAll(f)
+	assert.Nil(t, err)
+	f.Close()
+	assert.Contains(t, string(data), "second run")
+	assert.Contains(t, string(data), "mysqldump: Got error: 2002")
+	assert.Contains(t, string(data), "stream=stderr")
+
+	f, err = OpenRunLog(config, "TEST", ids[1])
+	assert.Nil(t, err)
+	data, err = io.ReadAll(f)
+	assert.Nil(t, err)
+	f.Close()
+	assert.Contains(t, string(data), "first run")
+
+	assert.Nil(t, removeRunLog(config, "TEST", runLog1.Path()))
+	assert.Nil(t, removeRunLog(config, "TEST", runLog1.Path()))
+
+	// paths outside the run logs of the job are refused
+	other := filepath.Join(dir, "other.log")
+	assert.Nil(t, os.WriteFile(other, []byte("other"), 0600))
+	assert.Error(t, removeRunLog(config, "TEST", other))
+	assert.Error(t, removeRunLog(config, "OTHER", runLog2.Path()))
+	assert.Error(t, removeRunLog(config, "TEST", filepath.Join(runLogDir(config, "TEST"), "..", "..", "other.log")))
+	_, err = os.Stat(other)
+	assert.Nil(t, err)
+
+	ids, err = ListRunLogs(config, "TEST")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 1)
+
+	ids, err = ListRunLogs(config, "MISSING")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 0)
+
+	_, err = OpenRunLog(config, "MISSING", "")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = OpenRunLog(config, "TEST", "../../repbak")
+	assert.Error(t, err)
+
+	_, err = ListRunLogs(config, "..")
+	assert.Error(t, err)
+}
+
+func TestRunLogWithoutRetention(t *testing.T) {
+	config := &Config{
+		LibPath:   "/nonexistent",
+		Retention: -1,
+	}
+
+	runLog, err := NewRunLog(config, "TEST", time.Now())
+	assert.Nil(t, err)
+	assert.Nil(t, runLog)
+	assert.Equal(t, runLog.Path(), "")
+
+	runLog.Infof("only logged to the repbak log")
+	runLog.Stderr("only logged to the repbak log")
+	assert.Nil(t, runLog.Close())
+}
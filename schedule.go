@@ -0,0 +1,59 @@
+package repbak
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	cron "github.com/robfig/cron/v3"
+)
+
+// scheduleParser parses cron expressions with an optional leading seconds field and descriptors such
+// as @daily and @every 6h.
+var scheduleParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// newCrontab returns a cron that parses schedules with scheduleParser.
+func newCrontab() *cron.Cron {
+	return cron.New(cron.WithParser(scheduleParser))
+}
+
+// scheduleSpec returns schedule evaluated in the IANA timezone. The local time is used if timezone
+// is empty.
+func scheduleSpec(schedule, timezone string) string {
+	if timezone == "" {
+		return schedule
+	}
+	return "CRON_TZ=" + timezone + " " + schedule
+}
+
+// parseSchedule parses schedule evaluated in the IANA timezone.
+func parseSchedule(schedule, timezone string) (cron.Schedule, error) {
+	return scheduleParser.Parse(scheduleSpec(schedule, timezone))
+}
+
+// missedRun returns true if a run of schedule was due between the start of the last successful run
+// and now. It's true if there's no successful run.
+func missedRun(schedule cron.Schedule, lastSuccess *Stat, now time.Time) bool {
+	if lastSuccess == nil {
+		return true
+	}
+	return !schedule.Next(lastSuccess.Start).After(now)
+}
+
+// jitterRand is the source of the random start delays. The global source isn't seeded in go 1.19.
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomDelay returns a random delay from 0 up to max.
+func randomDelay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
@@ -0,0 +1,58 @@
+package repbak
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// standard five field expressions
+	schedule, err := parseSchedule("0 3 * * *", "")
+	assert.Nil(t, err)
+	assert.Equal(t, schedule.Next(now).Hour(), 3)
+
+	// an optional seconds field
+	schedule, err = parseSchedule("30 0 3 * * *", "UTC")
+	assert.Nil(t, err)
+	assert.Equal(t, schedule.Next(now), time.Date(2023, 6, 2, 3, 0, 30, 0, time.UTC))
+
+	// descriptors
+	schedule, err = parseSchedule("@every 6h", "")
+	assert.Nil(t, err)
+	assert.Equal(t, schedule.Next(now), now.Add(6*time.Hour))
+
+	// timezones
+	schedule, err = parseSchedule("0 3 * * *", "America/New_York")
+	assert.Nil(t, err)
+	assert.Equal(t, schedule.Next(now).UTC(), time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC))
+
+	_, err = parseSchedule("0 3 * * *", "Mars/Olympus_Mons")
+	assert.Error(t, err)
+
+	_, err = parseSchedule("invalid", "")
+	assert.Error(t, err)
+}
+
+func TestMissedRun(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	schedule, err := parseSchedule("0 0 * * *", "UTC")
+	assert.Nil(t, err)
+
+	assert.True(t, missedRun(schedule, nil, now))
+	assert.False(t, missedRun(schedule, &Stat{Start: now.Add(-12 * time.Hour)}, now))
+	assert.True(t, missedRun(schedule, &Stat{Start: now.Add(-13 * time.Hour)}, now))
+}
+
+func TestRandomDelay(t *testing.T) {
+	assert.Equal(t, randomDelay(0), time.Duration(0))
+
+	for i := 0; i < 100; i++ {
+		delay := randomDelay(time.Second)
+		assert.True(t, delay >= 0 && delay < time.Second)
+	}
+}
@@ -0,0 +1,480 @@
+package repbak
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/healthcheck/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// apiPrefix is the path prefix of the versioned JSON API.
+const apiPrefix = "/api/v1/"
+
+// defaultStatsLimit is the number of stats returned by the API when no limit is given.
+const defaultStatsLimit = 50
+
+// Server serves the health checks, metrics, run logs, JSON API and dashboard of repbak over HTTP.
+type Server struct {
+	config  *Config
+	db      DB
+	repbak  *RepBak
+	metrics *Metrics
+	mux     *http.ServeMux
+	server  *http.Server
+	reload  func() error
+	mu      sync.RWMutex
+
+	// clientCerts is true if clients must present a verified certificate. Like the rest of the TLS
+	// config it's fixed when the server starts.
+	clientCerts bool
+}
+
+// NewServer returns a Server for rb. Metrics may be nil.
+func NewServer(config *Config, db DB, rb *RepBak, metrics *Metrics) *Server {
+	s := &Server{
+		config:  config,
+		db:      db,
+		repbak:  rb,
+		metrics: metrics,
+		mux:     http.NewServeMux(),
+	}
+
+	s.server = &http.Server{Handler: s}
+	if config.HTTP != nil {
+		s.server.Addr = fmt.Sprintf("%s:%d", config.HTTP.Addr, config.HTTP.Port)
+		s.clientCerts = config.HTTP.TLS != nil && config.HTTP.TLS.ClientCAFile != ""
+	}
+
+	// liveness check
+	s.mux.Handle("/live", healthcheck.Handler(
+		healthcheck.WithChecker(
+			"live", healthcheck.CheckerFunc(
+				func(ctx context.Context) error {
+					return nil
+				},
+			),
+		),
+	))
+
+	// health of every backup. /health/{job} returns the health of a single backup.
+	s.mux.HandleFunc("/health", s.health)
+	s.mux.HandleFunc("/health/", s.jobHealth)
+
+	// prometheus metrics
+	s.mux.Handle("/metrics", metrics)
+
+	// pending and dead lettered notifications
+	s.mux.HandleFunc("/outbox", s.outbox)
+
+	// captured run logs. /logs/{name} lists the run log IDs and /logs/{name}/{id} returns a run log.
+	s.mux.HandleFunc("/logs/", s.logs)
+
+	// JSON API
+	s.mux.HandleFunc(apiPrefix, s.api)
+
+	// read only dashboard and its embedded assets
+	dashboard := newDashboard(db, rb)
+	s.mux.Handle("/", dashboard)
+	s.mux.Handle("/assets/", dashboard.assets)
+
+	return s
+}
+
+// SetConfig replaces the config used for authorization and run logs. The address and TLS config
+// can't be changed without a restart.
+func (s *Server) SetConfig(config *Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = config
+}
+
+// OnReload sets the function called to reload the configuration by POST /api/v1/reload.
+func (s *Server) OnReload(reload func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reload = reload
+}
+
+// currentConfig returns the config in use.
+func (s *Server) currentConfig() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// ListenAndServe serves http or https if TLS is configured until the server is shut down.
+func (s *Server) ListenAndServe() error {
+	if s.config.HTTP == nil || s.config.HTTP.TLS == nil {
+		return s.server.ListenAndServe()
+	}
+
+	tlsConfig, err := newTLSConfig(s.config.HTTP.TLS)
+	if err != nil {
+		return err
+	}
+	s.server.TLSConfig = tlsConfig
+
+	return s.server.ListenAndServeTLS("", "")
+}
+
+// Shutdown gracefully shuts down the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// ServeHTTP authorizes the request and serves it with the matching handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var auth *HTTPAuth
+	if config := s.currentConfig(); config.HTTP != nil {
+		auth = config.HTTP.Auth
+	}
+
+	if !authorize(auth, s.clientCerts, w, r) {
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	report, err := s.repbak.Health()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeHealth(w, report.Healthy, report)
+}
+
+func (s *Server) jobHealth(w http.ResponseWriter, r *http.Request) {
+	health, err := s.repbak.JobHealth(strings.TrimPrefix(r.URL.Path, "/health/"))
+	if errors.Is(err, ErrUnknownJob) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeHealth(w, health.Healthy, health)
+}
+
+func (s *Server) outbox(w http.ResponseWriter, r *http.Request) {
+	status, err := ListOutbox(s.db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) logs(w http.ResponseWriter, r *http.Request) {
+	name, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/logs/"), "/")
+
+	if id == "" {
+		ids, err := ListRunLogs(s.currentConfig(), name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, ids)
+		return
+	}
+
+	f, err := OpenRunLog(s.currentConfig(), name, id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, f)
+}
+
+// api routes the JSON API requests.
+//
+//	GET  /api/v1/jobs
+//	GET  /api/v1/jobs/{name}
+//	POST /api/v1/jobs/{name}/run
+//	POST /api/v1/jobs/{name}/cancel
+//	GET  /api/v1/jobs/{name}/stats?limit=&before=
+//	GET  /api/v1/jobs/{name}/runs/{run_id}/log
+//	POST /api/v1/reload
+//	GET  /api/v1/maintenance
+//	POST /api/v1/maintenance
+//	DELETE /api/v1/maintenance
+func (s *Server) api(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/"), "/")
+	if len(parts) == 1 && parts[0] == "reload" {
+		s.reloadConfig(w, r)
+		return
+	}
+	if len(parts) == 1 && parts[0] == "maintenance" {
+		s.maintenance(w, r)
+		return
+	}
+	if parts[0] != "jobs" {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.repbak.Jobs())
+	case len(parts) == 2:
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		job, err := s.repbak.Job(parts[1])
+		if err != nil {
+			writeAPIError(w, apiStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	case len(parts) == 3 && parts[2] == "run":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		if err := s.repbak.Run(parts[1], TriggerAPI); err != nil {
+			writeAPIError(w, apiStatus(err), err)
+			return
+		}
+		log.Infof("Backup of %s triggered by %s", parts[1], r.RemoteAddr)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+	case len(parts) == 3 && parts[2] == "cancel":
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		if err := s.repbak.Cancel(parts[1]); err != nil {
+			writeAPIError(w, apiStatus(err), err)
+			return
+		}
+		log.Infof("Backup of %s canceled by %s", parts[1], r.RemoteAddr)
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "canceling"})
+	case len(parts) == 3 && parts[2] == "stats":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		s.stats(w, r, parts[1])
+	case len(parts) == 5 && parts[2] == "runs" && parts[4] == "log":
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		s.runLog(w, r, parts[1], parts[3])
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// reloadConfig reloads the configuration with the function set by OnReload.
+func (s *Server) reloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	s.mu.RLock()
+	reload := s.reload
+	s.mu.RUnlock()
+
+	if reload == nil {
+		writeAPIError(w, http.StatusNotImplemented, errors.New("reloading isn't supported"))
+		return
+	}
+
+	log.Infof("Reload triggered by %s", r.RemoteAddr)
+	if err := reload(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// maintenance gets, enables, or disables maintenance mode. POST takes an optional JSON body with the
+// reason.
+func (s *Server) maintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.repbak.Maintenance())
+	case http.MethodPost:
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+			return
+		}
+
+		maintenance, err := s.repbak.SetMaintenance(true, body.Reason)
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Infof("Maintenance mode enabled by %s", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, maintenance)
+	case http.MethodDelete:
+		maintenance, err := s.repbak.SetMaintenance(false, "")
+		if err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Infof("Maintenance mode disabled by %s", r.RemoteAddr)
+		writeJSON(w, http.StatusOK, maintenance)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// stats writes a page of the stats of the job name newest first.
+func (s *Server) stats(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := s.repbak.Job(name); err != nil {
+		writeAPIError(w, apiStatus(err), err)
+		return
+	}
+
+	limit := defaultStatsLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeAPIError(w, http.StatusBadRequest, errors.New("invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	var before time.Time
+	if v := r.URL.Query().Get("before"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, errors.New("invalid before, expected RFC 3339"))
+			return
+		}
+		before = t
+	}
+
+	stats, err := s.db.Page(name, before, limit)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if stats == nil {
+		stats = []Stat{}
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// runLog writes the captured log of the run with runID of the job name. A runID of latest returns
+// the log of the most recent run.
+func (s *Server) runLog(w http.ResponseWriter, r *http.Request, name, runID string) {
+	if _, err := s.repbak.Job(name); err != nil {
+		writeAPIError(w, apiStatus(err), err)
+		return
+	}
+
+	stat, err := s.findStat(name, runID)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if stat == nil || !isRunLogPath(s.currentConfig(), name, stat.Log) {
+		writeAPIError(w, http.StatusNotFound, errors.New("run log not found"))
+		return
+	}
+
+	f, err := os.Open(stat.Log)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeAPIError(w, http.StatusNotFound, errors.New("run log not found"))
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.Copy(w, f)
+}
+
+// findStat returns the stat of the job name with runID or nil if there isn't one. A runID of latest
+// returns the most recent stat.
+func (s *Server) findStat(name, runID string) (*Stat, error) {
+	if runID == "latest" {
+		stats, err := s.db.Page(name, time.Time{}, 1)
+		if err != nil || len(stats) == 0 {
+			return nil, err
+		}
+		return &stats[0], nil
+	}
+
+	return s.db.Run(name, runID)
+}
+
+// apiStatus returns the HTTP status of an error returned by RepBak.
+func apiStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrUnknownJob):
+		return http.StatusNotFound
+	case errors.Is(err, ErrJobRunning), errors.Is(err, ErrJobNotRunning):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// allowMethod writes a 405 and returns false if the request method isn't method.
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+// writeAPIError writes err as a JSON error with status.
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes v as JSON with status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeHealth writes v as JSON with a 200 status if healthy and 503 otherwise.
+func writeHealth(w http.ResponseWriter, healthy bool, v interface{}) {
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, v)
+}
@@ -0,0 +1,90 @@
+package repbak
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// authenticate returns the role of the client that sent r or an empty string if the client didn't
+// authenticate. Every client is an operator if auth isn't configured.
+func authenticate(auth *HTTPAuth, r *http.Request) string {
+	if auth == nil {
+		return RoleOperator
+	}
+
+	if token, ok := bearerToken(r); ok {
+		for _, t := range auth.Tokens {
+			if secretEqual(token, t.Token) {
+				return t.Role
+			}
+		}
+		return ""
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, u := range auth.Users {
+			if secretEqual(username, u.Username) && secretEqual(password, u.Password) {
+				return u.Role
+			}
+		}
+	}
+
+	return ""
+}
+
+// requiredRole returns the role needed to serve r. Requests that change state need an operator.
+func requiredRole(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return RoleRead
+	default:
+		return RoleOperator
+	}
+}
+
+// authorize writes a 401 or 403 and returns false if the client that sent r isn't allowed to. If
+// clientCerts is true then the client must also have presented a verified certificate.
+func authorize(auth *HTTPAuth, clientCerts bool, w http.ResponseWriter, r *http.Request) bool {
+	if auth != nil && auth.PublicLive && r.URL.Path == "/live" {
+		return true
+	}
+
+	if clientCerts && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		writeAPIError(w, http.StatusUnauthorized, errors.New("unauthorized: a client certificate is required"))
+		return false
+	}
+
+	role := authenticate(auth, r)
+	if role == "" {
+		if len(auth.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="repbak"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="repbak"`)
+		}
+		writeAPIError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+		return false
+	}
+
+	if role != RoleOperator && requiredRole(r) == RoleOperator {
+		writeAPIError(w, http.StatusForbidden, errors.New("forbidden: requires the operator role"))
+		return false
+	}
+
+	return true
+}
+
+// bearerToken returns the bearer token in the Authorization header of r.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// secretEqual compares a and b in constant time.
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
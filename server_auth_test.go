@@ -0,0 +1,68 @@
+package repbak
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerAuth(t *testing.T) {
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+
+	config.HTTP = &HTTP{
+		Auth: &HTTPAuth{
+			Tokens: []HTTPToken{
+				{Token: "READ"},
+				{Token: "OPERATE", Role: RoleOperator},
+			},
+			Users: []HTTPUser{
+				{Username: "viewer", Password: "PASS"},
+			},
+		},
+	}
+	assert.Nil(t, config.validate())
+
+	rb := New(config, nil, &testDumper{}, &testNotifier{})
+	server := NewServer(config, nil, rb, nil)
+
+	request := func(method, path string, auth func(r *http.Request)) int {
+		r := httptest.NewRequest(method, path, nil)
+		if auth != nil {
+			auth(r)
+		}
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+	bearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	assert.Equal(t, request(http.MethodGet, "/live", nil), http.StatusUnauthorized)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/jobs", nil), http.StatusUnauthorized)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/jobs", bearer("BAD")), http.StatusUnauthorized)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/jobs", bearer("READ")), http.StatusOK)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/jobs", func(r *http.Request) {
+		r.SetBasicAuth("viewer", "PASS")
+	}), http.StatusOK)
+	assert.Equal(t, request(http.MethodGet, "/api/v1/jobs", func(r *http.Request) {
+		r.SetBasicAuth("viewer", "BAD")
+	}), http.StatusUnauthorized)
+
+	// only operators change state
+	assert.Equal(t, request(http.MethodPost, "/api/v1/jobs/mysqldump/cancel", bearer("READ")), http.StatusForbidden)
+	assert.Equal(t, request(http.MethodPost, "/api/v1/jobs/mysqldump/cancel", bearer("OPERATE")), http.StatusConflict)
+
+	config.HTTP.Auth.PublicLive = true
+	assert.Equal(t, request(http.MethodGet, "/live", nil), http.StatusOK)
+	assert.Equal(t, request(http.MethodGet, "/live/", nil), http.StatusUnauthorized)
+
+	// no auth
+	config.HTTP.Auth = nil
+	assert.Equal(t, request(http.MethodPost, "/api/v1/jobs/mysqldump/cancel", nil), http.StatusConflict)
+}
@@ -0,0 +1,186 @@
+package repbak
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerAPI(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.LibPath = dir
+
+	db, err := NewBoltDB(config)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	dumper := &testDumper{stat: Stat{Name: "mysqldump", Skip: true}}
+	rb := New(config, db, dumper, &testNotifier{})
+	server := NewServer(config, db, rb, nil)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// jobs
+	w := request(http.MethodGet, "/api/v1/jobs")
+	assert.Equal(t, w.Code, http.StatusOK)
+	var jobs []Job
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&jobs))
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, jobs[0].Name, "mysqldump")
+	assert.Equal(t, jobs[0].Schedule, config.MySQLDump.Schedule)
+	assert.Nil(t, jobs[0].RunningSince)
+
+	w = request(http.MethodGet, "/api/v1/jobs/MISSING")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	assert.Contains(t, w.Body.String(), `"error"`)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/run")
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed)
+
+	// trigger
+	w = request(http.MethodPost, "/api/v1/jobs/mysqldump/run")
+	assert.Equal(t, w.Code, http.StatusAccepted)
+	assert.Eventually(t, func() bool {
+		return len(dumper.triggers()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, dumper.triggers(), []string{TriggerAPI})
+
+	// cancel
+	w = request(http.MethodPost, "/api/v1/jobs/mysqldump/cancel")
+	assert.Equal(t, w.Code, http.StatusConflict)
+	assert.Equal(t, dumper.stops, 0)
+
+	dumper.started = time.Now()
+
+	w = request(http.MethodPost, "/api/v1/jobs/mysqldump/run")
+	assert.Equal(t, w.Code, http.StatusConflict)
+
+	w = request(http.MethodPost, "/api/v1/jobs/mysqldump/cancel")
+	assert.Equal(t, w.Code, http.StatusAccepted)
+	assert.Equal(t, dumper.stops, 1)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump")
+	assert.Equal(t, w.Code, http.StatusOK)
+	var job Job
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&job))
+	assert.True(t, job.RunningSince.Equal(dumper.started))
+
+	// stats and run logs
+	logPath := filepath.Join(runLogDir(config, "mysqldump"), "run.log")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(logPath), 0700))
+	assert.Nil(t, os.WriteFile(logPath, []byte("LOG"), 0644))
+
+	first := NewStat("mysqldump").Finish(nil)
+	first.Start = first.Start.Add(-time.Hour)
+	first.Log = logPath
+	assert.Nil(t, db.Insert(first))
+
+	second := NewStat("mysqldump").Finish(nil)
+	assert.Nil(t, db.Insert(second))
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/stats?limit=1")
+	assert.Equal(t, w.Code, http.StatusOK)
+	var stats []Stat
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&stats))
+	assert.Len(t, stats, 1)
+	assert.Equal(t, stats[0].RunID, second.RunID)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/stats?before="+second.Start.Format(time.RFC3339Nano))
+	assert.Equal(t, w.Code, http.StatusOK)
+	stats = nil
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&stats))
+	assert.Len(t, stats, 2)
+	assert.Equal(t, stats[0].RunID, first.RunID)
+
+	// the backup skipped by the dumper when triggered is recorded
+	assert.True(t, stats[1].Skip)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/stats?limit=x")
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/runs/"+first.RunID+"/log")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "LOG")
+
+	// logs outside the run log directory aren't served
+	outside := NewStat("mysqldump").Finish(nil)
+	outside.Start = outside.Start.Add(-2 * time.Hour)
+	outside.Log = filepath.Join(dir, "repbak.db")
+	assert.Nil(t, db.Insert(outside))
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/runs/"+outside.RunID+"/log")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/runs/latest/log")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	w = request(http.MethodGet, "/api/v1/jobs/mysqldump/runs/MISSING/log")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	// reload
+	w = request(http.MethodPost, "/api/v1/reload")
+	assert.Equal(t, w.Code, http.StatusNotImplemented)
+
+	reloads := 0
+	server.OnReload(func() error {
+		reloads++
+		if reloads > 1 {
+			return errors.New("invalid config")
+		}
+		return nil
+	})
+
+	w = request(http.MethodGet, "/api/v1/reload")
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed)
+
+	w = request(http.MethodPost, "/api/v1/reload")
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = request(http.MethodPost, "/api/v1/reload")
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Contains(t, w.Body.String(), "invalid config")
+	assert.Equal(t, reloads, 2)
+
+	// maintenance
+	w = request(http.MethodGet, "/api/v1/maintenance")
+	assert.Equal(t, w.Code, http.StatusOK)
+	var maintenance Maintenance
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&maintenance))
+	assert.False(t, maintenance.Enabled)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/maintenance", strings.NewReader(`{"reason":"upgrade"}`)))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&maintenance))
+	assert.True(t, maintenance.Enabled)
+	assert.Equal(t, maintenance.Reason, "upgrade")
+	assert.True(t, rb.Maintenance().Enabled)
+
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/maintenance", strings.NewReader(`{`)))
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+
+	w = request(http.MethodPut, "/api/v1/maintenance")
+	assert.Equal(t, w.Code, http.StatusMethodNotAllowed)
+
+	w = request(http.MethodDelete, "/api/v1/maintenance")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.False(t, rb.Maintenance().Enabled)
+}
@@ -0,0 +1,125 @@
+package repbak
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// certReloader loads a certificate and reloads it when the certificate or key file changes so
+// renewed certificates are served without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader returns a certReloader with the certificate loaded.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// GetCertificate returns the certificate, reloading it first if the files changed. The previous
+// certificate is kept if the reload fails.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		log.Error(err)
+		return c.cert, nil
+	}
+
+	if modTime.After(c.modTime) {
+		if err := c.load(modTime); err != nil {
+			log.Error(err)
+		} else {
+			log.Infof("TLS: reloaded certificate %s", c.certFile)
+		}
+	}
+
+	return c.cert, nil
+}
+
+// load loads the certificate. The lock must be held.
+func (c *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("TLS: failed to load certificate: %w", err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+
+	return nil
+}
+
+// latestModTime returns the latest modification time of the certificate and key files.
+func (c *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, fmt.Errorf("TLS: failed to stat %s: %w", path, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// newTLSConfig returns the TLS configuration of the http server. If a client CA file is set then
+// certificates presented by clients must be signed by it. Clients without a certificate complete the
+// handshake so the public /live probe works and are rejected by authorize.
+func newTLSConfig(config *HTTPTLS) (*tls.Config, error) {
+	reloader, err := newCertReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("TLS: failed to read client_ca_file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("TLS: no certificates found in %s", config.ClientCAFile)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
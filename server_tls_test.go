@@ -0,0 +1,160 @@
+package repbak
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestCert writes a self signed certificate with the common name to certFile and keyFile.
+func writeTestCert(t *testing.T, name, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	_, err = newCertReloader(certFile, keyFile)
+	assert.Error(t, err)
+
+	writeTestCert(t, "first", certFile, keyFile)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	commonName := func() string {
+		cert, err := reloader.GetCertificate(nil)
+		assert.Nil(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.Nil(t, err)
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, commonName(), "first")
+
+	// renewed certificate
+	writeTestCert(t, "second", certFile, keyFile)
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	assert.Equal(t, commonName(), "second")
+
+	// a broken certificate keeps the previous one
+	assert.Nil(t, os.WriteFile(keyFile, []byte("BAD"), 0600))
+	later = later.Add(time.Minute)
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	assert.Equal(t, commonName(), "second")
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, "server", certFile, keyFile)
+
+	tlsConfig, err := newTLSConfig(&HTTPTLS{CertFile: certFile, KeyFile: keyFile})
+	assert.Nil(t, err)
+	assert.Equal(t, tlsConfig.ClientAuth, tls.NoClientCert)
+
+	tlsConfig, err = newTLSConfig(&HTTPTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: certFile})
+	assert.Nil(t, err)
+	assert.Equal(t, tlsConfig.ClientAuth, tls.VerifyClientCertIfGiven)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	_, err = newTLSConfig(&HTTPTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile})
+	assert.Error(t, err)
+}
+
+func TestServerClientCert(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, "server", certFile, keyFile)
+
+	clientCertFile := filepath.Join(dir, "client.pem")
+	clientKeyFile := filepath.Join(dir, "client-key.pem")
+	writeTestCert(t, "client", clientCertFile, clientKeyFile)
+
+	config, err := OpenConfig("./testdata/repbak.yaml")
+	assert.Nil(t, err)
+	config.HTTP = &HTTP{
+		TLS: &HTTPTLS{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCertFile},
+		Auth: &HTTPAuth{
+			PublicLive: true,
+			Tokens:     []HTTPToken{{Token: "READ"}},
+		},
+	}
+	assert.Nil(t, config.validate())
+
+	rb := New(config, nil, &testDumper{}, &testNotifier{})
+	tlsConfig, err := newTLSConfig(config.HTTP.TLS)
+	assert.Nil(t, err)
+
+	ts := httptest.NewUnstartedServer(NewServer(config, nil, rb, nil))
+	ts.TLS = tlsConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	request := func(path string, certs ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: certs},
+		}}
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer READ")
+
+		resp, err := client.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// probes without a certificate only reach the public /live
+	assert.Equal(t, request("/live"), http.StatusOK)
+	assert.Equal(t, request("/api/v1/jobs"), http.StatusUnauthorized)
+
+	cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.Nil(t, err)
+	assert.Equal(t, request("/api/v1/jobs", cert), http.StatusOK)
+}
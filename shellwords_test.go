@@ -0,0 +1,32 @@
+package repbak
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		args []string
+	}{
+		{"", nil},
+		{"  --all-databases  --add-drop-database ", []string{"--all-databases", "--add-drop-database"}},
+		{`--where='id > 10'`, []string{"--where=id > 10"}},
+		{`--comments "a \"quoted\" \$value" 'it''s'`, []string{"--comments", `a "quoted" $value`, "its"}},
+		{`a\ b c\\d "e\f"`, []string{"a b", `c\d`, `e\f`}},
+		{`'' ""`, []string{"", ""}},
+	}
+
+	for _, test := range tests {
+		args, err := splitArgs(test.s)
+		assert.Nil(t, err)
+		assert.Equal(t, args, test.args, test.s)
+	}
+
+	for _, s := range []string{`'unterminated`, `"unterminated`, `trailing\`} {
+		_, err := splitArgs(s)
+		assert.Error(t, err, s)
+	}
+}
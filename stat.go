@@ -1,20 +1,97 @@
 package repbak
 
-import "time"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// statSchemaVersion is the version of the stored Stat format. Stats stored before versioning have
+// a SchemaVersion of 0. Version 2 stores Start and End as RFC 3339 timestamps instead of strings
+// formatted with time_format.
+const statSchemaVersion = 2
+
+// Sources that can trigger a backup.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerAPI      = "api"
+
+	// TriggerCatchUp is a backup run at startup because a scheduled backup was missed.
+	TriggerCatchUp = "catch_up"
+)
 
 // Stat defines basic statistics for a single sync. Stats are stored so that historical data from past syncs
 // can be viewed.
 type Stat struct {
-	Name     string
-	Success  bool
-	Start    string
-	End      string
+	// SchemaVersion is the version of the stored stat format.
+	SchemaVersion int
+
+	// RunID uniquely identifies the run.
+	RunID string
+
+	Name    string
+	Success bool
+
+	// Start and End are when the run started and finished. They're formatted with time_format only
+	// when displayed.
+	Start    time.Time
+	End      time.Time
 	Duration time.Duration
 	Error    error `json:"-"`
-	Skip     bool
-	format   string
-	start    time.Time
-	end      time.Time
+
+	// ErrorMessage is the message of Error so the reason for a failure is stored.
+	ErrorMessage string
+
+	// ExitCode is the exit code of the dumper process. It's -1 if the process didn't exit normally.
+	ExitCode int
+
+	// Signal is the name of the signal that killed the dumper process if any.
+	Signal string
+
+	Skip bool
+
+	// SkipReason explains why the backup was skipped.
+	SkipReason string `json:",omitempty"`
+
+	Log    string
+	Stderr []string `json:"-"`
+
+	// Artifacts are the paths of the backup files created by the run.
+	Artifacts []string
+
+	// BytesWritten is the number of uncompressed bytes written by the dumper.
+	BytesWritten int64
+
+	// CompressedSize is the size of the backup on disk when compression is enabled.
+	CompressedSize int64
+
+	// Checksum is the hex encoded SHA-256 checksum of the backup file.
+	Checksum string
+
+	// Host is the hostname of the server that ran the backup.
+	Host string
+
+	// Version is the version of repbak that ran the backup.
+	Version string
+
+	// Trigger is what started the backup: schedule, manual, or api.
+	Trigger string
+
+	// Attempt is the attempt of the run starting at 1. Each attempt of a retried backup is stored.
+	Attempt int `json:",omitempty"`
+
+	// Retried is true if the attempt failed and the backup was retried.
+	Retried bool `json:",omitempty"`
 }
 
 // Finish sets the Success based on err, End based on the current time, and Duration based on Start and End.
@@ -23,23 +100,202 @@ func (s Stat) Finish(err error) Stat {
 		s.Success = true
 	}
 
-	s.end = time.Now()
-	s.End = s.end.Format(s.format)
-	s.Duration = time.Since(s.start)
+	s.End = time.Now()
+	s.Duration = s.End.Sub(s.Start)
 	s.Error = err
+	s.ExitCode, s.Signal = exitStatus(err)
+	if err != nil {
+		s.ErrorMessage = err.Error()
+	}
 	return s
 }
 
+// UnmarshalJSON decodes a stored stat and restores Error from ErrorMessage. Start and End of stats
+// stored before schema version 2 are left zero since their format is unknown.
+func (s *Stat) UnmarshalJSON(data []byte) error {
+	type stored Stat
+	aux := struct {
+		*stored
+		Start json.RawMessage
+		End   json.RawMessage
+	}{
+		stored: (*stored)(s),
+	}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	s.Start = parseStatTime(aux.Start)
+	s.End = parseStatTime(aux.End)
+
+	if s.ErrorMessage != "" {
+		s.Error = errors.New(s.ErrorMessage)
+	}
+	return nil
+}
+
+// parseStatTime parses a stored RFC 3339 time. Anything else is returned as the zero time.
+func parseStatTime(data json.RawMessage) time.Time {
+	var t time.Time
+	if err := json.Unmarshal(data, &t); err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
 // NewStat creates a new Stat with Name set to name, Success set to false, and Start set to
 // the current time.
-func NewStat(name string, format string) Stat {
+func NewStat(name string) Stat {
 	start := time.Now()
 
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
 	return Stat{
-		Name:    name,
-		Success: false,
-		Start:   start.Format(format),
-		start:   start,
-		format:  format,
+		SchemaVersion: statSchemaVersion,
+		RunID:         newRunID(),
+		Name:          name,
+		Success:       false,
+		Start:         start,
+		Host:          host,
+		Version:       Version,
+	}
+}
+
+// Aggregate summarizes the stats of a job over a window of time.
+type Aggregate struct {
+	Name      string
+	Runs      int
+	Successes int
+	Failures  int
+
+	// Skips is the number of skipped backups. They aren't counted as runs.
+	Skips int
+
+	// Retries is the number of failed attempts that were retried. They aren't counted as runs.
+	Retries int
+
+	// SuccessRate is the fraction of runs that succeeded from 0 to 1.
+	SuccessRate float64
+
+	MeanDuration time.Duration
+	P95Duration  time.Duration
+
+	// TotalBytes is the sum of the uncompressed bytes written by all runs.
+	TotalBytes int64
+}
+
+// AggregateStats computes the Aggregate of stats for the job name.
+func AggregateStats(name string, stats []Stat) Aggregate {
+	aggregate := Aggregate{
+		Name: name,
+	}
+
+	durations := make([]time.Duration, 0, len(stats))
+	var total time.Duration
+	for _, stat := range stats {
+		if stat.Skip {
+			aggregate.Skips++
+			continue
+		}
+		if stat.Retried {
+			aggregate.Retries++
+			continue
+		}
+
+		aggregate.Runs++
+		if stat.Success {
+			aggregate.Successes++
+		} else {
+			aggregate.Failures++
+		}
+
+		aggregate.TotalBytes += stat.BytesWritten
+		total += stat.Duration
+		durations = append(durations, stat.Duration)
+	}
+
+	if aggregate.Runs == 0 {
+		return aggregate
+	}
+
+	// p95 uses the nearest rank method
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	rank := int(math.Ceil(0.95*float64(len(durations)))) - 1
+
+	aggregate.SuccessRate = float64(aggregate.Successes) / float64(aggregate.Runs)
+	aggregate.MeanDuration = total / time.Duration(len(durations))
+	aggregate.P95Duration = durations[rank]
+
+	return aggregate
+}
+
+// newRunID returns a random ID for a run.
+func newRunID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// fall back to the time which is unique enough for a single host
+		return time.Now().UTC().Format(runLogTimeFormat)
+	}
+	return hex.EncodeToString(b)
+}
+
+// exitStatus returns the exit code and signal of the process that caused err. A nil error is a 0
+// exit code and any error not caused by a process exiting is -1.
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return -1, ""
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitErr.ExitCode(), status.Signal().String()
+	}
+	return exitErr.ExitCode(), ""
+}
+
+// failureCanceled is a backup that was canceled. Canceled backups are never retried.
+const failureCanceled = "canceled"
+
+// connectErrors are lowercase messages of mysql clients that couldn't connect or lost the connection.
+var connectErrors = []string{
+	"can't connect",
+	"lost connection",
+	"unknown mysql server host",
+	"server has gone away",
+	"got error: 2002",
+	"got error: 2003",
+	"got error: 2005",
+	"got error: 2006",
+	"got error: 2013",
+}
+
+// failureOf returns why stat failed: connect, timeout, canceled, or error. It's empty if stat
+// succeeded.
+func failureOf(stat Stat) string {
+	switch {
+	case stat.Success:
+		return ""
+	case errors.Is(stat.Error, context.Canceled):
+		return failureCanceled
+	case errors.Is(stat.Error, context.DeadlineExceeded):
+		return FailureTimeout
+	}
+
+	text := strings.ToLower(stat.ErrorMessage + "\n" + strings.Join(stat.Stderr, "\n"))
+	for _, connectError := range connectErrors {
+		if strings.Contains(text, connectError) {
+			return FailureConnect
+		}
 	}
+	return FailureError
 }
@@ -0,0 +1,305 @@
+package repbak
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats stats can be exported and imported as.
+const (
+	ExportJSONL = "jsonl"
+	ExportCSV   = "csv"
+)
+
+// statCSVHeader are the columns of exported CSV stats.
+var statCSVHeader = []string{
+	"run_id",
+	"name",
+	"success",
+	"skip",
+	"start",
+	"end",
+	"duration_seconds",
+	"error",
+	"exit_code",
+	"signal",
+	"log",
+	"artifacts",
+	"bytes_written",
+	"compressed_size",
+	"checksum",
+	"host",
+	"version",
+	"trigger",
+}
+
+// ExportOptions filters and formats exported stats.
+type ExportOptions struct {
+	// Format is jsonl or csv.
+	Format string
+
+	// Job only exports the stats of the named job if set.
+	Job string
+
+	// From only exports stats that started at or after From if set.
+	From time.Time
+
+	// To only exports stats that started before To if set.
+	To time.Time
+}
+
+// ExportStats writes the stats in db matching opts to w sorted by job and start time. The number of
+// exported stats is returned.
+func ExportStats(db DB, w io.Writer, opts ExportOptions) (int, error) {
+	statMap, err := db.List()
+	if err != nil {
+		return 0, err
+	}
+
+	var stats []Stat
+	for name, jobStats := range statMap {
+		if opts.Job != "" && name != opts.Job {
+			continue
+		}
+
+		for _, stat := range jobStats {
+			if !opts.From.IsZero() && stat.Start.Before(opts.From) {
+				continue
+			}
+			if !opts.To.IsZero() && !stat.Start.Before(opts.To) {
+				continue
+			}
+			stats = append(stats, stat)
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Name != stats[j].Name {
+			return stats[i].Name < stats[j].Name
+		}
+		return stats[i].Start.Before(stats[j].Start)
+	})
+
+	switch opts.Format {
+	case ExportJSONL:
+		encoder := json.NewEncoder(w)
+		for _, stat := range stats {
+			if err := encoder.Encode(stat); err != nil {
+				return 0, fmt.Errorf("Export: failed to write stat: %w", err)
+			}
+		}
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(statCSVHeader)
+		for _, stat := range stats {
+			writer.Write(statCSVRecord(stat))
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return 0, fmt.Errorf("Export: failed to write stat: %w", err)
+		}
+	default:
+		return 0, fmt.Errorf("Export: invalid format: %s", opts.Format)
+	}
+
+	return len(stats), nil
+}
+
+// ImportStats reads stats in the format from r and inserts them into db. Nothing is inserted if a
+// stat is invalid. Stats beyond the retention of db are pruned once they're all inserted. The paths
+// of run logs and artifacts aren't imported. The number of imported stats is returned.
+func ImportStats(db DB, r io.Reader, format string) (int, error) {
+	var stats []Stat
+
+	switch format {
+	case ExportJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+
+			stat := Stat{}
+			if err := json.Unmarshal(scanner.Bytes(), &stat); err != nil {
+				return 0, fmt.Errorf("Import: failed to read stat on line %d: %w", line, err)
+			}
+			stats = append(stats, stat)
+		}
+		if err := scanner.Err(); err != nil {
+			return 0, fmt.Errorf("Import: failed to read stats: %w", err)
+		}
+	case ExportCSV:
+		records, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return 0, fmt.Errorf("Import: failed to read stats: %w", err)
+		}
+
+		if len(records) == 0 {
+			return 0, nil
+		}
+
+		columns := make(map[string]int)
+		for i, column := range records[0] {
+			columns[column] = i
+		}
+
+		for i, record := range records[1:] {
+			stat, err := parseStatCSVRecord(columns, record)
+			if err != nil {
+				return 0, fmt.Errorf("Import: failed to read stat on line %d: %w", i+2, err)
+			}
+			stats = append(stats, stat)
+		}
+	default:
+		return 0, fmt.Errorf("Import: invalid format: %s", format)
+	}
+
+	for i, stat := range stats {
+		if stat.Name == "" || stat.Start.IsZero() {
+			return 0, fmt.Errorf("Import: stat %d is missing a name or start", i+1)
+		}
+
+		if stat.SchemaVersion == 0 {
+			stat.SchemaVersion = statSchemaVersion
+		}
+
+		// the run logs and artifacts of another host aren't here and their paths can't be trusted
+		stat.Log = ""
+		stat.Artifacts = nil
+
+		stats[i] = stat
+	}
+
+	if err := db.InsertBatch(stats); err != nil {
+		return 0, err
+	}
+
+	return len(stats), nil
+}
+
+// statCSVRecord returns the CSV columns of stat in the order of statCSVHeader.
+func statCSVRecord(stat Stat) []string {
+	return []string{
+		stat.RunID,
+		stat.Name,
+		strconv.FormatBool(stat.Success),
+		strconv.FormatBool(stat.Skip),
+		formatExportTime(stat.Start),
+		formatExportTime(stat.End),
+		strconv.FormatFloat(stat.Duration.Seconds(), 'f', -1, 64),
+		stat.ErrorMessage,
+		strconv.Itoa(stat.ExitCode),
+		stat.Signal,
+		stat.Log,
+		strings.Join(stat.Artifacts, ";"),
+		strconv.FormatInt(stat.BytesWritten, 10),
+		strconv.FormatInt(stat.CompressedSize, 10),
+		stat.Checksum,
+		stat.Host,
+		stat.Version,
+		stat.Trigger,
+	}
+}
+
+// parseStatCSVRecord parses a stat from a CSV record whose columns are indexed by name. Missing
+// columns are left empty.
+func parseStatCSVRecord(columns map[string]int, record []string) (Stat, error) {
+	value := func(column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return record[i]
+	}
+
+	var errs []error
+	parseBool := func(column string) bool {
+		if value(column) == "" {
+			return false
+		}
+		b, err := strconv.ParseBool(value(column))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", column, err))
+		}
+		return b
+	}
+	parseInt := func(column string) int64 {
+		if value(column) == "" {
+			return 0
+		}
+		n, err := strconv.ParseInt(value(column), 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", column, err))
+		}
+		return n
+	}
+	parseTime := func(column string) time.Time {
+		if value(column) == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339Nano, value(column))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", column, err))
+		}
+		return t
+	}
+
+	stat := Stat{
+		SchemaVersion:  statSchemaVersion,
+		RunID:          value("run_id"),
+		Name:           value("name"),
+		Success:        parseBool("success"),
+		Skip:           parseBool("skip"),
+		Start:          parseTime("start"),
+		End:            parseTime("end"),
+		ErrorMessage:   value("error"),
+		ExitCode:       int(parseInt("exit_code")),
+		Signal:         value("signal"),
+		Log:            value("log"),
+		BytesWritten:   parseInt("bytes_written"),
+		CompressedSize: parseInt("compressed_size"),
+		Checksum:       value("checksum"),
+		Host:           value("host"),
+		Version:        value("version"),
+		Trigger:        value("trigger"),
+	}
+
+	if value("duration_seconds") != "" {
+		seconds, err := strconv.ParseFloat(value("duration_seconds"), 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid duration_seconds: %w", err))
+		}
+		stat.Duration = time.Duration(math.Round(seconds * float64(time.Second)))
+	}
+
+	if value("artifacts") != "" {
+		stat.Artifacts = strings.Split(value("artifacts"), ";")
+	}
+
+	if stat.ErrorMessage != "" {
+		stat.Error = errors.New(stat.ErrorMessage)
+	}
+
+	if len(errs) > 0 {
+		return stat, errs[0]
+	}
+	return stat, nil
+}
+
+// formatExportTime formats t as RFC 3339 or an empty string if t is zero.
+func formatExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -0,0 +1,139 @@
+package repbak
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newExportTestDB(t *testing.T, dir string) *BoltDB {
+	db, err := NewBoltDB(&Config{
+		LibPath:   dir,
+		Retention: 10,
+	})
+	assert.Nil(t, err)
+	return db
+}
+
+func TestExportImportStats(t *testing.T) {
+	for _, format := range []string{ExportJSONL, ExportCSV} {
+		src, err := os.MkdirTemp("", "repbak_test")
+		assert.Nil(t, err)
+		defer os.RemoveAll(src)
+
+		dst, err := os.MkdirTemp("", "repbak_test")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dst)
+
+		srcDB := newExportTestDB(t, src)
+		defer srcDB.Close()
+
+		start := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+		for i := 0; i < 3; i++ {
+			for _, name := range []string{"TEST", "TEST2"} {
+				stat := NewStat(name)
+				stat.Start = start.Add(time.Duration(i) * time.Hour)
+				stat.Artifacts = []string{"/backups/a.dump", "/backups/b.dump"}
+				stat.Log = "/var/lib/repbak/logs/TEST/run.log"
+				stat.BytesWritten = 1024
+
+				var err error
+				if i == 1 {
+					err = errors.New("ERROR, with a comma")
+				}
+				assert.Nil(t, srcDB.Insert(stat.Finish(err)))
+			}
+		}
+
+		var b bytes.Buffer
+		n, err := ExportStats(srcDB, &b, ExportOptions{
+			Format: format,
+			Job:    "TEST",
+			From:   start.Add(time.Hour),
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, n, 2)
+
+		dstDB := newExportTestDB(t, dst)
+		defer dstDB.Close()
+
+		n, err = ImportStats(dstDB, &b, format)
+		assert.Nil(t, err)
+		assert.Equal(t, n, 2)
+
+		exported, err := srcDB.List()
+		assert.Nil(t, err)
+
+		imported, err := dstDB.List()
+		assert.Nil(t, err)
+		assert.Len(t, imported, 1)
+		assert.Len(t, imported["TEST"], 2)
+
+		for i, stat := range imported["TEST"] {
+			expected := exported["TEST"][i]
+			assert.Equal(t, stat.RunID, expected.RunID)
+			assert.True(t, stat.Start.Equal(expected.Start))
+			assert.True(t, stat.End.Equal(expected.End))
+			assert.Equal(t, stat.Duration, expected.Duration)
+			assert.Equal(t, stat.Success, expected.Success)
+			assert.Equal(t, stat.ErrorMessage, expected.ErrorMessage)
+			assert.Len(t, stat.Artifacts, 0)
+			assert.Equal(t, stat.Log, "")
+			assert.Equal(t, stat.BytesWritten, expected.BytesWritten)
+			assert.Equal(t, stat.Host, expected.Host)
+		}
+		assert.EqualError(t, imported["TEST"][1].Error, "ERROR, with a comma")
+	}
+}
+
+func TestExportStatsTo(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := newExportTestDB(t, dir)
+	defer db.Close()
+
+	stat := NewStat("TEST")
+	assert.Nil(t, db.Insert(stat.Finish(nil)))
+
+	var b bytes.Buffer
+	n, err := ExportStats(db, &b, ExportOptions{Format: ExportCSV, To: stat.Start})
+	assert.Nil(t, err)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, b.String(), strings.Join(statCSVHeader, ",")+"\n")
+
+	_, err = ExportStats(db, &b, ExportOptions{Format: "xml"})
+	assert.Error(t, err)
+}
+
+func TestImportStatsInvalid(t *testing.T) {
+	dir, err := os.MkdirTemp("", "repbak_test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	db := newExportTestDB(t, dir)
+	defer db.Close()
+
+	_, err = ImportStats(db, strings.NewReader("name,start,success\nTEST,2022-12-06T00:00:00Z,maybe\n"), ExportCSV)
+	assert.Error(t, err)
+
+	_, err = ImportStats(db, strings.NewReader("{\"Name\":\"TEST\"}\n"), ExportJSONL)
+	assert.Error(t, err)
+
+	_, err = ImportStats(db, strings.NewReader("not json\n"), ExportJSONL)
+	assert.Error(t, err)
+
+	_, err = ImportStats(db, strings.NewReader(""), "xml")
+	assert.Error(t, err)
+
+	// partial CSV files only need the name and start
+	n, err := ImportStats(db, strings.NewReader("name,start\nTEST,2022-12-06T00:00:00Z\n"), ExportCSV)
+	assert.Nil(t, err)
+	assert.Equal(t, n, 1)
+}
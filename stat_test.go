@@ -1,7 +1,10 @@
 package repbak
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"os/exec"
 	"testing"
 	"time"
 
@@ -9,26 +12,26 @@ import (
 )
 
 func TestStat(t *testing.T) {
-	stat := NewStat("SUCCESS", "Mon Jan 02 03:04:05 PM MST")
+	stat := NewStat("SUCCESS")
 	assert.Equal(t, stat.Name, "SUCCESS")
 	assert.False(t, stat.Success)
-	assert.Equal(t, stat.End, "")
+	assert.True(t, stat.End.IsZero())
 	assert.Equal(t, stat.Duration, time.Duration(0))
 	assert.Equal(t, stat.Skip, false)
 
 	stat = stat.Finish(nil)
 	assert.Equal(t, stat.Name, "SUCCESS")
 	assert.True(t, stat.Success)
-	assert.NotEqual(t, stat.End, "")
+	assert.False(t, stat.End.Before(stat.Start))
 	assert.NotEqual(t, stat.Duration, time.Duration(0))
 	assert.Equal(t, stat.Skip, false)
 }
 
 func TestErrorStat(t *testing.T) {
-	stat := NewStat("FAIL", "Mon Jan 02 03:04:05 PM MST")
+	stat := NewStat("FAIL")
 	assert.Equal(t, stat.Name, "FAIL")
 	assert.False(t, stat.Success)
-	assert.Equal(t, stat.End, "")
+	assert.True(t, stat.End.IsZero())
 	assert.Equal(t, stat.Duration, time.Duration(0))
 	assert.Equal(t, stat.Skip, false)
 
@@ -36,8 +39,112 @@ func TestErrorStat(t *testing.T) {
 	stat = stat.Finish(err)
 	assert.Equal(t, stat.Name, "FAIL")
 	assert.False(t, stat.Success)
-	assert.NotEqual(t, stat.End, "")
+	assert.False(t, stat.End.Before(stat.Start))
 	assert.NotEqual(t, stat.Duration, time.Duration(0))
 	assert.Equal(t, stat.Skip, false)
 	assert.Equal(t, stat.Error, err)
 }
+
+func TestStatMetadata(t *testing.T) {
+	stat := NewStat("TEST")
+	assert.Equal(t, stat.SchemaVersion, statSchemaVersion)
+	assert.Len(t, stat.RunID, 32)
+	assert.NotEqual(t, stat.Host, "")
+	assert.Equal(t, stat.Version, Version)
+	assert.NotEqual(t, stat.RunID, NewStat("TEST").RunID)
+}
+
+func TestStatJSON(t *testing.T) {
+	stat := NewStat("FAIL").Finish(errors.New("fail"))
+	assert.Equal(t, stat.ExitCode, -1)
+
+	data, err := json.Marshal(stat)
+	assert.Nil(t, err)
+
+	var decoded Stat
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, decoded.RunID, stat.RunID)
+	assert.True(t, decoded.Start.Equal(stat.Start))
+	assert.True(t, decoded.End.Equal(stat.End))
+	assert.Equal(t, decoded.ErrorMessage, "fail")
+	assert.EqualError(t, decoded.Error, "fail")
+	assert.False(t, decoded.Success)
+}
+
+func TestExitStatus(t *testing.T) {
+	code, signal := exitStatus(nil)
+	assert.Equal(t, code, 0)
+	assert.Equal(t, signal, "")
+
+	code, signal = exitStatus(exec.Command("sh", "-c", "exit 3").Run())
+	assert.Equal(t, code, 3)
+	assert.Equal(t, signal, "")
+
+	code, signal = exitStatus(exec.Command("sh", "-c", "kill -9 $$").Run())
+	assert.Equal(t, code, -1)
+	assert.Equal(t, signal, "killed")
+}
+
+func TestStatLegacyJSON(t *testing.T) {
+	var stat Stat
+	assert.Nil(t, json.Unmarshal([]byte(`{"Name":"TEST","Start":"Tue Dec 06 12:00:00 AM EST","End":""}`), &stat))
+	assert.Equal(t, stat.Name, "TEST")
+	assert.True(t, stat.Start.IsZero())
+	assert.True(t, stat.End.IsZero())
+}
+
+func TestAggregateStats(t *testing.T) {
+	aggregate := AggregateStats("TEST", nil)
+	assert.Equal(t, aggregate.Name, "TEST")
+	assert.Equal(t, aggregate.Runs, 0)
+	assert.Equal(t, aggregate.SuccessRate, float64(0))
+
+	var stats []Stat
+	for i := 1; i <= 20; i++ {
+		stats = append(stats, Stat{
+			Success:      i%4 != 0,
+			Duration:     time.Duration(i) * time.Second,
+			BytesWritten: 10,
+		})
+	}
+
+	aggregate = AggregateStats("TEST", stats)
+	assert.Equal(t, aggregate.Runs, 20)
+	assert.Equal(t, aggregate.Successes, 15)
+	assert.Equal(t, aggregate.Failures, 5)
+	assert.Equal(t, aggregate.SuccessRate, 0.75)
+	assert.Equal(t, aggregate.MeanDuration, 10500*time.Millisecond)
+	assert.Equal(t, aggregate.P95Duration, 19*time.Second)
+	assert.Equal(t, aggregate.TotalBytes, int64(200))
+
+	// skipped backups aren't runs
+	aggregate = AggregateStats("TEST", append(stats, Stat{Skip: true}))
+	assert.Equal(t, aggregate.Runs, 20)
+	assert.Equal(t, aggregate.Skips, 1)
+	assert.Equal(t, aggregate.SuccessRate, 0.75)
+
+	// neither are retried attempts
+	aggregate = AggregateStats("TEST", append(stats, Stat{Retried: true}))
+	assert.Equal(t, aggregate.Runs, 20)
+	assert.Equal(t, aggregate.Retries, 1)
+	assert.Equal(t, aggregate.Failures, 5)
+}
+
+func TestFailureOf(t *testing.T) {
+	stat := NewStat("TEST").Finish(nil)
+	assert.Equal(t, failureOf(stat), "")
+
+	stat = NewStat("TEST").Finish(&stoppedError{reason: context.DeadlineExceeded, err: errors.New("signal: killed")})
+	assert.Equal(t, failureOf(stat), FailureTimeout)
+	assert.Contains(t, stat.ErrorMessage, "time limit")
+
+	stat = NewStat("TEST").Finish(&stoppedError{reason: context.Canceled, err: errors.New("signal: killed")})
+	assert.Equal(t, failureOf(stat), failureCanceled)
+
+	stat = NewStat("TEST").Finish(errors.New("exit status 2"))
+	stat.Stderr = []string{"mysqldump: Got error: 2002: Can't connect to local MySQL server through socket"}
+	assert.Equal(t, failureOf(stat), FailureConnect)
+
+	stat.Stderr = []string{"mysqldump: Couldn't execute 'SHOW TABLES'"}
+	assert.Equal(t, failureOf(stat), FailureError)
+}
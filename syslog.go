@@ -0,0 +1,245 @@
+package repbak
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// syslog severities from RFC 5424
+const (
+	syslogCrit    = 2
+	syslogErr     = 3
+	syslogWarning = 4
+	syslogNotice  = 5
+	syslogInfo    = 6
+	syslogDebug   = 7
+)
+
+// syslogFacilities maps facility names to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// syslogSockets are the local syslog unix sockets tried when no network is configured.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogSDID is the structured data ID used for repbak fields. 32473 is the private enterprise
+// number reserved for documentation in RFC 5612.
+const syslogSDID = "repbak@32473"
+
+// syslogWriter writes RFC 5424 messages to a local or remote syslog server.
+type syslogWriter struct {
+	network  string
+	addr     string
+	tag      string
+	facility int
+	hostname string
+	mu       sync.Mutex
+	conn     net.Conn
+}
+
+func newSyslogWriter(config *Syslog) *syslogWriter {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+
+	return &syslogWriter{
+		network:  config.Network,
+		addr:     config.Addr,
+		tag:      config.Tag,
+		facility: config.facility,
+		hostname: hostname,
+	}
+}
+
+// write sends msg with severity and the structured data fields. If the connection fails it is
+// reconnected once before giving up.
+func (w *syslogWriter) write(severity int, msgID string, msg string, fields map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	message := w.format(severity, msgID, msg, fields)
+
+	for i := 0; i < 2; i++ {
+		if w.conn == nil {
+			if err := w.connect(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := w.conn.Write(message); err == nil {
+			return nil
+		}
+
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return fmt.Errorf("Syslog: failed to write message to %s %s", w.network, w.addr)
+}
+
+func (w *syslogWriter) connect() error {
+	if w.network != "" {
+		conn, err := net.DialTimeout(w.network, w.addr, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("Syslog: failed to connect to %s %s: %w", w.network, w.addr, err)
+		}
+		w.conn = conn
+		return nil
+	}
+
+	for _, socket := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.Dial(network, socket)
+			if err == nil {
+				w.conn = conn
+				return nil
+			}
+		}
+	}
+
+	return errors.New("Syslog: failed to connect to the local syslog socket")
+}
+
+// format builds an RFC 5424 message. Stream connections use octet counting framing from RFC 6587.
+func (w *syslogWriter) format(severity int, msgID string, msg string, fields map[string]string) []byte {
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	sd := "-"
+	if len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var b strings.Builder
+		b.WriteString("[" + syslogSDID)
+		for _, key := range keys {
+			fmt.Fprintf(&b, ` %s="%s"`, key, syslogEscaper.Replace(fields[key]))
+		}
+		b.WriteString("]")
+		sd = b.String()
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		w.facility*8+severity,
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname,
+		w.tag,
+		os.Getpid(),
+		msgID,
+		sd,
+		msg,
+	)
+
+	if w.network == "tcp" || w.network == "tcp4" || w.network == "tcp6" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	return []byte(message)
+}
+
+// Close closes the connection to syslog.
+func (w *syslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// syslogEscaper escapes structured data param values as defined by RFC 5424.
+var syslogEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// SyslogHook is a logrus hook that writes repbak's log to syslog.
+type SyslogHook struct {
+	writer *syslogWriter
+}
+
+// NewSyslogHook creates a SyslogHook using the syslog configuration. If the syslog configuration
+// isn't set then the local syslog socket is used.
+func NewSyslogHook(config *Config) *SyslogHook {
+	syslog := config.Syslog
+	if syslog == nil {
+		syslog = &Syslog{
+			Tag:      "repbak",
+			facility: syslogFacilities["daemon"],
+		}
+	}
+
+	return &SyslogHook{
+		writer: newSyslogWriter(syslog),
+	}
+}
+
+// Levels returns all log levels.
+func (h *SyslogHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire writes the log entry to syslog.
+func (h *SyslogHook) Fire(entry *log.Entry) error {
+	fields := make(map[string]string, len(entry.Data))
+	for key, value := range entry.Data {
+		fields[key] = fmt.Sprint(value)
+	}
+
+	return h.writer.write(syslogSeverity(entry.Level), "", entry.Message, fields)
+}
+
+// Close closes the connection to syslog.
+func (h *SyslogHook) Close() error {
+	return h.writer.Close()
+}
+
+// syslogSeverity maps logrus levels to syslog severities.
+func syslogSeverity(level log.Level) int {
+	switch level {
+	case log.PanicLevel, log.FatalLevel:
+		return syslogCrit
+	case log.ErrorLevel:
+		return syslogErr
+	case log.WarnLevel:
+		return syslogWarning
+	case log.InfoLevel:
+		return syslogInfo
+	default:
+		return syslogDebug
+	}
+}
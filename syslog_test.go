@@ -0,0 +1,120 @@
+package repbak
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	writer := newSyslogWriter(&Syslog{
+		Network:  "udp",
+		Addr:     conn.LocalAddr().String(),
+		Tag:      "repbak",
+		facility: syslogFacilities["local0"],
+	})
+	defer writer.Close()
+
+	err = writer.write(syslogErr, "backup", "Backup failed", map[string]string{
+		"job":   "mysqldump",
+		"error": `bad "quote"]`,
+	})
+	assert.Nil(t, err)
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<131>1 "))
+	assert.Contains(t, msg, " repbak ")
+	assert.Contains(t, msg, " backup ")
+	assert.Contains(t, msg, `[repbak@32473 error="bad \"quote\"\]" job="mysqldump"]`)
+	assert.True(t, strings.HasSuffix(msg, "Backup failed"))
+}
+
+func TestSyslogWriterTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	msgc := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		line, _ := bufio.NewReader(conn).ReadString(']')
+		msgc <- line
+	}()
+
+	writer := newSyslogWriter(&Syslog{
+		Network:  "tcp",
+		Addr:     listener.Addr().String(),
+		Tag:      "repbak",
+		facility: syslogFacilities["daemon"],
+	})
+	defer writer.Close()
+
+	err = writer.write(syslogInfo, "", "hello", map[string]string{"job": "mysqldump"})
+	assert.Nil(t, err)
+
+	msg := <-msgc
+	length, rest, found := strings.Cut(msg, " ")
+	assert.True(t, found)
+	assert.NotEqual(t, length, "")
+	assert.True(t, strings.HasPrefix(rest, "<30>1 "))
+}
+
+func TestSyslogWriterConnectError(t *testing.T) {
+	writer := newSyslogWriter(&Syslog{
+		Network: "unix",
+		Addr:    "/nonexistent/syslog.sock",
+	})
+
+	err := writer.write(syslogInfo, "", "hello", nil)
+	assert.Error(t, err)
+}
+
+func TestSyslogHook(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	config := &Config{
+		Syslog: &Syslog{
+			Network:  "udp",
+			Addr:     conn.LocalAddr().String(),
+			Tag:      "repbak",
+			facility: syslogFacilities["daemon"],
+		},
+	}
+
+	hook := NewSyslogHook(config)
+	defer hook.Close()
+
+	logger := log.New()
+	logger.AddHook(hook)
+	logger.SetOutput(io.Discard)
+	logger.WithField("job", "mysqldump").Warn("hook message")
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<28>1 "))
+	assert.Contains(t, msg, `job="mysqldump"`)
+	assert.True(t, strings.HasSuffix(msg, "hook message"))
+}
@@ -0,0 +1,5 @@
+package repbak
+
+// Version is the version of repbak recorded with each stat. It's set at build time with
+// -ldflags "-X github.com/agorman/repbak.Version=v1.0.0".
+var Version = "dev"